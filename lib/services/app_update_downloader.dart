@@ -4,6 +4,7 @@ import 'package:dio/dio.dart';
 import 'package:flutter/foundation.dart';
 import 'package:ota_update/ota_update.dart';
 
+import 'update_manifest_verifier.dart';
 import 'update_service.dart';
 
 class AppUpdateProgress {
@@ -150,6 +151,17 @@ class AppUpdateDownloader {
     }
     cancelToken?.throwIfCanceled();
 
+    if (UpdateManifestVerifier.instance.isEnabled) {
+      if (updateResult.manifest == null) {
+        throw StateError('更新信息未通过签名校验，已停止下载');
+      }
+      // 开启校验时不安装无法核对摘要的文件
+      final sha256 = updateResult.sha256;
+      if (sha256 == null || sha256.isEmpty) {
+        throw StateError('更新清单缺少安装包校验值，已停止下载');
+      }
+    }
+
     final directApkUrl = _resolveAndroidApkUrl(updateResult);
     if (directApkUrl == null) {
       throw StateError('未找到可用的 Android APK 下载地址');
@@ -157,7 +169,10 @@ class AppUpdateDownloader {
 
     final candidates = _buildCandidateUrls(directApkUrl);
     onProgress(const AppUpdateProgress(message: '正在测速下载源...'));
-    final ranked = await _rankCandidates(candidates, cancelToken: cancelToken);
+    final ranked = filterCandidatesBySize(
+      await _rankCandidates(candidates, cancelToken: cancelToken),
+      updateResult.size,
+    );
     cancelToken?.throwIfCanceled();
     if (ranked.isEmpty) {
       throw StateError('未找到可用的下载源');
@@ -185,7 +200,8 @@ class AppUpdateDownloader {
         await _executeOta(
           url: candidate.url,
           version: updateResult.latestVersion,
-          totalBytes: candidate.contentLength,
+          sha256: updateResult.sha256,
+          totalBytes: candidate.contentLength ?? updateResult.size,
           onProgress: onProgress,
           cancelToken: cancelToken,
         );
@@ -209,6 +225,12 @@ class AppUpdateDownloader {
   }
 
   String? _resolveAndroidApkUrl(UpdateCheckResult updateResult) {
+    // 签名清单中的地址是唯一可信来源，不再推断
+    final manifest = updateResult.manifest;
+    if (manifest != null) {
+      return manifest.format == 'apk' ? manifest.downloadUrl : null;
+    }
+
     if (updateResult.androidDownloadUrl != null &&
         updateResult.androidDownloadUrl!.isNotEmpty) {
       return updateResult.androidDownloadUrl;
@@ -326,6 +348,24 @@ class AppUpdateDownloader {
     return ranked.map((item) => item.source).toList();
   }
 
+  /// 剔除文件大小与发布信息不一致的下载源（镜像内容可能被篡改或已过期）
+  @visibleForTesting
+  List<RankedMirrorSource> filterCandidatesBySize(
+    List<RankedMirrorSource> ranked,
+    int? expectedSize,
+  ) {
+    if (expectedSize == null || expectedSize <= 0) {
+      return ranked;
+    }
+    return ranked
+        .where(
+          (source) =>
+              source.contentLength == null ||
+              source.contentLength == expectedSize,
+        )
+        .toList();
+  }
+
   Future<MirrorProbeResult> _probeUrl(
     String url, {
     AppUpdateCancelToken? appCancelToken,
@@ -434,6 +474,7 @@ class AppUpdateDownloader {
   Future<void> _executeOta({
     required String url,
     required String? version,
+    required String? sha256,
     required int? totalBytes,
     required ValueChanged<AppUpdateProgress> onProgress,
     AppUpdateCancelToken? cancelToken,
@@ -456,8 +497,13 @@ class AppUpdateDownloader {
       }
     });
 
+    // ota_update 在安装前校验 SHA-256，不匹配时上报 CHECKSUM_ERROR
     sub = otaUpdate
-        .execute(url, destinationFilename: destinationFilename)
+        .execute(
+          url,
+          destinationFilename: destinationFilename,
+          sha256checksum: sha256 == null || sha256.isEmpty ? null : sha256,
+        )
         .listen(
           (event) {
             if (cancelToken?.isCanceled ?? false) {
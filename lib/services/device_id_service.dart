@@ -1,4 +1,6 @@
+import 'dart:convert';
 import 'dart:io';
+import 'dart:math';
 import 'package:device_info_plus/device_info_plus.dart';
 import 'storage/storage_service.dart';
 import 'package:uuid/uuid.dart';
@@ -11,6 +13,7 @@ class DeviceIdService {
   DeviceIdService._();
 
   String? _cachedDeviceId;
+  String? _cachedDeviceSecret;
 
   /// 获取设备ID，如果不存在则生成一个新的
   Future<String> getDeviceId() async {
@@ -41,6 +44,34 @@ class DeviceIdService {
     }
   }
 
+  /// 获取设备密钥，如果不存在则生成一个新的
+  ///
+  /// 密钥随检查更新发送，服务端登记首次收到的密钥，之后只向持有它的设备发放自助令牌。
+  /// 安全存储不可用时返回 null，不发送密钥
+  Future<String?> getDeviceSecret() async {
+    if (_cachedDeviceSecret != null) {
+      return _cachedDeviceSecret!;
+    }
+
+    try {
+      final storedSecret = await StorageService.instance.loadDeviceSecret();
+      if (storedSecret != null && storedSecret.isNotEmpty) {
+        _cachedDeviceSecret = storedSecret;
+        return storedSecret;
+      }
+
+      final random = Random.secure();
+      final bytes = List<int>.generate(32, (_) => random.nextInt(256));
+      final newSecret = base64Url.encode(bytes).replaceAll('=', '');
+      await StorageService.instance.saveDeviceSecret(newSecret);
+
+      _cachedDeviceSecret = newSecret;
+      return newSecret;
+    } catch (e) {
+      return null;
+    }
+  }
+
   /// 生成新的设备ID
   Future<String> _generateDeviceId() async {
     const uuid = Uuid();
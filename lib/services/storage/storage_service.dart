@@ -81,6 +81,9 @@ class StorageKeys {
   static const String deviceId = 'device_id';
   // 非安全存储的降级 Key（例如 Linux 桌面端 keyring 被锁定时）
   static const String deviceIdFallback = 'device_id.fallback';
+  // 设备密钥：首次检查更新时在服务端登记，凭它领取设备自助令牌
+  static const String deviceSecret = 'device_secret';
+  static const String deviceSecretFallback = 'device_secret.fallback';
 
   // 主题相关
   static const String themeMode = 'theme.mode'; // system | light | dark
@@ -2085,6 +2088,9 @@ class StorageService {
       return StorageKeys.proxyPasswordFallback;
     }
     if (logicalKey == StorageKeys.deviceId) return StorageKeys.deviceIdFallback;
+    if (logicalKey == StorageKeys.deviceSecret) {
+      return StorageKeys.deviceSecretFallback;
+    }
     if (logicalKey == StorageKeys.cookieCloudSecretsV2) {
       return StorageKeys.cookieCloudSecretsV2Fallback;
     }
@@ -2282,6 +2288,7 @@ class StorageService {
         key == StorageKeys.legacySiteApiKeyFallback ||
         key == StorageKeys.proxyPasswordFallback ||
         key == StorageKeys.deviceIdFallback ||
+        key == StorageKeys.deviceSecretFallback ||
         key == StorageKeys.cookieCloudUrl ||
         key == StorageKeys.cookieCloudUrlFallback ||
         key == StorageKeys.cookieCloudUuid ||
@@ -2501,6 +2508,9 @@ class StorageService {
     if (prefs.containsKey(StorageKeys.deviceIdFallback)) {
       await loadDeviceId();
     }
+    if (prefs.containsKey(StorageKeys.deviceSecretFallback)) {
+      await loadDeviceSecret();
+    }
 
     await _migrateEmbeddedDownloaderPasswords();
 
@@ -4929,6 +4939,28 @@ class StorageService {
     ),
   );
 
+  // 设备密钥读写（与设备ID相同的安全存储与降级策略）
+  Future<void> saveDeviceSecret(String secret) =>
+      _runInCurrentSecureStorageOperationEpoch(
+        () => _runSensitiveStorageOperation(
+          () => _saveSecureWithFallback(
+            key: StorageKeys.deviceSecret,
+            fallbackKey: StorageKeys.deviceSecretFallback,
+            value: secret,
+          ),
+        ),
+      );
+
+  Future<String?> loadDeviceSecret() =>
+      _runInCurrentSecureStorageOperationEpoch(
+        () => _runSensitiveStorageOperation(
+          () => _loadSecureWithFallback(
+            key: StorageKeys.deviceSecret,
+            fallbackKey: StorageKeys.deviceSecretFallback,
+          ),
+        ),
+      );
+
   Future<void> deleteDeviceId() => _runInCurrentSecureStorageOperationEpoch(
     () => _runSensitiveStorageOperation(_deleteDeviceIdInCurrentEpoch),
   );
@@ -0,0 +1,166 @@
+import 'dart:convert';
+import 'dart:typed_data';
+
+import 'package:ed25519_edwards/ed25519_edwards.dart' as ed;
+import 'package:flutter/foundation.dart';
+
+class UpdateManifestException implements Exception {
+  final String message;
+
+  const UpdateManifestException(this.message);
+
+  @override
+  String toString() => '更新清单校验失败：$message';
+}
+
+/// 服务端签名的更新清单，签名校验通过后才可信
+@immutable
+class UpdateManifest {
+  final String version;
+  final String platform;
+  final String? arch;
+  final String? format;
+  final String downloadUrl;
+  final int? size;
+  final String? sha256;
+  final bool forceUpdate;
+  final String? minVersion;
+  final DateTime issuedAt;
+
+  const UpdateManifest({
+    required this.version,
+    required this.platform,
+    this.arch,
+    this.format,
+    required this.downloadUrl,
+    this.size,
+    this.sha256,
+    this.forceUpdate = false,
+    this.minVersion,
+    required this.issuedAt,
+  });
+
+  /// 清单签发后的有效期，过期的清单视为重放
+  static const Duration maxAge = Duration(hours: 24);
+
+  /// 允许的本机时钟偏差
+  static const Duration maxClockSkew = Duration(hours: 1);
+
+  static final RegExp _sha256Pattern = RegExp(r'^[0-9a-fA-F]{64}$');
+
+  /// 确认签名有效的清单适用于本次安装：带有安装包的 SHA-256 与大小，
+  /// 平台与本机一致，且签发时间在有效期内，防止重放旧的或其他平台的清单
+  void ensureApplicable({required String platform, DateTime? now}) {
+    final sha256 = this.sha256;
+    if (sha256 == null || !_sha256Pattern.hasMatch(sha256)) {
+      throw const UpdateManifestException('清单缺少安装包校验值');
+    }
+    final size = this.size;
+    if (size == null || size <= 0) {
+      throw const UpdateManifestException('清单缺少安装包大小');
+    }
+    if (this.platform.trim().toLowerCase() != platform.trim().toLowerCase()) {
+      throw const UpdateManifestException('清单平台与本机不一致');
+    }
+    final current = (now ?? DateTime.now()).toUtc();
+    if (issuedAt.isBefore(current.subtract(maxAge)) ||
+        issuedAt.isAfter(current.add(maxClockSkew))) {
+      throw const UpdateManifestException('清单已过期或签发时间无效');
+    }
+  }
+
+  factory UpdateManifest.fromJson(Map<String, dynamic> json) {
+    return UpdateManifest(
+      version: json['version'] as String? ?? '',
+      platform: json['platform'] as String? ?? '',
+      arch: json['arch'] as String?,
+      format: json['format'] as String?,
+      downloadUrl: json['download_url'] as String? ?? '',
+      size: (json['size'] as num?)?.toInt(),
+      sha256: json['sha256'] as String?,
+      forceUpdate: json['force_update'] as bool? ?? false,
+      minVersion: json['min_version'] as String?,
+      issuedAt: DateTime.fromMillisecondsSinceEpoch(
+        ((json['issued_at'] as num?)?.toInt() ?? 0) * 1000,
+        isUtc: true,
+      ),
+    );
+  }
+}
+
+/// 使用内置的 Ed25519 公钥校验更新清单签名。
+///
+/// 公钥在构建时通过 `--dart-define=UPDATE_MANIFEST_PUBLIC_KEYS=<key_id>:<base64>,...`
+/// 注入，可同时信任多把公钥以便轮换；未配置公钥时不做校验。
+class UpdateManifestVerifier {
+  static const String _publicKeysDefine = String.fromEnvironment(
+    'UPDATE_MANIFEST_PUBLIC_KEYS',
+  );
+
+  static final UpdateManifestVerifier instance =
+      UpdateManifestVerifier.fromSpec(_publicKeysDefine);
+
+  final Map<String, Uint8List> trustedKeys;
+
+  UpdateManifestVerifier(this.trustedKeys);
+
+  factory UpdateManifestVerifier.fromSpec(String spec) {
+    final keys = <String, Uint8List>{};
+    for (final entry in spec.split(',')) {
+      final trimmed = entry.trim();
+      final separator = trimmed.lastIndexOf(':');
+      if (separator <= 0) continue;
+      try {
+        final key = base64.decode(trimmed.substring(separator + 1).trim());
+        if (key.length == ed.PublicKeySize) {
+          keys[trimmed.substring(0, separator).trim()] = key;
+        }
+      } on FormatException {
+        // 忽略格式错误的公钥
+      }
+    }
+    return UpdateManifestVerifier(keys);
+  }
+
+  bool get isEnabled => trustedKeys.isNotEmpty;
+
+  /// 校验 `manifest`（base64 编码的清单 JSON）与 `signatures`，
+  /// 任一受信任公钥的签名有效即通过。
+  UpdateManifest verify(String? manifest, Object? signatures) {
+    if (manifest == null || manifest.isEmpty) {
+      throw const UpdateManifestException('缺少更新清单');
+    }
+    if (signatures is! List || signatures.isEmpty) {
+      throw const UpdateManifestException('缺少签名');
+    }
+
+    final Uint8List payload;
+    try {
+      payload = base64.decode(manifest);
+    } on FormatException {
+      throw const UpdateManifestException('清单编码无效');
+    }
+
+    final verified = signatures.whereType<Map>().any((signature) {
+      final key = trustedKeys[signature['key_id']];
+      final encoded = signature['signature'];
+      if (key == null || encoded is! String) {
+        return false;
+      }
+      try {
+        return ed.verify(ed.PublicKey(key), payload, base64.decode(encoded));
+      } catch (_) {
+        return false;
+      }
+    });
+    if (!verified) {
+      throw const UpdateManifestException('签名无效或公钥不受信任');
+    }
+
+    final decoded = jsonDecode(utf8.decode(payload));
+    if (decoded is! Map<String, dynamic>) {
+      throw const UpdateManifestException('清单内容无效');
+    }
+    return UpdateManifest.fromJson(decoded);
+  }
+}
@@ -1,10 +1,13 @@
 import 'dart:convert';
+import 'dart:ui' show PlatformDispatcher;
 import 'package:dio/dio.dart';
 import 'package:flutter/foundation.dart';
 import 'package:logger/logger.dart';
 import 'package:package_info_plus/package_info_plus.dart';
 import 'package:shared_preferences/shared_preferences.dart';
 import 'device_id_service.dart';
+import 'storage/storage_service.dart';
+import 'update_manifest_verifier.dart';
 
 class UpdateService {
   static const String _baseUrl = 'https://ptmate.fly2sky.dpdns.org';
@@ -54,7 +57,17 @@ class UpdateService {
         'platform': platform,
         'app_version': packageInfo.version,
         'is_beta': betaEnabled,
+        // 按系统语言返回更新说明
+        'locale': PlatformDispatcher.instance.locale.toLanguageTag(),
       };
+      final deviceSecret = await DeviceIdService.instance.getDeviceSecret();
+      if (deviceSecret != null) {
+        requestData['device_secret'] = deviceSecret;
+      }
+      final sites = await _reportedSites();
+      if (sites != null) {
+        requestData['sites'] = sites;
+      }
 
       // 发送请求
       Response response = await _dio.post(
@@ -68,7 +81,8 @@ class UpdateService {
 
         // 解析响应
         Map<String, dynamic> responseData = response.data;
-        return UpdateCheckResult.fromJson(responseData);
+        final result = UpdateCheckResult.fromJson(responseData);
+        return _verifyResult(result, responseData, platform);
       }
     } catch (e) {
       if (kDebugMode) {
@@ -80,6 +94,51 @@ class UpdateService {
     return null;
   }
 
+  /// 已配置站点的模板 ID（自定义站点为空）与适配器类型，用于统计站点使用情况。
+  /// 不包含站点地址、用户名或认证信息；读取失败时返回 null，不上报
+  Future<List<Map<String, String>>?> _reportedSites() async {
+    try {
+      final sites = await StorageService.instance.loadSiteConfigs();
+      return sites
+          .map(
+            (site) => {
+              'template_id': site.templateId == '-1' ? '' : site.templateId,
+              'site_type': site.siteType.id,
+            },
+          )
+          .toList();
+    } catch (e) {
+      _logger.w('Failed to load sites for update check: $e');
+      return null;
+    }
+  }
+
+  /// 配置了受信任公钥时，只接受签名有效、适用于本机的更新信息，并以清单中的地址和校验值为准
+  UpdateCheckResult? _verifyResult(
+    UpdateCheckResult result,
+    Map<String, dynamic> responseData,
+    String platform,
+  ) {
+    final verifier = UpdateManifestVerifier.instance;
+    if (!result.hasUpdate || !verifier.isEnabled) {
+      return result;
+    }
+    try {
+      final manifest = verifier.verify(
+        responseData['manifest'] as String?,
+        responseData['signatures'],
+      );
+      if (manifest.version != result.latestVersion) {
+        throw const UpdateManifestException('清单版本与响应不一致');
+      }
+      manifest.ensureApplicable(platform: platform);
+      return result.withManifest(manifest);
+    } on UpdateManifestException catch (e) {
+      _logger.w('Rejected update response: $e');
+      return null;
+    }
+  }
+
   /// 检查是否应该进行更新检查
   Future<bool> _shouldCheckForUpdates() async {
     try {
@@ -195,6 +254,13 @@ class UpdateCheckResult {
   final String? androidDownloadUrl;
   final bool isPreRelease;
 
+  /// 安装包的 SHA-256（十六进制）与字节数，用于校验下载内容
+  final String? sha256;
+  final int? size;
+
+  /// 签名校验通过的更新清单；未启用校验时为空
+  final UpdateManifest? manifest;
+
   UpdateCheckResult({
     required this.hasUpdate,
     this.latestVersion,
@@ -202,10 +268,14 @@ class UpdateCheckResult {
     this.downloadUrl,
     this.androidDownloadUrl,
     this.isPreRelease = false,
+    this.sha256,
+    this.size,
+    this.manifest,
   });
 
   factory UpdateCheckResult.fromJson(Map<String, dynamic> json) {
     final latest = json['latest_version'] as String?;
+    final asset = json['asset'];
     return UpdateCheckResult(
       hasUpdate: json['has_update'] ?? false,
       latestVersion: latest,
@@ -213,6 +283,24 @@ class UpdateCheckResult {
       downloadUrl: json['download_url'],
       androidDownloadUrl: json['android_download_url'],
       isPreRelease: _isPreReleaseVersion(latest),
+      sha256: asset is Map ? asset['sha256'] as String? : null,
+      size: asset is Map ? (asset['size'] as num?)?.toInt() : null,
+    );
+  }
+
+  /// 以签名清单中的下载地址和校验值替换响应中的对应字段
+  UpdateCheckResult withManifest(UpdateManifest manifest) {
+    final isApk = manifest.format == 'apk';
+    return UpdateCheckResult(
+      hasUpdate: hasUpdate,
+      latestVersion: manifest.version,
+      releaseNotes: releaseNotes,
+      downloadUrl: manifest.downloadUrl,
+      androidDownloadUrl: isApk ? manifest.downloadUrl : null,
+      isPreRelease: isPreRelease,
+      sha256: manifest.sha256,
+      size: manifest.size,
+      manifest: manifest,
     );
   }
 
@@ -224,6 +312,8 @@ class UpdateCheckResult {
       'download_url': downloadUrl,
       'android_download_url': androidDownloadUrl,
       'is_pre_release': isPreRelease,
+      'sha256': sha256,
+      'size': size,
     };
   }
 
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// crashGroup is a crash_groups row.
+type crashGroup struct {
+	ID          int64     `json:"id"`
+	Fingerprint string    `json:"fingerprint"`
+	Kind        string    `json:"kind"`
+	ErrorType   string    `json:"error_type"`
+	Message     string    `json:"message"`
+	SiteType    string    `json:"site_type"`
+	Occurrences int64     `json:"occurrences"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// crashGroupItem is a group of the crash list with its affected versions,
+// newest first.
+type crashGroupItem struct {
+	crashGroup
+	Versions []string `json:"versions"`
+}
+
+// crashVersion is a crash_group_versions row.
+type crashVersion struct {
+	AppVersion  string    `json:"app_version"`
+	Platform    string    `json:"platform"`
+	Occurrences int64     `json:"occurrences"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// crashReportRecord is a stored report of a group.
+type crashReportRecord struct {
+	ID         int64     `json:"id"`
+	DeviceID   string    `json:"device_id"`
+	Platform   string    `json:"platform"`
+	AppVersion string    `json:"app_version"`
+	SiteType   string    `json:"site_type"`
+	TemplateID string    `json:"template_id"`
+	Message    string    `json:"message"`
+	StackTrace string    `json:"stack_trace"`
+	LogTail    string    `json:"log_tail"`
+	OccurredAt time.Time `json:"occurred_at"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// crashListQuery applies the filters of the crash list: kind, site_type,
+// platform and version of the affected versions, and q in the error type or
+// message.
+func crashListQuery(db *gorm.DB, c *gin.Context) *gorm.DB {
+	tx := db.Table("crash_groups")
+	if kind := c.Query("kind"); kind != "" {
+		tx = tx.Where("kind = ?", kind)
+	}
+	if siteType := c.Query("site_type"); siteType != "" {
+		tx = tx.Where("site_type = ?", siteType)
+	}
+	if platform := c.Query("platform"); platform != "" {
+		tx = tx.Where("id IN (SELECT group_id FROM crash_group_versions WHERE platform = ?)", platform)
+	}
+	if version := c.Query("version"); version != "" {
+		tx = tx.Where("id IN (SELECT group_id FROM crash_group_versions WHERE app_version = ?)", version)
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		like := "%" + q + "%"
+		tx = tx.Where("(error_type ILIKE ? OR message ILIKE ?)", like, like)
+	}
+	return tx
+}
+
+// loadCrashGroupVersions returns the affected versions of each group, newest
+// first.
+func loadCrashGroupVersions(db *gorm.DB, groupIDs []int64) (map[int64][]string, error) {
+	var rows []struct {
+		GroupID    int64
+		AppVersion string
+	}
+	if err := db.Raw(`SELECT DISTINCT group_id, app_version FROM crash_group_versions WHERE group_id IN ?`, groupIDs).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	versions := make(map[int64][]string, len(groupIDs))
+	for _, r := range rows {
+		versions[r.GroupID] = append(versions[r.GroupID], r.AppVersion)
+	}
+	for _, v := range versions {
+		sort.SliceStable(v, func(i, j int) bool { return compareVersionStrings(v[i], v[j]) > 0 })
+	}
+	return versions, nil
+}
+
+// GET /api/v1/admin/crashes
+// Query: kind, site_type, platform, version, q, sort (last_seen or
+// occurrences), page, pageSize.
+func AdminListCrashes(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+		if pageSize <= 0 || pageSize > 200 {
+			pageSize = 20
+		}
+		if page < 1 {
+			page = 1
+		}
+		order := "last_seen DESC, id DESC"
+		if c.Query("sort") == "occurrences" {
+			order = "occurrences DESC, last_seen DESC, id DESC"
+		}
+
+		var total int64
+		if err := crashListQuery(db, c).Count(&total).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch crashes"})
+			return
+		}
+		var groups []crashGroup
+		if err := crashListQuery(db, c).Order(order).Limit(pageSize).Offset((page - 1) * pageSize).
+			Scan(&groups).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch crashes"})
+			return
+		}
+
+		items := make([]crashGroupItem, 0, len(groups))
+		if len(groups) > 0 {
+			ids := make([]int64, 0, len(groups))
+			for _, g := range groups {
+				ids = append(ids, g.ID)
+			}
+			versions, err := loadCrashGroupVersions(db, ids)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch crashes"})
+				return
+			}
+			for _, g := range groups {
+				item := crashGroupItem{crashGroup: g, Versions: versions[g.ID]}
+				if item.Versions == nil {
+					item.Versions = []string{}
+				}
+				items = append(items, item)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"total": total, "items": items})
+	}
+}
+
+// GET /api/v1/admin/crashes/:id
+// A crash group with the occurrences per affected version and platform, and
+// its latest reports.
+func AdminCrashDetail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "分组 ID 不合法"})
+			return
+		}
+		var groups []crashGroup
+		if err := db.Table("crash_groups").Where("id = ?", id).Limit(1).Scan(&groups).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch crash"})
+			return
+		}
+		if len(groups) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "没有该崩溃分组"})
+			return
+		}
+
+		versions := []crashVersion{}
+		if err := db.Raw(`SELECT app_version, platform, occurrences, first_seen, last_seen
+FROM crash_group_versions
+WHERE group_id = ?`, id).Scan(&versions).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch crash"})
+			return
+		}
+		sort.SliceStable(versions, func(i, j int) bool {
+			if cmp := compareVersionStrings(versions[i].AppVersion, versions[j].AppVersion); cmp != 0 {
+				return cmp > 0
+			}
+			return versions[i].Platform < versions[j].Platform
+		})
+
+		reports := []crashReportRecord{}
+		if err := db.Raw(`SELECT id, device_id, platform, app_version, site_type, template_id, message, stack_trace, log_tail, occurred_at, received_at
+FROM crash_reports
+WHERE group_id = ?
+ORDER BY occurred_at DESC, id DESC`, id).Scan(&reports).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch crash"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"group": groups[0], "versions": versions, "reports": reports})
+	}
+}
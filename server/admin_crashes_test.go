@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var crashGroupColumns = []string{"id", "fingerprint", "kind", "error_type", "message", "site_type", "occurrences", "first_seen", "last_seen"}
+
+func TestAdminListCrashes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	first := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	last := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	filter := `WHERE site_type = $1 AND id IN (SELECT group_id FROM crash_group_versions WHERE app_version = $2)`
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "crash_groups" `+filter)).
+		WithArgs("NexusPHPWeb", "2.30.0").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "crash_groups" `+filter+` ORDER BY occurrences DESC, last_seen DESC, id DESC LIMIT $3`)).
+		WithArgs("NexusPHPWeb", "2.30.0", 20).
+		WillReturnRows(sqlmock.NewRows(crashGroupColumns).
+			AddRow(7, "f1", "error", "RangeError", "RangeError (index): Invalid value: 3", "NexusPHPWeb", 42, first, last))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT DISTINCT group_id, app_version FROM crash_group_versions WHERE group_id IN ($1)`)).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"group_id", "app_version"}).
+			AddRow(7, "2.9.0").
+			AddRow(7, "2.30.0").
+			AddRow(7, "2.30.0-beta.1"))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/crashes?site_type=NexusPHPWeb&version=2.30.0&sort=occurrences", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminListCrashes(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var body struct {
+		Total int64            `json:"total"`
+		Items []crashGroupItem `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, int64(1), body.Total)
+	require.Len(t, body.Items, 1)
+	assert.Equal(t, int64(42), body.Items[0].Occurrences)
+	assert.Equal(t, first, body.Items[0].FirstSeen)
+	assert.Equal(t, last, body.Items[0].LastSeen)
+	assert.Equal(t, []string{"2.30.0", "2.30.0-beta.1", "2.9.0"}, body.Items[0].Versions)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdminCrashDetail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	first := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	last := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "crash_groups" WHERE id = $1 LIMIT $2`)).
+		WithArgs(int64(7), 1).
+		WillReturnRows(sqlmock.NewRows(crashGroupColumns).
+			AddRow(7, "f1", "error", "RangeError", "RangeError (index): Invalid value: 3", "NexusPHPWeb", 3, first, last))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT app_version, platform, occurrences, first_seen, last_seen
+FROM crash_group_versions
+WHERE group_id = $1`)).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"app_version", "platform", "occurrences", "first_seen", "last_seen"}).
+			AddRow("2.29.0", "android", 1, first, first).
+			AddRow("2.30.0", "ios", 1, last, last).
+			AddRow("2.30.0", "android", 1, last, last))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM crash_reports
+WHERE group_id = $1
+ORDER BY occurred_at DESC, id DESC`)).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "device_id", "platform", "app_version", "site_type", "template_id", "message", "stack_trace", "log_tail", "occurred_at", "received_at"}).
+			AddRow(11, "device-1", "ios", "2.30.0", "NexusPHPWeb", "afun", "RangeError (index): Invalid value: 3", "#0 ...", "passkey=[REDACTED]", last, last))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/crashes/7", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Params = gin.Params{{Key: "id", Value: "7"}}
+
+	AdminCrashDetail(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var body struct {
+		Group    crashGroup          `json:"group"`
+		Versions []crashVersion      `json:"versions"`
+		Reports  []crashReportRecord `json:"reports"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "RangeError", body.Group.ErrorType)
+	require.Len(t, body.Versions, 3)
+	assert.Equal(t, crashVersion{AppVersion: "2.30.0", Platform: "android", Occurrences: 1, FirstSeen: last, LastSeen: last}, body.Versions[0])
+	assert.Equal(t, "2.29.0", body.Versions[2].AppVersion)
+	require.Len(t, body.Reports, 1)
+	assert.Equal(t, "afun", body.Reports[0].TemplateID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdminCrashDetailUnknownGroup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "crash_groups" WHERE id = $1 LIMIT $2`)).
+		WithArgs(int64(404), 1).
+		WillReturnRows(sqlmock.NewRows(crashGroupColumns))
+
+	for id, status := range map[string]int{"404": http.StatusNotFound, "abc": http.StatusBadRequest} {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/crashes/"+id, nil)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		c.Params = gin.Params{{Key: "id", Value: id}}
+
+		AdminCrashDetail(db)(c)
+
+		assert.Equal(t, status, w.Code, id)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// exportFlushRows is how many rows are buffered before they are sent.
+const exportFlushRows = 500
+
+// exportFormat reads the format query parameter: csv (default) or ndjson.
+func exportFormat(c *gin.Context) (string, bool) {
+	format := c.DefaultQuery("format", "csv")
+	return format, format == "csv" || format == "ndjson"
+}
+
+// exportWriter streams the rows of an export as CSV, with a header line, or
+// as newline-delimited JSON objects keyed by column.
+type exportWriter struct {
+	c       *gin.Context
+	columns []string
+	csv     *csv.Writer
+	line    bytes.Buffer
+	rows    int
+}
+
+// newExportWriter sends the response headers of an export named name.
+func newExportWriter(c *gin.Context, name, format string, columns []string) (*exportWriter, error) {
+	filename := fmt.Sprintf("%s-%s.%s", name, analyticsZone.Today().Format("20060102"), format)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "no-store")
+	w := &exportWriter{c: c, columns: columns}
+	if format == "ndjson" {
+		c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+		c.Status(http.StatusOK)
+		return w, nil
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	w.csv = csv.NewWriter(c.Writer)
+	return w, w.csv.Write(columns)
+}
+
+// Row writes one row, with a value per column.
+func (w *exportWriter) Row(values ...interface{}) error {
+	if w.csv != nil {
+		record := make([]string, len(values))
+		for i, v := range values {
+			record[i] = csvCell(v)
+		}
+		if err := w.csv.Write(record); err != nil {
+			return err
+		}
+	} else {
+		w.line.Reset()
+		w.line.WriteByte('{')
+		for i, v := range values {
+			if i > 0 {
+				w.line.WriteByte(',')
+			}
+			key, _ := json.Marshal(w.columns[i])
+			value, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			w.line.Write(key)
+			w.line.WriteByte(':')
+			w.line.Write(value)
+		}
+		w.line.WriteString("}\n")
+		if _, err := w.c.Writer.Write(w.line.Bytes()); err != nil {
+			return err
+		}
+	}
+	w.rows++
+	if w.rows%exportFlushRows == 0 {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *exportWriter) flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+// Close sends the buffered rows.
+func (w *exportWriter) Close() error {
+	return w.flush()
+}
+
+// csvCell formats a value for CSV. Text starting like a spreadsheet formula
+// is prefixed with a quote, as device ids and versions come from clients.
+func csvCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case *float64:
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// streamExport writes every row of rows, scanned by scan, and logs the error
+// that ends an export early: the status line has been sent by then.
+func streamExport(w *exportWriter, rows *sql.Rows, scan func(*sql.Rows) ([]interface{}, error)) {
+	defer rows.Close()
+	for rows.Next() {
+		values, err := scan(rows)
+		if err == nil {
+			err = w.Row(values...)
+		}
+		if err != nil {
+			log.Printf("export aborted: %v", err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("export aborted: %v", err)
+		return
+	}
+	if err := w.Close(); err != nil {
+		log.Printf("export aborted: %v", err)
+	}
+}
+
+// writeExport writes rows that are already loaded.
+func writeExport(c *gin.Context, name, format string, columns []string, rows [][]interface{}) {
+	w, err := newExportWriter(c, name, format, columns)
+	for _, row := range rows {
+		if err != nil {
+			break
+		}
+		err = w.Row(row...)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Printf("export aborted: %v", err)
+	}
+}
+
+// GET /api/v1/admin/export/devices
+// Query: format=csv|ndjson and the filters of /stats/devices.
+func AdminExportDevices(db *gorm.DB) gin.HandlerFunc {
+	columns := []string{"device_id", "platform", "app_version", "first_seen", "last_seen", "total_launches", "ip", "country", "region"}
+	return func(c *gin.Context) {
+		format, ok := exportFormat(c)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format 只能为 csv 或 ndjson"})
+			return
+		}
+		tx, err := deviceListQuery(db, c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch version stats"})
+			return
+		}
+		rows, err := tx.Select(strings.Join(columns, ", ")).Order("last_seen DESC").Rows()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export devices"})
+			return
+		}
+		w, err := newExportWriter(c, "devices", format, columns)
+		if err != nil {
+			rows.Close()
+			log.Printf("export aborted: %v", err)
+			return
+		}
+		streamExport(w, rows, func(rows *sql.Rows) ([]interface{}, error) {
+			var d deviceListItem
+			if err := db.ScanRows(rows, &d); err != nil {
+				return nil, err
+			}
+			return []interface{}{d.DeviceID, d.Platform, d.AppVersion, d.FirstSeen, d.LastSeen, d.TotalLaunches, d.IP, d.Country, d.Region}, nil
+		})
+	}
+}
+
+// GET /api/v1/admin/export/activity
+// Query: format=csv|ndjson and window / from / to (see parseRange).
+func AdminExportActivity(db *gorm.DB) gin.HandlerFunc {
+	columns := []string{"seen_date", "device_id", "platform", "app_version", "seen_at", "country", "region"}
+	return func(c *gin.Context) {
+		format, ok := exportFormat(c)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format 只能为 csv 或 ndjson"})
+			return
+		}
+		from, to, err := parseRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时间范围不合法"})
+			return
+		}
+		rows, err := db.Raw(`SELECT seen_date, device_id, platform, app_version, seen_at, country, region
+FROM app_activity
+WHERE seen_date >= (?::date) AND seen_date < (?::date)
+ORDER BY seen_date, id`, from, to).Rows()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export activity"})
+			return
+		}
+		w, err := newExportWriter(c, "activity", format, columns)
+		if err != nil {
+			rows.Close()
+			log.Printf("export aborted: %v", err)
+			return
+		}
+		streamExport(w, rows, func(rows *sql.Rows) ([]interface{}, error) {
+			var seenDate, seenAt time.Time
+			var deviceID, platform, version, country, region string
+			if err := rows.Scan(&seenDate, &deviceID, &platform, &version, &seenAt, &country, &region); err != nil {
+				return nil, err
+			}
+			return []interface{}{seenDate.Format("2006-01-02"), deviceID, platform, version, seenAt, country, region}, nil
+		})
+	}
+}
+
+// statsExports lists the aggregates of AdminExportStats.
+var statsExports = []string{"overview", "platforms", "versions", "countries", "trend", "daily", "retention", "adoption"}
+
+// GET /api/v1/admin/export/stats/:kind
+// kind is one of statsExports; the query takes format=csv|ndjson and the
+// parameters of the matching /stats endpoint.
+func AdminExportStats(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format, ok := exportFormat(c)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format 只能为 csv 或 ndjson"})
+			return
+		}
+		kind := c.Param("kind")
+		columns, rows, status, msg := loadStatsExport(db, c, kind)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		writeExport(c, kind, format, columns, rows)
+	}
+}
+
+// loadStatsExport loads the rows of an aggregate export. On failure it returns
+// the status and message of the error response.
+func loadStatsExport(db *gorm.DB, c *gin.Context, kind string) ([]string, [][]interface{}, int, string) {
+	var rows [][]interface{}
+	switch kind {
+	case "overview":
+		from, to, err := parseRange(c)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, "时间范围不合法"
+		}
+		o := loadOverview(db, from, to)
+		return []string{"dau_today", "mau_30d", "total_devices", "period_devices", "timezone"},
+			[][]interface{}{{o.DAUToday, o.MAU30d, o.TotalDevices, o.PeriodDevices, o.Timezone}}, 0, ""
+
+	case "platforms":
+		counts, err := loadPlatformCounts(db)
+		if err != nil {
+			return nil, nil, http.StatusInternalServerError, "Failed to fetch platform stats"
+		}
+		for _, p := range counts {
+			rows = append(rows, []interface{}{p.Platform, p.Count})
+		}
+		return []string{"platform", "devices"}, rows, 0, ""
+
+	case "versions":
+		counts, err := loadCurrentVersionStatsRows(db)
+		if err != nil {
+			return nil, nil, http.StatusInternalServerError, "Failed to fetch version stats"
+		}
+		for _, v := range counts {
+			rows = append(rows, []interface{}{v.Version, v.Count})
+		}
+		return []string{"version", "devices"}, rows, 0, ""
+
+	case "countries":
+		country, ok := parseCountry(c)
+		if !ok {
+			return nil, nil, http.StatusBadRequest, "country 需为两位国家代码，例如 CN"
+		}
+		counts, err := loadCountryCounts(db, country, nowUTC().Add(-activeDeviceWindow))
+		if err != nil {
+			return nil, nil, http.StatusInternalServerError, "Failed to fetch country stats"
+		}
+		for _, cc := range counts {
+			rows = append(rows, []interface{}{cc.Country, cc.Region, cc.Count, cc.Active})
+		}
+		return []string{"country", "region", "devices", "active_devices"}, rows, 0, ""
+
+	case "trend":
+		from, to, err := parseRange(c)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, "时间范围不合法"
+		}
+		gap, ok := parseChurnGap(c)
+		if !ok {
+			return nil, nil, http.StatusBadRequest, "gap 需为 1-365 之间的天数"
+		}
+		trend, err := loadTrend(db, from, to, gap)
+		if err != nil {
+			return nil, nil, http.StatusInternalServerError, "Failed to fetch trend"
+		}
+		for _, t := range trend {
+			rows = append(rows, []interface{}{t.Date.Format("2006-01-02"), t.Count, t.New, t.Returning, t.Resurrected})
+		}
+		return []string{"date", "devices", "new", "returning", "resurrected"}, rows, 0, ""
+
+	case "daily":
+		from, to, err := parseRange(c)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, "时间范围不合法"
+		}
+		counts, err := loadAdoptionCounts(db, from, to)
+		if err != nil {
+			return nil, nil, http.StatusInternalServerError, "Failed to fetch daily stats"
+		}
+		for _, d := range counts {
+			rows = append(rows, []interface{}{d.Date.Format("2006-01-02"), d.Platform, d.Version, d.Count})
+		}
+		return []string{"date", "platform", "app_version", "devices"}, rows, 0, ""
+
+	case "retention":
+		f, msg := parseRetentionFilter(c)
+		if msg != "" {
+			return nil, nil, http.StatusBadRequest, msg
+		}
+		cohorts, purged, err := loadRetentionCohorts(db, f)
+		if err != nil {
+			return nil, nil, http.StatusInternalServerError, "Failed to fetch retention stats"
+		}
+		columns := []string{"cohort", "size"}
+		for _, day := range retentionDays {
+			n := strconv.Itoa(day)
+			columns = append(columns, "d"+n+"_retained", "d"+n+"_eligible", "d"+n+"_rate")
+		}
+		for _, cohort := range buildRetentionMatrix(cohorts, purged) {
+			row := []interface{}{cohort.Cohort, cohort.Size}
+			for _, cell := range cohort.Retention {
+				row = append(row, cell.Retained, cell.Eligible, cell.Rate)
+			}
+			rows = append(rows, row)
+		}
+		return columns, rows, 0, ""
+
+	case "adoption":
+		versions, days, msg := parseAdoptionParams(c)
+		if msg != "" {
+			return nil, nil, http.StatusBadRequest, msg
+		}
+		releases, err := loadAdoptionReleases(db, versions)
+		if err != nil {
+			return nil, nil, http.StatusInternalServerError, "Failed to fetch releases"
+		}
+		curves, err := loadAdoptionCurves(db, releases, days)
+		if err != nil {
+			return nil, nil, http.StatusInternalServerError, "Failed to fetch adoption stats"
+		}
+		// One row for all platforms, then one per platform
+		for _, curve := range curves {
+			for _, p := range curve.Points {
+				rows = append(rows, []interface{}{curve.Version, p.Day, p.Date, "", p.Active, p.Adopted, p.Share})
+				for _, platform := range sortedPlatforms(p.Platforms) {
+					s := p.Platforms[platform]
+					rows = append(rows, []interface{}{curve.Version, p.Day, p.Date, platform, s.Active, s.Adopted, s.Share})
+				}
+			}
+		}
+		return []string{"version", "day", "date", "platform", "active", "adopted", "share"}, rows, 0, ""
+	}
+	return nil, nil, http.StatusNotFound, "未知的统计类型，可选：" + strings.Join(statsExports, ", ")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVCellEscapesFormulas(t *testing.T) {
+	rate := 12.5
+	assert.Equal(t, "'=HYPERLINK(1)", csvCell("=HYPERLINK(1)"))
+	assert.Equal(t, "'-1", csvCell("-1"))
+	assert.Equal(t, "device-1", csvCell("device-1"))
+	assert.Equal(t, "2024-05-10T04:00:00Z", csvCell(time.Date(2024, 5, 10, 12, 0, 0, 0, analyticsZone.Location)))
+	assert.Equal(t, "12.5", csvCell(&rate))
+	assert.Equal(t, "", csvCell((*float64)(nil)))
+	assert.Equal(t, "42", csvCell(int64(42)))
+}
+
+func TestAdminExportDevicesStreamsFilteredCSV(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	seen := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT device_id, platform, app_version, first_seen, last_seen, total_launches, ip, country, region FROM "app_statistics" WHERE platform = $1 ORDER BY last_seen DESC`)).
+		WithArgs("android").
+		WillReturnRows(sqlmock.NewRows([]string{"device_id", "platform", "app_version", "first_seen", "last_seen", "total_launches", "ip", "country", "region"}).
+			AddRow("device-1", "android", "2.30.0", seen, seen, 3, "127.0.0.1", "CN", "GD").
+			AddRow("=cmd", "android", "2.29.0", seen, seen, 1, "", "", ""))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/export/devices?platform=android", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminExportDevices(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), `attachment; filename="devices-`)
+	assert.Equal(t, strings.Join([]string{
+		"device_id,platform,app_version,first_seen,last_seen,total_launches,ip,country,region",
+		"device-1,android,2.30.0,2024-05-10T08:00:00Z,2024-05-10T08:00:00Z,3,127.0.0.1,CN,GD",
+		"'=cmd,android,2.29.0,2024-05-10T08:00:00Z,2024-05-10T08:00:00Z,1,,,",
+		"",
+	}, "\n"), w.Body.String())
+}
+
+func TestAdminExportActivityWritesNDJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	from := time.Date(2024, 5, 10, 0, 0, 0, 0, analyticsZone.Location)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT seen_date, device_id, platform, app_version, seen_at, country, region
+FROM app_activity
+WHERE seen_date >= ($1::date) AND seen_date < ($2::date)
+ORDER BY seen_date, id`)).
+		WithArgs(from, from.AddDate(0, 0, 1)).
+		WillReturnRows(sqlmock.NewRows([]string{"seen_date", "device_id", "platform", "app_version", "seen_at", "country", "region"}).
+			AddRow(time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC), "device-1", "ios", "2.30.0", time.Date(2024, 5, 10, 1, 2, 3, 0, time.UTC), "JP", "13"))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/export/activity?format=ndjson&window=custom&from=2024-05-10&to=2024-05-10", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminExportActivity(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"seen_date":"2024-05-10","device_id":"device-1","platform":"ios","app_version":"2.30.0","seen_at":"2024-05-10T01:02:03Z","country":"JP","region":"13"}`+"\n", w.Body.String())
+}
+
+func TestAdminExportStatsPlatforms(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	expectDeviceSnapshot(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT s.platform AS platform, COUNT(*) AS count FROM app_statistics s WHERE s.dormant_at IS NULL GROUP BY s.platform`)).
+		WillReturnRows(sqlmock.NewRows([]string{"platform", "count"}).AddRow("android", 7).AddRow("ios", 2))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/export/stats/platforms", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Params = gin.Params{{Key: "kind", Value: "platforms"}}
+
+	AdminExportStats(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "platform,devices\nandroid,7\nios,2\n", w.Body.String())
+}
+
+func TestAdminExportRejectsUnknownKindAndFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, _, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	for _, tc := range []struct {
+		url, kind string
+		status    int
+	}{
+		{"/api/v1/admin/export/stats/sessions", "sessions", http.StatusNotFound},
+		{"/api/v1/admin/export/stats/platforms?format=xlsx", "platforms", http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, tc.url, nil)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		c.Params = gin.Params{{Key: "kind", Value: tc.kind}}
+
+		AdminExportStats(db)(c)
+
+		assert.Equal(t, tc.status, w.Code, tc.url)
+	}
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+
+	"server/analytics"
 )
 
 const (
@@ -22,9 +25,39 @@ type versionStatsRow struct {
 	IsOther bool   `json:"is_other"`
 }
 
-// Helpers to parse window or custom range, using UTC+8 date boundaries
+// activeDeviceWindow is how recently a device must have checked in to count as
+// active for rollout adoption and minimum version compliance.
+const activeDeviceWindow = analytics.ActiveWindow
+
+// activeVersionCount is the number of active devices per platform and version.
+type activeVersionCount struct {
+	Platform string
+	Version  string
+	Count    int64
+}
+
+type minVersionComplianceRow struct {
+	ID         int    `json:"id"`
+	Channel    string `json:"channel"`
+	Platform   string `json:"platform"`
+	MinVersion string `json:"min_version"`
+	Devices    int64  `json:"devices"`
+}
+
+type rolloutProgressRow struct {
+	Version          string      `json:"version"`
+	IsBeta           bool        `json:"is_beta"`
+	Platforms        PlatformSet `json:"platforms"`
+	RolloutPercent   int         `json:"rollout_percent"`
+	EffectivePercent int         `json:"effective_percent"`
+	Paused           bool        `json:"paused"`
+	AdoptedDevices   int64       `json:"adopted_devices"`
+	ActiveDevices    int64       `json:"active_devices"`
+	AdoptionPercent  float64     `json:"adoption_percent"`
+}
+
+// Helpers to parse window or custom range, using analytics time zone date boundaries
 func parseRange(c *gin.Context) (from time.Time, to time.Time, err error) {
-	loc := time.FixedZone("UTC+8", 8*3600)
 	window := c.Query("window")
 	if window == "" {
 		window = "7d"
@@ -35,24 +68,23 @@ func parseRange(c *gin.Context) (from time.Time, to time.Time, err error) {
 		if fromStr == "" || toStr == "" {
 			return time.Time{}, time.Time{}, errBadRange
 		}
-		// Parse YYYY-MM-DD in UTC+8
-		if from, err = time.ParseInLocation("2006-01-02", fromStr, loc); err != nil {
+		// Parse YYYY-MM-DD in the analytics time zone
+		if from, err = analyticsZone.ParseDay(fromStr); err != nil {
 			return
 		}
-		if to, err = time.ParseInLocation("2006-01-02", toStr, loc); err != nil {
+		if to, err = analyticsZone.ParseDay(toStr); err != nil {
 			return
 		}
-		// Inclusive range: to = local end of day
-		to = to.Add(24 * time.Hour)
+		// Inclusive range: to = local end of day, which is not always 24h away
+		to = to.AddDate(0, 0, 1)
 	} else {
 		// Window 7d or 30d ending today (inclusive)
 		days := 7
 		if window == "30d" {
 			days = 30
 		}
-		now := time.Now().In(loc)
-		endLocalMidnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
-		start := endLocalMidnight.Add(time.Duration(-days) * 24 * time.Hour)
+		endLocalMidnight := analyticsZone.Today().AddDate(0, 0, 1)
+		start := endLocalMidnight.AddDate(0, 0, -days)
 		from, to = start, endLocalMidnight
 	}
 	return
@@ -60,6 +92,34 @@ func parseRange(c *gin.Context) (from time.Time, to time.Time, err error) {
 
 var errBadRange = gorm.ErrInvalidData
 
+type overviewStats struct {
+	DAUToday      int64  `json:"dauToday"`
+	MAU30d        int64  `json:"mau30d"`
+	TotalDevices  int64  `json:"totalDevices"`
+	PeriodDevices int64  `json:"periodDevices"`
+	Timezone      string `json:"timezone"`
+}
+
+func loadOverview(db *gorm.DB, from, to time.Time) overviewStats {
+	stats := overviewStats{Timezone: analyticsZone.Name}
+
+	// DAU today
+	today := analyticsZone.Today()
+	db.Raw("SELECT COUNT(DISTINCT device_id) FROM app_activity WHERE seen_date = (?::date)", today).Scan(&stats.DAUToday)
+
+	// MAU 30d: devices seen in the last 30 days, whose last_seen is indexed
+	start30 := today.AddDate(0, 0, -30)
+	db.Raw("SELECT COUNT(*) FROM app_statistics WHERE last_seen >= ?", start30).Scan(&stats.MAU30d)
+
+	// Total devices, except the dormant ones
+	db.Raw("SELECT COUNT(*) FROM app_statistics WHERE dormant_at IS NULL").Scan(&stats.TotalDevices)
+
+	// Devices in period (from-to). Distinct devices over a range cannot be
+	// summed from daily rollups, so this reads app_activity.
+	db.Raw("SELECT COUNT(DISTINCT device_id) FROM app_activity WHERE seen_date >= (?::date) AND seen_date < (?::date)", from, to).Scan(&stats.PeriodDevices)
+	return stats
+}
+
 // GET /api/v1/admin/stats/overview
 func AdminStatsOverview(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -68,46 +128,50 @@ func AdminStatsOverview(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "时间范围不合法"})
 			return
 		}
+		c.JSON(http.StatusOK, loadOverview(db, from, to))
+	}
+}
 
-		// DAU today (UTC+8)
-		loc := time.FixedZone("UTC+8", 8*3600)
-		now := time.Now().In(loc)
-		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
-		var dauToday int64
-		db.Raw("SELECT COUNT(DISTINCT device_id) FROM app_activity WHERE seen_date = (?::date)", today).Scan(&dauToday)
-
-		// MAU 30d: distinct devices in last 30 days
-		start30 := today.Add(-30 * 24 * time.Hour)
-		var mau30d int64
-		db.Raw("SELECT COUNT(DISTINCT device_id) FROM app_activity WHERE seen_date >= (?::date) AND seen_date < (?::date)", start30, today.Add(24*time.Hour)).Scan(&mau30d)
-
-		// Total devices ever
-		var totalDevices int64
-		db.Raw("SELECT COUNT(*) FROM app_statistics").Scan(&totalDevices)
-
-		// Devices in period (from-to)
-		var periodDevices int64
-		db.Raw("SELECT COUNT(DISTINCT device_id) FROM app_activity WHERE seen_date >= (?::date) AND seen_date < (?::date)", from, to).Scan(&periodDevices)
+type platformCount struct {
+	Platform string `json:"platform"`
+	Count    int64  `json:"count"`
+}
 
-		c.JSON(http.StatusOK, gin.H{
-			"dauToday":      dauToday,
-			"mau30d":        mau30d,
-			"totalDevices":  totalDevices,
-			"periodDevices": periodDevices,
-		})
+// loadPlatformCounts counts devices per current platform, from the latest
+// device snapshot or app_statistics while there is none.
+func loadPlatformCounts(db *gorm.DB) ([]platformCount, error) {
+	var rows []platformCount
+	snapshot, err := loadDeviceSnapshot(db, analyticsZone.Today())
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshot) == 0 {
+		// 设备维度统计：按当前平台分组（不限定时间窗口）
+		err := db.Raw("SELECT s.platform AS platform, COUNT(*) AS count FROM app_statistics s WHERE s.dormant_at IS NULL GROUP BY s.platform ORDER BY count DESC").Scan(&rows).Error
+		return rows, err
+	}
+	index := make(map[string]int)
+	for _, s := range snapshot {
+		i, ok := index[s.Platform]
+		if !ok {
+			i = len(rows)
+			index[s.Platform] = i
+			rows = append(rows, platformCount{Platform: s.Platform})
+		}
+		rows[i].Count += s.Devices
 	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Count > rows[j].Count })
+	return rows, nil
 }
 
 // GET /api/v1/admin/stats/platforms
 func AdminStatsPlatforms(db *gorm.DB) gin.HandlerFunc {
-	type Row struct {
-		Platform string `json:"platform"`
-		Count    int64  `json:"count"`
-	}
 	return func(c *gin.Context) {
-		var rows []Row
-		// 设备维度统计：按当前平台分组（不限定时间窗口）
-		db.Raw("SELECT s.platform AS platform, COUNT(*) AS count FROM app_statistics s GROUP BY s.platform ORDER BY count DESC").Scan(&rows)
+		rows, err := loadPlatformCounts(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch platform stats"})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"items": rows})
 	}
 }
@@ -115,27 +179,97 @@ func AdminStatsPlatforms(db *gorm.DB) gin.HandlerFunc {
 // GET /api/v1/admin/stats/versions
 func AdminStatsVersions(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		rows, err := loadVersionStatsRows(db)
+		now := nowUTC()
+		// Counts come from the latest device snapshot, or app_statistics
+		// while no recent snapshot exists
+		snapshot, err := loadDeviceSnapshot(db, analyticsZone.Today())
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch version stats"})
 			return
 		}
+		rows := snapshotVersionStatsRows(snapshot)
+		active := snapshotActiveVersionCounts(snapshot)
+		if len(snapshot) == 0 {
+			if rows, err = loadVersionStatsRows(db); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch version stats"})
+				return
+			}
+			if active, err = loadActiveVersionCounts(db, now.Add(-activeDeviceWindow)); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch version stats"})
+				return
+			}
+		}
+		rollouts, err := loadRolloutProgress(db, active, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rollout stats"})
+			return
+		}
+		floors, belowFloor, err := loadMinVersionCompliance(db, active)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch minimum version stats"})
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{"items": bucketVersionStatsRows(rows, parseVersionStatsLimit(c))})
+		c.JSON(http.StatusOK, gin.H{
+			"items":             bucketVersionStatsRows(rows, parseVersionStatsLimit(c)),
+			"rollouts":          rollouts,
+			"minVersions":       floors,
+			"belowFloorDevices": belowFloor,
+		})
 	}
 }
 
+// deviceListItem is a device as listed and exported by the admin.
+type deviceListItem struct {
+	DeviceID      string     `json:"device_id"`
+	Platform      string     `json:"platform"`
+	AppVersion    string     `json:"app_version"`
+	FirstSeen     time.Time  `json:"first_seen"`
+	LastSeen      time.Time  `json:"last_seen"`
+	TotalLaunches int        `json:"total_launches"`
+	IP            string     `json:"ip"`
+	Country       string     `json:"country"`
+	Region        string     `json:"region"`
+	DormantAt     *time.Time `json:"dormant_at"`
+}
+
+// deviceListQuery applies the device list filters of the query string:
+// platform, version, version_bucket (with version_limit), country and q.
+// A malformed country is ignored.
+func deviceListQuery(db *gorm.DB, c *gin.Context) (*gorm.DB, error) {
+	platform := c.Query("platform")
+	country, _ := parseCountry(c)
+	version := c.Query("version")
+	versionBucket := c.Query("version_bucket")
+	q := c.Query("q")
+
+	tx := db.Model(&AppStatistic{})
+	if platform != "" {
+		tx = tx.Where("platform = ?", platform)
+	}
+	if country != "" {
+		tx = tx.Where("country = ?", country)
+	}
+	if version != "" {
+		tx = tx.Where("app_version = ?", version)
+	} else if versionBucket == otherVersionBucket {
+		topVersions, err := loadTopVersionNames(db, parseVersionStatsLimit(c))
+		if err != nil {
+			return nil, err
+		}
+		if len(topVersions) > 0 {
+			tx = tx.Where("app_version NOT IN ?", topVersions)
+		}
+	}
+	if q != "" {
+		cond, args := ipSearchCondition(q)
+		tx = tx.Where(cond, args...)
+	}
+	return tx, nil
+}
+
 // GET /api/v1/admin/stats/devices
 func AdminStatsDevices(db *gorm.DB) gin.HandlerFunc {
-	type Item struct {
-		DeviceID      string    `json:"device_id"`
-		Platform      string    `json:"platform"`
-		AppVersion    string    `json:"app_version"`
-		FirstSeen     time.Time `json:"first_seen"`
-		LastSeen      time.Time `json:"last_seen"`
-		TotalLaunches int       `json:"total_launches"`
-		IP            string    `json:"ip"`
-	}
 	return func(c *gin.Context) {
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 		pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
@@ -144,35 +278,16 @@ func AdminStatsDevices(db *gorm.DB) gin.HandlerFunc {
 		}
 		offset := (page - 1) * pageSize
 
-		platform := c.Query("platform")
-		version := c.Query("version")
-		versionBucket := c.Query("version_bucket")
-		q := c.Query("q")
-
-		tx := db.Model(&AppStatistic{})
-		if platform != "" {
-			tx = tx.Where("platform = ?", platform)
-		}
-		if version != "" {
-			tx = tx.Where("app_version = ?", version)
-		} else if versionBucket == otherVersionBucket {
-			topVersions, err := loadTopVersionNames(db, parseVersionStatsLimit(c))
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch version stats"})
-				return
-			}
-			if len(topVersions) > 0 {
-				tx = tx.Where("app_version NOT IN ?", topVersions)
-			}
-		}
-		if q != "" {
-			tx = tx.Where("device_id ILIKE ? OR ip ILIKE ?", "%"+q+"%", "%"+q+"%")
+		tx, err := deviceListQuery(db, c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch version stats"})
+			return
 		}
 
 		var total int64
 		tx.Count(&total)
 
-		var items []Item
+		var items []deviceListItem
 		tx.Order("last_seen DESC").Limit(pageSize).Offset(offset).Scan(&items)
 
 		c.JSON(http.StatusOK, gin.H{"total": total, "items": items})
@@ -180,26 +295,128 @@ func AdminStatsDevices(db *gorm.DB) gin.HandlerFunc {
 }
 
 // GET /api/v1/admin/stats/trend/dau
+// Each day is split into new devices (first seen that day), returning devices
+// and resurrected devices, which come back after more than `gap` days away.
+// churned counts the devices that have been away for more than `gap` days
+// since some day of the window. Rolled days come from the rollups, the rest
+// (at least today) from app_activity.
 func AdminStatsTrendDAU(db *gorm.DB) gin.HandlerFunc {
-	type Row struct {
-		Date  time.Time `json:"date"`
-		Count int64     `json:"count"`
-	}
 	return func(c *gin.Context) {
 		from, to, err := parseRange(c)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "时间范围不合法"})
 			return
 		}
-		var rows []Row
-		// Aggregate by seen_date (stored as date at UTC+8 local midnight)
-		db.Raw("SELECT seen_date AS date, COUNT(DISTINCT device_id) AS count FROM app_activity WHERE seen_date >= (?::date) AND seen_date < (?::date) GROUP BY seen_date ORDER BY seen_date", from, to).Scan(&rows)
+		gap, ok := parseChurnGap(c)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "gap 需为 1-365 之间的天数"})
+			return
+		}
+
+		rows, err := loadTrend(db, from, to, gap)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trend"})
+			return
+		}
 		var windowDevices int64
 		db.Raw("SELECT COUNT(DISTINCT device_id) FROM app_activity WHERE seen_date >= (?::date) AND seen_date < (?::date)", from, to).Scan(&windowDevices)
-		c.JSON(http.StatusOK, gin.H{"items": rows, "windowDevices": windowDevices})
+		churned, err := countChurnedDevices(db, from, to, gap)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trend"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"items": rows, "windowDevices": windowDevices, "churned": churned, "gap": gap})
 	}
 }
 
+const (
+	defaultChurnGapDays = 14
+	maxChurnGapDays     = 365
+)
+
+// analyticsZone is the time zone of the seen_date buckets and of every stats
+// range. main sets it from ANALYTICS_TIMEZONE.
+var analyticsZone = analytics.DefaultZone()
+
+// parseChurnGap reads the gap query parameter, reporting false when invalid.
+func parseChurnGap(c *gin.Context) (int, bool) {
+	raw := c.Query("gap")
+	if raw == "" {
+		return defaultChurnGapDays, true
+	}
+	gap, err := strconv.Atoi(raw)
+	return gap, err == nil && gap >= 1 && gap <= maxChurnGapDays
+}
+
+// loadTrend classifies the devices active on each day of [from, to), reading
+// rolled days from the rollups and the rest from app_activity.
+func loadTrend(db *gorm.DB, from, to time.Time, gap int) ([]deviceTrendRow, error) {
+	mid, err := splitRange(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+	rows := []deviceTrendRow{}
+	if from.Before(mid) {
+		if rows, err = loadRolledDeviceTrend(db, from, mid, gap); err != nil {
+			return nil, err
+		}
+	}
+	if mid.Before(to) {
+		live, err := loadDeviceTrend(db, mid, to, gap)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, live...)
+	}
+	return rows, nil
+}
+
+type deviceTrendRow struct {
+	Date        time.Time `json:"date"`
+	Count       int64     `json:"count"`
+	New         int64     `json:"new"`
+	Returning   int64     `json:"returning"`
+	Resurrected int64     `json:"resurrected"`
+}
+
+// loadDeviceTrend classifies the devices active on each day of [from, to).
+// Activity from gap days before the window is scanned as well so that the
+// previous visit of every device active in the window is known when it lies
+// within the gap. A device without one (that is not new) was away longer.
+func loadDeviceTrend(db *gorm.DB, from, to time.Time, gap int) ([]deviceTrendRow, error) {
+	var rows []deviceTrendRow
+	err := db.Raw(`WITH days AS (
+    SELECT device_id, seen_date,
+        LAG(seen_date) OVER (PARTITION BY device_id ORDER BY seen_date) AS prev_date
+    FROM app_activity
+    WHERE seen_date >= (?::date) AND seen_date < (?::date)
+), classified AS (
+    SELECT d.seen_date, d.prev_date,
+        COALESCE((s.first_seen AT TIME ZONE ?)::date >= d.seen_date, false) AS is_new
+    FROM days d
+    LEFT JOIN app_statistics s ON s.device_id = d.device_id
+    WHERE d.seen_date >= (?::date)
+)
+SELECT seen_date AS date, COUNT(*) AS count,
+    COUNT(*) FILTER (WHERE is_new) AS new,
+    COUNT(*) FILTER (WHERE NOT is_new AND prev_date >= seen_date - ?::int) AS returning,
+    COUNT(*) FILTER (WHERE NOT is_new AND (prev_date IS NULL OR prev_date < seen_date - ?::int)) AS resurrected
+FROM classified
+GROUP BY seen_date
+ORDER BY seen_date`, from.AddDate(0, 0, -gap), to, analyticsZone.Name, from, gap, gap).Scan(&rows).Error
+	return rows, err
+}
+
+// countChurnedDevices counts the devices that churned during [from, to): the
+// first day on which a visit would count as resurrected, gap+1 days after
+// their last one, falls in the window and they have not been seen since.
+func countChurnedDevices(db *gorm.DB, from, to time.Time, gap int) (int64, error) {
+	var churned int64
+	err := db.Raw("SELECT COUNT(*) FROM app_statistics WHERE last_seen >= ? AND last_seen < ?",
+		from.AddDate(0, 0, -gap-1), to.AddDate(0, 0, -gap-1)).Scan(&churned).Error
+	return churned, err
+}
+
 func parseVersionStatsLimit(c *gin.Context) int {
 	raw := c.Query("version_limit")
 	if raw == "" {
@@ -220,11 +437,25 @@ func loadVersionStatsRows(db *gorm.DB) ([]versionStatsRow, error) {
 	var rows []versionStatsRow
 	err := db.Raw(`SELECT s.app_version AS version, COUNT(*) AS count
 FROM app_statistics s
+WHERE s.dormant_at IS NULL
 GROUP BY s.app_version
 ORDER BY COUNT(*) DESC, s.app_version ASC`).Scan(&rows).Error
 	return rows, err
 }
 
+// loadCurrentVersionStatsRows counts devices per version from the latest device
+// snapshot, or app_statistics while there is none.
+func loadCurrentVersionStatsRows(db *gorm.DB) ([]versionStatsRow, error) {
+	snapshot, err := loadDeviceSnapshot(db, analyticsZone.Today())
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshot) == 0 {
+		return loadVersionStatsRows(db)
+	}
+	return snapshotVersionStatsRows(snapshot), nil
+}
+
 func bucketVersionStatsRows(rows []versionStatsRow, limit int) []versionStatsRow {
 	if limit <= 0 {
 		limit = defaultVersionStatsLimit
@@ -264,7 +495,7 @@ func loadTopVersionNames(db *gorm.DB, limit int) ([]string, error) {
 		limit = maxVersionStatsLimit
 	}
 
-	rows, err := loadVersionStatsRows(db)
+	rows, err := loadCurrentVersionStatsRows(db)
 	if err != nil {
 		return nil, err
 	}
@@ -278,3 +509,86 @@ func loadTopVersionNames(db *gorm.DB, limit int) ([]string, error) {
 	}
 	return names, nil
 }
+
+// loadActiveVersionCounts counts devices seen since the given time by platform and version.
+func loadActiveVersionCounts(db *gorm.DB, since time.Time) ([]activeVersionCount, error) {
+	var counts []activeVersionCount
+	err := db.Raw(`SELECT s.platform AS platform, s.app_version AS version, COUNT(*) AS count
+FROM app_statistics s
+WHERE s.last_seen >= ?
+GROUP BY s.platform, s.app_version`, since).Scan(&counts).Error
+	return counts, err
+}
+
+// loadRolloutProgress reports, for each release that is currently the latest on
+// some platform, how many of the active devices on the platforms it targets
+// already run it compared with the rollout target.
+func loadRolloutProgress(db *gorm.DB, active []activeVersionCount, now time.Time) ([]rolloutProgressRow, error) {
+	published, err := loadPublishedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]bool)
+	for _, latest := range []map[string]string{latestVersionsByPlatform(published, false), latestVersionsByPlatform(published, true)} {
+		for _, version := range latest {
+			current[version] = true
+		}
+	}
+
+	progress := make([]rolloutProgressRow, 0, len(current))
+	for i := range published {
+		v := &published[i]
+		if !current[v.Version] {
+			continue
+		}
+		row := rolloutProgressRow{
+			Version:          v.Version,
+			IsBeta:           v.IsBeta,
+			Platforms:        v.Platforms,
+			RolloutPercent:   v.RolloutPercent,
+			EffectivePercent: effectiveRolloutPercent(v, now),
+			Paused:           v.RolloutPaused,
+		}
+		for _, c := range active {
+			if !v.Platforms.Covers(c.Platform) {
+				continue
+			}
+			row.ActiveDevices += c.Count
+			if c.Version == v.Version {
+				row.AdoptedDevices += c.Count
+			}
+		}
+		if row.ActiveDevices > 0 {
+			row.AdoptionPercent = float64(row.AdoptedDevices) * 100 / float64(row.ActiveDevices)
+		}
+		progress = append(progress, row)
+	}
+	return progress, nil
+}
+
+// loadMinVersionCompliance counts, per minimum version rule, the active devices
+// running a version below it. Devices on a pre-release version are matched
+// against the beta channel rules.
+func loadMinVersionCompliance(db *gorm.DB, active []activeVersionCount) ([]minVersionComplianceRow, int64, error) {
+	rules, err := loadMinSupportedVersions(db)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows := make([]minVersionComplianceRow, len(rules))
+	index := make(map[int]int, len(rules))
+	for i, r := range rules {
+		rows[i] = minVersionComplianceRow{ID: r.ID, Channel: r.Channel, Platform: r.Platform, MinVersion: r.MinVersion}
+		index[r.ID] = i
+	}
+
+	var total int64
+	for _, c := range active {
+		floor := resolveMinSupportedVersion(rules, updateChannel(inferBeta(c.Version)), c.Platform)
+		if floor == nil || !isNewerVersion(c.Version, floor.MinVersion) {
+			continue
+		}
+		rows[index[floor.ID]].Devices += c.Count
+		total += c.Count
+	}
+	return rows, total, nil
+}
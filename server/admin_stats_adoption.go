@@ -0,0 +1,274 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAdoptionReleases = 3
+	maxAdoptionReleases     = 8
+	defaultAdoptionDays     = 30
+	maxAdoptionDays         = 90
+)
+
+// adoptionCount is the number of devices active on a day per platform and version.
+type adoptionCount struct {
+	Date     time.Time
+	Platform string
+	Version  string
+	Count    int64
+}
+
+// adoptionShare is the part of the active devices running a release or newer.
+type adoptionShare struct {
+	Active  int64   `json:"active"`
+	Adopted int64   `json:"adopted"`
+	Share   float64 `json:"share"`
+}
+
+func (s *adoptionShare) add(count int64, adopted bool) {
+	s.Active += count
+	if adopted {
+		s.Adopted += count
+	}
+}
+
+func (s *adoptionShare) finish() {
+	if s.Active > 0 {
+		s.Share = float64(s.Adopted) * 100 / float64(s.Active)
+	}
+}
+
+// adoptionPoint is one day of an adoption curve. Day counts from the day the
+// release was created, so curves of different releases line up.
+type adoptionPoint struct {
+	Day  int    `json:"day"`
+	Date string `json:"date"`
+	adoptionShare
+	Platforms map[string]*adoptionShare `json:"platforms"`
+}
+
+type adoptionCurve struct {
+	Version   string          `json:"version"`
+	IsBeta    bool            `json:"is_beta"`
+	Platforms PlatformSet     `json:"platforms"`
+	CreatedAt time.Time       `json:"created_at"`
+	Points    []adoptionPoint `json:"points"`
+}
+
+// loadAdoptionReleases returns the requested releases, newest first, or the
+// latest published ones when none are requested.
+func loadAdoptionReleases(db *gorm.DB, versions []string) ([]AppVersion, error) {
+	if len(versions) == 0 {
+		published, err := loadPublishedVersions(db)
+		if err != nil {
+			return nil, err
+		}
+		if len(published) > defaultAdoptionReleases {
+			published = published[:defaultAdoptionReleases]
+		}
+		return published, nil
+	}
+	var releases []AppVersion
+	if err := db.Where("version IN ?", versions).Find(&releases).Error; err != nil {
+		return nil, err
+	}
+	sortVersionsDesc(releases)
+	return releases, nil
+}
+
+// loadAdoptionCounts counts active devices per day, platform and version in
+// [from, to), from the rollups up to the rollup boundary and app_activity after.
+func loadAdoptionCounts(db *gorm.DB, from, to time.Time) ([]adoptionCount, error) {
+	mid, err := splitRange(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var counts []adoptionCount
+	if from.Before(mid) {
+		err := db.Raw(`SELECT day AS date, platform, app_version AS version, devices AS count
+FROM stats_daily_activity
+WHERE day >= (?::date) AND day < (?::date)`, from, mid).Scan(&counts).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	if mid.Before(to) {
+		var live []adoptionCount
+		err := db.Raw(`SELECT seen_date AS date, platform, app_version AS version, COUNT(*) AS count
+FROM app_activity
+WHERE seen_date >= (?::date) AND seen_date < (?::date)
+GROUP BY seen_date, platform, app_version`, mid, to).Scan(&live).Error
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, live...)
+	}
+	return counts, nil
+}
+
+// buildAdoptionCurve computes, for each of the first `days` days since the
+// release was created, the share of active devices on the platforms it
+// targets that run it or a newer version.
+func buildAdoptionCurve(v *AppVersion, counts []adoptionCount, loc *time.Location, days int, today time.Time) adoptionCurve {
+	created := v.CreatedAt.In(loc)
+	start := time.Date(created.Year(), created.Month(), created.Day(), 0, 0, 0, 0, loc)
+	curve := adoptionCurve{
+		Version:   v.Version,
+		IsBeta:    v.IsBeta,
+		Platforms: v.Platforms,
+		CreatedAt: v.CreatedAt,
+		Points:    []adoptionPoint{},
+	}
+
+	byDate := make(map[string]*adoptionPoint)
+	for day := 0; day < days; day++ {
+		date := start.AddDate(0, 0, day)
+		if date.After(today) {
+			break
+		}
+		curve.Points = append(curve.Points, adoptionPoint{
+			Day:       day,
+			Date:      date.Format("2006-01-02"),
+			Platforms: make(map[string]*adoptionShare),
+		})
+	}
+	for i := range curve.Points {
+		byDate[curve.Points[i].Date] = &curve.Points[i]
+	}
+
+	adopted := make(map[string]bool)
+	for _, c := range counts {
+		p := byDate[c.Date.Format("2006-01-02")]
+		platform := normalizePlatform(c.Platform)
+		if p == nil || !v.Platforms.Covers(platform) {
+			continue
+		}
+		isAdopted, ok := adopted[c.Version]
+		if !ok {
+			isAdopted = compareVersionStrings(c.Version, v.Version) >= 0
+			adopted[c.Version] = isAdopted
+		}
+		p.add(c.Count, isAdopted)
+		share := p.Platforms[platform]
+		if share == nil {
+			share = &adoptionShare{}
+			p.Platforms[platform] = share
+		}
+		share.add(c.Count, isAdopted)
+	}
+	for i := range curve.Points {
+		curve.Points[i].finish()
+		for _, share := range curve.Points[i].Platforms {
+			share.finish()
+		}
+	}
+	return curve
+}
+
+// parseAdoptionParams reads the versions and days query parameters,
+// returning the message of the error when they are invalid.
+func parseAdoptionParams(c *gin.Context) ([]string, int, string) {
+	var versions []string
+	for _, v := range strings.Split(c.Query("versions"), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) > maxAdoptionReleases {
+		return nil, 0, "最多同时对比 " + strconv.Itoa(maxAdoptionReleases) + " 个版本"
+	}
+	days, err := strconv.Atoi(c.DefaultQuery("days", strconv.Itoa(defaultAdoptionDays)))
+	if err != nil || days < 1 {
+		days = defaultAdoptionDays
+	}
+	if days > maxAdoptionDays {
+		days = maxAdoptionDays
+	}
+	return versions, days, ""
+}
+
+// loadAdoptionCurves builds the adoption curve of each release.
+func loadAdoptionCurves(db *gorm.DB, releases []AppVersion, days int) ([]adoptionCurve, error) {
+	curves := []adoptionCurve{}
+	if len(releases) == 0 {
+		return curves, nil
+	}
+	today := analyticsZone.Today()
+
+	// One query covers the curves of every release
+	first, last := releases[0].CreatedAt, releases[0].CreatedAt
+	for _, r := range releases {
+		if r.CreatedAt.Before(first) {
+			first = r.CreatedAt
+		}
+		if r.CreatedAt.After(last) {
+			last = r.CreatedAt
+		}
+	}
+	from := analyticsZone.Day(first)
+	to := analyticsZone.Day(last).AddDate(0, 0, days)
+	counts, err := loadAdoptionCounts(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for i := range releases {
+		curves = append(curves, buildAdoptionCurve(&releases[i], counts, analyticsZone.Location, days, today))
+	}
+	return curves, nil
+}
+
+// sortedPlatforms returns the platforms of a point in platform order.
+func sortedPlatforms(shares map[string]*adoptionShare) []string {
+	platforms := make([]string, 0, len(shares))
+	for p := range shares {
+		platforms = append(platforms, p)
+	}
+	sortPlatforms(platforms)
+	return platforms
+}
+
+// GET /api/v1/admin/stats/adoption
+// Query: versions (comma separated, defaults to the latest published releases)
+// and days (length of each curve, default 30).
+func AdminStatsAdoption(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		versions, days, msg := parseAdoptionParams(c)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		releases, err := loadAdoptionReleases(db, versions)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch releases"})
+			return
+		}
+		curves, err := loadAdoptionCurves(db, releases, days)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch adoption stats"})
+			return
+		}
+
+		platforms := make(map[string]bool)
+		for _, curve := range curves {
+			for _, p := range curve.Points {
+				for platform := range p.Platforms {
+					platforms[platform] = true
+				}
+			}
+		}
+		platformList := make([]string, 0, len(platforms))
+		for p := range platforms {
+			platformList = append(platformList, p)
+		}
+		sortPlatforms(platformList)
+
+		c.JSON(http.StatusOK, gin.H{"items": curves, "platforms": platformList})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildAdoptionCurve(t *testing.T) {
+	loc := analyticsZone.Location
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+	// Created late on May 1st local time, which is still April 30th in UTC
+	v := &AppVersion{Version: "2.30.0", Platforms: PlatformSet{"android", "ios"}, CreatedAt: time.Date(2024, 4, 30, 20, 0, 0, 0, time.UTC)}
+	counts := []adoptionCount{
+		{Date: day(1), Platform: "android", Version: "2.29.0", Count: 9},
+		{Date: day(1), Platform: "android", Version: "2.30.0", Count: 1},
+		{Date: day(2), Platform: "android", Version: "2.29.0", Count: 5},
+		{Date: day(2), Platform: "android", Version: "2.30.0", Count: 4},
+		{Date: day(2), Platform: "android", Version: "2.31.0-beta.1", Count: 1},
+		{Date: day(2), Platform: "ios", Version: "2.30.0", Count: 2},
+		// Not targeted by the release
+		{Date: day(2), Platform: "windows", Version: "2.29.0", Count: 50},
+	}
+
+	curve := buildAdoptionCurve(v, counts, loc, 5, time.Date(2024, 5, 3, 0, 0, 0, 0, loc))
+
+	require.Len(t, curve.Points, 3, "stops at today")
+	assert.Equal(t, "2024-05-01", curve.Points[0].Date)
+	assert.InDelta(t, 10.0, curve.Points[0].Share, 0.001)
+	p := curve.Points[1]
+	assert.Equal(t, 1, p.Day)
+	assert.Equal(t, int64(12), p.Active)
+	assert.Equal(t, int64(7), p.Adopted)
+	assert.InDelta(t, 50.0, p.Platforms["android"].Share, 0.001)
+	assert.InDelta(t, 100.0, p.Platforms["ios"].Share, 0.001)
+	assert.NotContains(t, p.Platforms, "windows")
+	assert.Equal(t, int64(0), curve.Points[2].Active)
+}
+
+func TestAdminStatsAdoptionComparesRequestedReleases(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	created := time.Now().Add(-48 * time.Hour)
+	mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE version IN \(\$1,\$2\) AND "app_versions"."deleted_at" IS NULL`).
+		WithArgs("2.29.0", "2.30.0").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "platforms", "created_at"}).
+			AddRow(1, "2.29.0", "", created.Add(-480*time.Hour)).
+			AddRow(2, "2.30.0", "", created))
+	expectRolledThrough(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT seen_date AS date, platform, app_version AS version, COUNT(*) AS count
+FROM app_activity
+WHERE seen_date >= ($1::date) AND seen_date < ($2::date)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"date", "platform", "version", "count"}))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/adoption?versions=2.29.0,2.30.0&days=14", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminStatsAdoption(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Items []adoptionCurve `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Len(t, body.Items, 2)
+	assert.Equal(t, "2.30.0", body.Items[0].Version)
+	assert.Equal(t, "2.29.0", body.Items[1].Version)
+	assert.Len(t, body.Items[1].Points, 14)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// countryCount is the number of devices located in a country, or in a region
+// of it. Country and Region are empty for devices without a location.
+type countryCount struct {
+	Country string `json:"country"`
+	Region  string `json:"region,omitempty"`
+	Count   int64  `json:"count"`
+	// Active counts the devices seen in the last 30 days
+	Active int64 `json:"active"`
+}
+
+// parseCountry reads an ISO 3166-1 alpha-2 country code parameter, reporting
+// false when it is set but malformed.
+func parseCountry(c *gin.Context) (string, bool) {
+	country := strings.ToUpper(strings.TrimSpace(c.Query("country")))
+	if country == "" {
+		return "", true
+	}
+	if len(country) != 2 || country[0] < 'A' || country[0] > 'Z' || country[1] < 'A' || country[1] > 'Z' {
+		return "", false
+	}
+	return country, true
+}
+
+// loadCountryCounts counts devices per country, or per region of country
+// when it is set, by the location of their last check.
+func loadCountryCounts(db *gorm.DB, country string, activeSince time.Time) ([]countryCount, error) {
+	var rows []countryCount
+	if country == "" {
+		err := db.Raw(`SELECT country, COUNT(*) AS count, COUNT(*) FILTER (WHERE last_seen >= ?) AS active
+FROM app_statistics
+WHERE dormant_at IS NULL
+GROUP BY country
+ORDER BY count DESC, country`, activeSince).Scan(&rows).Error
+		return rows, err
+	}
+	err := db.Raw(`SELECT country, region, COUNT(*) AS count, COUNT(*) FILTER (WHERE last_seen >= ?) AS active
+FROM app_statistics
+WHERE dormant_at IS NULL AND country = ?
+GROUP BY country, region
+ORDER BY count DESC, region`, activeSince, country).Scan(&rows).Error
+	return rows, err
+}
+
+// GET /api/v1/admin/stats/countries
+// Query: country (optional) breaks the devices of that country down by region.
+// Devices are located only when GEOIP_DB is configured.
+func AdminStatsCountries(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		country, ok := parseCountry(c)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "country 需为两位国家代码，例如 CN"})
+			return
+		}
+		rows, err := loadCountryCounts(db, country, nowUTC().Add(-activeDeviceWindow))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch country stats"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"items": rows, "geoip": geoIPLocator != nil})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminStatsCountries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT country, COUNT(*) AS count, COUNT(*) FILTER (WHERE last_seen >= $1) AS active
+FROM app_statistics
+WHERE dormant_at IS NULL
+GROUP BY country`)).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"country", "count", "active"}).
+			AddRow("CN", 12, 8).
+			AddRow("", 3, 1))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/countries", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminStatsCountries(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Items []countryCount `json:"items"`
+		GeoIP bool           `json:"geoip"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, []countryCount{{Country: "CN", Count: 12, Active: 8}, {Country: "", Count: 3, Active: 1}}, body.Items)
+	assert.False(t, body.GeoIP)
+}
+
+func TestAdminStatsCountriesByRegion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT country, region, COUNT(*) AS count`)).
+		WithArgs(sqlmock.AnyArg(), "CN").
+		WillReturnRows(sqlmock.NewRows([]string{"country", "region", "count", "active"}).
+			AddRow("CN", "GD", 5, 4))
+
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"country=cn", http.StatusOK},
+		{"country=China", http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/countries?"+tc.query, nil)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+
+		AdminStatsCountries(db)(c)
+
+		assert.Equal(t, tc.status, w.Code, tc.query)
+	}
+}
+
+func TestAdminStatsDevicesFiltersByCountry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "app_statistics" WHERE platform = $1 AND country = $2`)).
+		WithArgs("android", "DE").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "app_statistics" WHERE platform = $1 AND country = $2 ORDER BY last_seen DESC LIMIT $3`)).
+		WithArgs("android", "DE", 20).
+		WillReturnRows(sqlmock.NewRows([]string{"device_id"}))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/devices?platform=android&country=de", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminStatsDevices(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// eventCount is how often an event occurred and on how many devices.
+type eventCount struct {
+	Name    string `json:"name"`
+	Count   int64  `json:"count"`
+	Devices int64  `json:"devices"`
+}
+
+// loadEventCounts counts every event of eventSchemas in [from, to), listing
+// the ones that did not occur with zero counts. Devices are distinct per
+// event, so they do not add up across events.
+func loadEventCounts(db *gorm.DB, from, to time.Time) ([]eventCount, error) {
+	var rows []eventCount
+	if err := db.Raw(`SELECT name, COUNT(*) AS count, COUNT(DISTINCT device_id) AS devices
+FROM client_events
+WHERE occurred_at >= ? AND occurred_at < ?
+GROUP BY name
+ORDER BY count DESC, name`, from, to).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(rows))
+	for _, r := range rows {
+		seen[r.Name] = true
+	}
+	for _, name := range eventNames() {
+		if !seen[name] {
+			rows = append(rows, eventCount{Name: name})
+		}
+	}
+	return rows, nil
+}
+
+// eventDay is how often an event occurred on a day and on how many devices.
+type eventDay struct {
+	Day     string `json:"day"`
+	Count   int64  `json:"count"`
+	Devices int64  `json:"devices"`
+}
+
+// loadEventTrend counts the event name per day of the analytics time zone in
+// [from, to). Days without the event are left out.
+func loadEventTrend(db *gorm.DB, name string, from, to time.Time) ([]eventDay, error) {
+	var rows []struct {
+		Day     time.Time
+		Count   int64
+		Devices int64
+	}
+	if err := db.Raw(`SELECT (occurred_at AT TIME ZONE ?)::date AS day, COUNT(*) AS count, COUNT(DISTINCT device_id) AS devices
+FROM client_events
+WHERE name = ? AND occurred_at >= ? AND occurred_at < ?
+GROUP BY day
+ORDER BY day`, analyticsZone.Name, name, from, to).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	days := make([]eventDay, 0, len(rows))
+	for _, r := range rows {
+		days = append(days, eventDay{Day: r.Day.Format("2006-01-02"), Count: r.Count, Devices: r.Devices})
+	}
+	return days, nil
+}
+
+// GET /api/v1/admin/stats/events
+// Query: window (7d, 30d or custom with from / to) as for the overview.
+func AdminStatsEvents(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := parseRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时间范围不合法"})
+			return
+		}
+		rows, err := loadEventCounts(db, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event stats"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"items": rows})
+	}
+}
+
+// GET /api/v1/admin/stats/events/trend
+// Query: name of the event, and the range as for /stats/events.
+func AdminStatsEventTrend(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Query("name")
+		if _, ok := eventSchemas[name]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "未知事件"})
+			return
+		}
+		from, to, err := parseRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时间范围不合法"})
+			return
+		}
+		days, err := loadEventTrend(db, name, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event trend"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"name": name, "items": days})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminStatsEventsListsEveryEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, analyticsZone.Location)
+	to := time.Date(2024, 5, 8, 0, 0, 0, 0, analyticsZone.Location)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT name, COUNT(*) AS count, COUNT(DISTINCT device_id) AS devices
+FROM client_events
+WHERE occurred_at >= $1 AND occurred_at < $2`)).
+		WithArgs(from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "count", "devices"}).
+			AddRow("site.search", 340, 25).
+			AddRow("download.send", 41, 12))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/events?window=custom&from=2024-05-01&to=2024-05-07", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminStatsEvents(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Items []eventCount `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, []eventCount{
+		{Name: "site.search", Count: 340, Devices: 25},
+		{Name: "download.send", Count: 41, Devices: 12},
+		{Name: "downloader.add"},
+		{Name: "search.aggregate"},
+	}, body.Items)
+}
+
+func TestAdminStatsEventTrend(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, analyticsZone.Location)
+	to := time.Date(2024, 5, 3, 0, 0, 0, 0, analyticsZone.Location)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT (occurred_at AT TIME ZONE $1)::date AS day, COUNT(*) AS count, COUNT(DISTINCT device_id) AS devices
+FROM client_events
+WHERE name = $2 AND occurred_at >= $3 AND occurred_at < $4`)).
+		WithArgs(analyticsZone.Name, "search.aggregate", from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "count", "devices"}).
+			AddRow(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), 9, 4))
+
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"name=page.view&window=7d", http.StatusBadRequest},
+		{"name=search.aggregate&window=custom&from=2024-05-01&to=2024-05-02", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/events/trend?"+tc.query, nil)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+
+		AdminStatsEventTrend(db)(c)
+
+		require.Equal(t, tc.status, w.Code, tc.query)
+		if tc.status == http.StatusOK {
+			assert.JSONEq(t, `{"name":"search.aggregate","items":[{"day":"2024-05-02","count":9,"devices":4}]}`, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"server/analytics"
+)
+
+// retentionDays are the day offsets reported by the retention matrix.
+var retentionDays = []int{1, 7, 30}
+
+// retentionCell is the retention of one cohort on day N after first seen.
+// Only devices whose day N has already begun are eligible, so the latest
+// cohorts are not reported as churned before they had the chance to return.
+// Rate is nil while no device of the cohort is eligible.
+// Unavailable cells are the ones whose day N may fall on activity removed by
+// ACTIVITY_RETENTION_DAYS: their returns can no longer be counted, so they
+// carry no counts rather than a retention of 0.
+type retentionCell struct {
+	Day         int      `json:"day"`
+	Retained    int64    `json:"retained"`
+	Eligible    int64    `json:"eligible"`
+	Rate        *float64 `json:"rate"`
+	Unavailable bool     `json:"unavailable,omitempty"`
+}
+
+type retentionCohort struct {
+	Cohort    string          `json:"cohort"`
+	Size      int64           `json:"size"`
+	Retention []retentionCell `json:"retention"`
+}
+
+// retentionRow is the result of retentionSQL for one cohort.
+type retentionRow struct {
+	CohortDate  time.Time
+	Size        int64
+	EligibleD1  int64
+	RetainedD1  int64
+	EligibleD7  int64
+	RetainedD7  int64
+	EligibleD30 int64
+	RetainedD30 int64
+}
+
+// retentionSQL groups devices into cohorts by the day of their first
+// app_activity row, whose platform and app version are the ones filtered on,
+// and counts the devices active again exactly 1, 7 and 30 days later.
+// First days up to the rollup boundary come from stats_device_firsts; devices
+// missing there were first seen on or after the boundary, so only that part
+// of app_activity is scanned for them.
+// The cohort CTE conditions are filled in by loadRetentionCohorts.
+const retentionSQL = `WITH firsts AS (
+    SELECT device_id, first_date, platform, app_version
+    FROM stats_device_firsts
+    UNION ALL
+    SELECT * FROM (
+        SELECT DISTINCT ON (a.device_id) a.device_id, a.seen_date AS first_date, a.platform, a.app_version
+        FROM app_activity a
+        WHERE a.seen_date >= (?::date)
+            AND NOT EXISTS (SELECT 1 FROM stats_device_firsts f WHERE f.device_id = a.device_id)
+        ORDER BY a.device_id, a.seen_date, a.seen_at
+    ) live
+), cohort AS (
+    SELECT device_id, first_date, %s AS cohort_date
+    FROM firsts
+    WHERE %s
+)
+SELECT c.cohort_date AS cohort_date, COUNT(*) AS size,
+    COUNT(*) FILTER (WHERE c.first_date + 1 <= (?::date)) AS eligible_d1,
+    COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM app_activity a WHERE a.device_id = c.device_id AND a.seen_date = c.first_date + 1)) AS retained_d1,
+    COUNT(*) FILTER (WHERE c.first_date + 7 <= (?::date)) AS eligible_d7,
+    COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM app_activity a WHERE a.device_id = c.device_id AND a.seen_date = c.first_date + 7)) AS retained_d7,
+    COUNT(*) FILTER (WHERE c.first_date + 30 <= (?::date)) AS eligible_d30,
+    COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM app_activity a WHERE a.device_id = c.device_id AND a.seen_date = c.first_date + 30)) AS retained_d30
+FROM cohort c
+GROUP BY c.cohort_date
+ORDER BY c.cohort_date`
+
+// retentionFilter selects the cohorts of a retention query.
+type retentionFilter struct {
+	From, To, Today time.Time
+	Weekly          bool
+	Platform        string
+	Version         string
+}
+
+// parseRetentionFilter reads the query parameters of AdminStatsRetention,
+// returning the message of the error when they are invalid.
+func parseRetentionFilter(c *gin.Context) (retentionFilter, string) {
+	from, to, err := parseRange(c)
+	if err != nil {
+		return retentionFilter{}, "时间范围不合法"
+	}
+	cohort := c.DefaultQuery("cohort", "day")
+	if cohort != "day" && cohort != "week" {
+		return retentionFilter{}, "cohort 只能为 day 或 week"
+	}
+	return retentionFilter{
+		From:     from,
+		To:       to,
+		Today:    analyticsZone.Today(),
+		Weekly:   cohort == "week",
+		Platform: c.Query("platform"),
+		Version:  c.Query("version"),
+	}, ""
+}
+
+// loadRetentionCohorts runs retentionSQL for f. It also returns the last day
+// whose app_activity may have been purged, nil when nothing was.
+func loadRetentionCohorts(db *gorm.DB, f retentionFilter) ([]retentionRow, *time.Time, error) {
+	boundary, err := rollupBoundary(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	purged, err := analytics.RolledThrough(db, analytics.ActivityPurge)
+	if err != nil {
+		return nil, nil, err
+	}
+	if purged != nil {
+		day := analyticsZone.DateValue(*purged)
+		purged = &day
+	}
+	cohortExpr := "first_date"
+	if f.Weekly {
+		// ISO weeks, starting on Monday
+		cohortExpr = "date_trunc('week', first_date)::date"
+	}
+	conds := []string{"first_date >= (?::date)", "first_date < (?::date)"}
+	args := []interface{}{boundary, f.From, f.To}
+	if f.Platform != "" {
+		conds = append(conds, "platform = ?")
+		args = append(args, f.Platform)
+	}
+	if f.Version != "" {
+		conds = append(conds, "app_version = ?")
+		args = append(args, f.Version)
+	}
+	args = append(args, f.Today, f.Today, f.Today)
+
+	var rows []retentionRow
+	err = db.Raw(fmt.Sprintf(retentionSQL, cohortExpr, strings.Join(conds, " AND ")), args...).Scan(&rows).Error
+	return rows, purged, err
+}
+
+// buildRetentionMatrix turns the query rows into one line per cohort with a
+// cell per entry of retentionDays. Cells whose day N is on or before purged,
+// for any device of the cohort, are unavailable.
+func buildRetentionMatrix(rows []retentionRow, purged *time.Time) []retentionCohort {
+	cohorts := make([]retentionCohort, 0, len(rows))
+	for _, r := range rows {
+		counts := [][2]int64{
+			{r.EligibleD1, r.RetainedD1},
+			{r.EligibleD7, r.RetainedD7},
+			{r.EligibleD30, r.RetainedD30},
+		}
+		cells := make([]retentionCell, len(retentionDays))
+		for i, day := range retentionDays {
+			// The first device of a weekly cohort may be seen on its first day
+			if purged != nil && !r.CohortDate.AddDate(0, 0, day).After(*purged) {
+				cells[i] = retentionCell{Day: day, Unavailable: true}
+				continue
+			}
+			cells[i] = retentionCell{Day: day, Eligible: counts[i][0], Retained: counts[i][1]}
+			if cells[i].Eligible == 0 {
+				continue
+			}
+			rate := float64(cells[i].Retained) * 100 / float64(cells[i].Eligible)
+			cells[i].Rate = &rate
+		}
+		cohorts = append(cohorts, retentionCohort{
+			Cohort:    r.CohortDate.Format("2006-01-02"),
+			Size:      r.Size,
+			Retention: cells,
+		})
+	}
+	return cohorts
+}
+
+// GET /api/v1/admin/stats/retention
+// Query: window / from / to select the first-seen days (see parseRange),
+// cohort=day|week, platform and version filter on the device's first activity.
+func AdminStatsRetention(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f, msg := parseRetentionFilter(c)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		rows, purged, err := loadRetentionCohorts(db, f)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch retention stats"})
+			return
+		}
+		cohort := "day"
+		if f.Weekly {
+			cohort = "week"
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"cohort": cohort,
+			"days":   retentionDays,
+			"items":  buildRetentionMatrix(rows, purged),
+		})
+	}
+}
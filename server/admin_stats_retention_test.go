@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildRetentionMatrix(t *testing.T) {
+	rows := []retentionRow{
+		{CohortDate: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Size: 10, EligibleD1: 10, RetainedD1: 4, EligibleD7: 10, RetainedD7: 2},
+	}
+
+	got := buildRetentionMatrix(rows, nil)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, "2024-05-01", got[0].Cohort)
+	assert.Equal(t, int64(10), got[0].Size)
+	require.Len(t, got[0].Retention, 3)
+	assert.Equal(t, 1, got[0].Retention[0].Day)
+	require.NotNil(t, got[0].Retention[0].Rate)
+	assert.InDelta(t, 40.0, *got[0].Retention[0].Rate, 0.001)
+	assert.InDelta(t, 20.0, *got[0].Retention[1].Rate, 0.001)
+	// D30 has not been reached yet
+	assert.Equal(t, 30, got[0].Retention[2].Day)
+	assert.Nil(t, got[0].Retention[2].Rate)
+}
+
+// expectPurgedThrough expects the lookup of the activity purge horizon,
+// returning through when given.
+func expectPurgedThrough(mock sqlmock.Sqlmock, through ...time.Time) {
+	rows := sqlmock.NewRows([]string{"rolled_through", "zone"})
+	for _, t := range through {
+		rows.AddRow(t, analyticsZone.Name)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT rolled_through, zone FROM stats_rollup_state WHERE name = $1`)).
+		WithArgs("activity_purge").
+		WillReturnRows(rows)
+}
+
+func TestBuildRetentionMatrixMarksPurgedDays(t *testing.T) {
+	purged := time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC)
+	rows := []retentionRow{
+		{CohortDate: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Size: 10, EligibleD1: 10, RetainedD1: 0, EligibleD7: 10, RetainedD7: 0, EligibleD30: 10, RetainedD30: 3},
+	}
+
+	got := buildRetentionMatrix(rows, &purged)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, int64(10), got[0].Size)
+	// Days 2 and 8 were purged: their returns are unknown, not 0%
+	for _, cell := range got[0].Retention[:2] {
+		assert.True(t, cell.Unavailable, cell.Day)
+		assert.Nil(t, cell.Rate, cell.Day)
+		assert.Zero(t, cell.Eligible, cell.Day)
+	}
+	assert.False(t, got[0].Retention[2].Unavailable)
+	require.NotNil(t, got[0].Retention[2].Rate)
+	assert.InDelta(t, 30.0, *got[0].Retention[2].Rate, 0.001)
+}
+
+func TestAdminStatsRetentionWeeklyWithFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	expectRolledThrough(mock, time.Date(2024, 5, 12, 0, 0, 0, 0, time.UTC))
+	expectPurgedThrough(mock)
+	mock.ExpectQuery(`(?s)FROM stats_device_firsts\s+UNION ALL.*a.seen_date >= \(\$1::date\).*SELECT device_id, first_date, date_trunc\('week', first_date\)::date AS cohort_date\s+FROM firsts\s+WHERE first_date >= \(\$2::date\) AND first_date < \(\$3::date\) AND platform = \$4 AND app_version = \$5`).
+		WithArgs(time.Date(2024, 5, 13, 0, 0, 0, 0, analyticsZone.Location), sqlmock.AnyArg(), sqlmock.AnyArg(), "android", "2.30.0", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"cohort_date", "size", "eligible_d1", "retained_d1", "eligible_d7", "retained_d7", "eligible_d30", "retained_d30"}).
+			AddRow(time.Date(2024, 4, 29, 0, 0, 0, 0, time.UTC), 8, 8, 6, 4, 1, 0, 0))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/retention?window=30d&cohort=week&platform=android&version=2.30.0", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminStatsRetention(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Cohort string            `json:"cohort"`
+		Days   []int             `json:"days"`
+		Items  []retentionCohort `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "week", body.Cohort)
+	assert.Equal(t, []int{1, 7, 30}, body.Days)
+	require.Len(t, body.Items, 1)
+	assert.Equal(t, "2024-04-29", body.Items[0].Cohort)
+	assert.InDelta(t, 75.0, *body.Items[0].Retention[0].Rate, 0.001)
+	assert.InDelta(t, 25.0, *body.Items[0].Retention[1].Rate, 0.001)
+	assert.Nil(t, body.Items[0].Retention[2].Rate)
+}
+
+func TestAdminStatsRetentionRejectsUnknownCohort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, _, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/retention?cohort=month", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminStatsRetention(db)(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
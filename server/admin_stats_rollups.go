@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"server/analytics"
+)
+
+const defaultRollupInterval = 10 * time.Minute
+
+// purgeInterval is how often the analytics retention is applied.
+const purgeInterval = time.Hour
+
+// rollupInterval reads ROLLUP_INTERVAL, a Go duration; 0 disables the job.
+func rollupInterval() (time.Duration, error) {
+	raw := os.Getenv("ROLLUP_INTERVAL")
+	if raw == "" {
+		return defaultRollupInterval, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid ROLLUP_INTERVAL %q", raw)
+	}
+	return d, nil
+}
+
+// startAnalyticsRollup keeps the stats rollup tables current in the background.
+func startAnalyticsRollup(db *gorm.DB) error {
+	interval, err := rollupInterval()
+	if err != nil {
+		return err
+	}
+	if interval == 0 {
+		log.Printf("analytics rollup disabled; stats read app_activity past the last rolled day")
+		return nil
+	}
+	roller := analytics.NewRoller(db, analyticsZone)
+	go roller.Run(context.Background(), interval)
+	return nil
+}
+
+// startAnalyticsPurge applies ACTIVITY_RETENTION_DAYS and DEVICE_RETENTION_DAYS
+// in the background.
+func startAnalyticsPurge(db *gorm.DB) error {
+	ret, err := analytics.RetentionFromEnv()
+	if err != nil {
+		return err
+	}
+	if !ret.Enabled() {
+		return nil
+	}
+	roller := analytics.NewRoller(db, analyticsZone)
+	go roller.RunPurge(context.Background(), ret, purgeInterval)
+	return nil
+}
+
+// rollupBoundary returns the start of the first day that is not covered by
+// the activity rollups yet. Stats read earlier days from the rollup tables and
+// this day onwards, which always includes today, from app_activity.
+// It is the zero time when nothing was rolled.
+func rollupBoundary(db *gorm.DB) (time.Time, error) {
+	through, err := analytics.RolledThrough(db, analytics.ActivityRollup)
+	if err != nil || through == nil {
+		return time.Time{}, err
+	}
+	return analyticsZone.DateValue(*through).AddDate(0, 0, 1), nil
+}
+
+// splitRange splits [from, to) at the rollup boundary into the part read from
+// the rollups, [from, mid), and the part read from app_activity, [mid, to).
+func splitRange(db *gorm.DB, from, to time.Time) (mid time.Time, err error) {
+	boundary, err := rollupBoundary(db)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch {
+	case boundary.Before(from):
+		return from, nil
+	case boundary.After(to):
+		return to, nil
+	}
+	return boundary, nil
+}
+
+// loadRolledDeviceTrend reads the trend of [from, to) from the rollups. The
+// resurrected devices are the ones away for at least gap days.
+func loadRolledDeviceTrend(db *gorm.DB, from, to time.Time, gap int) ([]deviceTrendRow, error) {
+	var rows []deviceTrendRow
+	err := db.Raw(`SELECT a.day AS date, a.count, a.new,
+    a.count - a.new - COALESCE(r.resurrected, 0) AS returning,
+    COALESCE(r.resurrected, 0) AS resurrected
+FROM (
+    SELECT day, SUM(devices) AS count, SUM(new_devices) AS new
+    FROM stats_daily_activity
+    WHERE day >= (?::date) AND day < (?::date)
+    GROUP BY day
+) a
+LEFT JOIN (
+    SELECT day, SUM(devices) AS resurrected
+    FROM stats_daily_returns
+    WHERE day >= (?::date) AND day < (?::date) AND away_days >= ?
+    GROUP BY day
+) r ON r.day = a.day
+ORDER BY a.day`, from, to, from, to, gap).Scan(&rows).Error
+	return rows, err
+}
+
+// deviceSnapshotRow is a row of the latest stats_daily_devices snapshot.
+type deviceSnapshotRow struct {
+	Platform      string
+	Version       string
+	Devices       int64
+	ActiveDevices int64
+}
+
+// loadDeviceSnapshot returns the latest snapshot of app_statistics, or nothing
+// when it was not taken since the day before today.
+func loadDeviceSnapshot(db *gorm.DB, today time.Time) ([]deviceSnapshotRow, error) {
+	var rows []deviceSnapshotRow
+	err := db.Raw(`SELECT platform, app_version AS version, devices, active_devices
+FROM stats_daily_devices
+WHERE day = (SELECT MAX(day) FROM stats_daily_devices) AND day >= (?::date)`, today.AddDate(0, 0, -1)).Scan(&rows).Error
+	return rows, err
+}
+
+// snapshotVersionStatsRows counts devices per version, ordered like loadVersionStatsRows.
+func snapshotVersionStatsRows(snapshot []deviceSnapshotRow) []versionStatsRow {
+	index := make(map[string]int)
+	var rows []versionStatsRow
+	for _, s := range snapshot {
+		i, ok := index[s.Version]
+		if !ok {
+			i = len(rows)
+			index[s.Version] = i
+			rows = append(rows, versionStatsRow{Version: s.Version})
+		}
+		rows[i].Count += s.Devices
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].Version < rows[j].Version
+	})
+	return rows
+}
+
+// snapshotActiveVersionCounts returns the devices active in the snapshot's
+// 30 day window per platform and version.
+func snapshotActiveVersionCounts(snapshot []deviceSnapshotRow) []activeVersionCount {
+	counts := make([]activeVersionCount, 0, len(snapshot))
+	for _, s := range snapshot {
+		if s.ActiveDevices > 0 {
+			counts = append(counts, activeVersionCount{Platform: s.Platform, Version: s.Version, Count: s.ActiveDevices})
+		}
+	}
+	return counts
+}
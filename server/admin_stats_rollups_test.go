@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectRolledThrough expects the watermark query of the activity rollups,
+// returning no row when through is omitted.
+func expectRolledThrough(mock sqlmock.Sqlmock, through ...time.Time) {
+	rows := sqlmock.NewRows([]string{"rolled_through", "zone"})
+	for _, t := range through {
+		rows.AddRow(t, analyticsZone.Name)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT rolled_through, zone FROM stats_rollup_state WHERE name = $1`)).
+		WithArgs("daily_activity").
+		WillReturnRows(rows)
+}
+
+// expectDeviceSnapshot expects the device snapshot query, returning the given
+// platform, version, devices and active devices rows.
+func expectDeviceSnapshot(mock sqlmock.Sqlmock, rows ...[]driver.Value) {
+	result := sqlmock.NewRows([]string{"platform", "version", "devices", "active_devices"})
+	for _, r := range rows {
+		result.AddRow(r...)
+	}
+	mock.ExpectQuery(`SELECT platform, app_version AS version, devices, active_devices\s+FROM stats_daily_devices`).
+		WillReturnRows(result)
+}
+
+func TestSplitRangeClampsBoundary(t *testing.T) {
+	loc := analyticsZone.Location
+	from := time.Date(2024, 5, 10, 0, 0, 0, 0, loc)
+	to := time.Date(2024, 5, 17, 0, 0, 0, 0, loc)
+
+	cases := []struct {
+		name    string
+		through []time.Time
+		want    time.Time
+	}{
+		{"never rolled", nil, from},
+		{"rolled before the range", []time.Time{time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}, from},
+		{"rolled inside the range", []time.Time{time.Date(2024, 5, 12, 0, 0, 0, 0, time.UTC)}, time.Date(2024, 5, 13, 0, 0, 0, 0, loc)},
+		{"rolled past the range", []time.Time{time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}, to},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, cleanup := newMockGormDB(t)
+			defer cleanup()
+			expectRolledThrough(mock, tc.through...)
+
+			mid, err := splitRange(db, from, to)
+
+			require.NoError(t, err)
+			assert.True(t, tc.want.Equal(mid), "got %s", mid)
+		})
+	}
+}
+
+func TestSnapshotVersionStatsRowsSumsPlatforms(t *testing.T) {
+	rows := snapshotVersionStatsRows([]deviceSnapshotRow{
+		{Platform: "android", Version: "2.24.0", Devices: 3},
+		{Platform: "android", Version: "2.25.0", Devices: 4},
+		{Platform: "ios", Version: "2.24.0", Devices: 1},
+		{Platform: "ios", Version: "2.23.0", Devices: 4},
+	})
+
+	assert.Equal(t, []versionStatsRow{
+		{Version: "2.23.0", Count: 4},
+		{Version: "2.24.0", Count: 4},
+		{Version: "2.25.0", Count: 4},
+	}, rows)
+}
+
+func TestAdminStatsVersionsReadsDeviceSnapshot(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	expectDeviceSnapshot(mock,
+		[]driver.Value{"android", "2.25.0", 6, 5},
+		[]driver.Value{"ios", "2.22.0", 3, 0})
+	mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND "app_versions"."deleted_at" IS NULL ORDER BY ` + regexp.QuoteMeta(versionOrderSQL)).
+		WithArgs(true).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}))
+	mock.ExpectQuery(`SELECT \* FROM "min_supported_versions" ORDER BY channel, platform`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "channel", "platform", "min_version"}).
+			AddRow(1, "stable", "", "2.26.0"))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/versions", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminStatsVersions(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Items             []versionStatsRow `json:"items"`
+		BelowFloorDevices int64             `json:"belowFloorDevices"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, []versionStatsRow{{Version: "2.25.0", Count: 6}, {Version: "2.22.0", Count: 3}}, body.Items)
+	// Only the devices active in the snapshot window are checked against floors
+	assert.Equal(t, int64(5), body.BelowFloorDevices)
+}
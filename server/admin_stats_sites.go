@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// siteTrendTemplates is the number of most configured templates whose daily
+// snapshots /stats/sites returns.
+const siteTrendTemplates = 10
+
+// siteCount is the number of active devices configuring a site template.
+// TemplateID is empty for custom sites.
+type siteCount struct {
+	TemplateID string `json:"template_id"`
+	SiteType   string `json:"site_type"`
+	Devices    int64  `json:"devices"`
+}
+
+// siteTypeCount is the number of active devices with a site of a type.
+type siteTypeCount struct {
+	SiteType string `json:"site_type"`
+	Devices  int64  `json:"devices"`
+}
+
+// siteTrendRow is a day of stats_daily_sites or, without TemplateID, of
+// stats_daily_site_types.
+type siteTrendRow struct {
+	Day        string `json:"day"`
+	TemplateID string `json:"template_id,omitempty"`
+	SiteType   string `json:"site_type"`
+	Devices    int64  `json:"devices"`
+}
+
+// siteStats is the response of /stats/sites.
+type siteStats struct {
+	// ReportingDevices counts the active devices that reported their sites
+	ReportingDevices int64           `json:"reporting_devices"`
+	Templates        []siteCount     `json:"templates"`
+	SiteTypes        []siteTypeCount `json:"site_types"`
+	SiteTypeTrend    []siteTrendRow  `json:"site_type_trend"`
+	TemplateTrend    []siteTrendRow  `json:"template_trend"`
+}
+
+// activeDeviceSites joins device_sites to the devices seen since its
+// parameter that are not dormant.
+const activeDeviceSites = `FROM device_sites ds
+JOIN app_statistics s ON s.device_id = ds.device_id
+WHERE s.dormant_at IS NULL AND s.last_seen >= ?`
+
+// loadSiteCounts counts the active devices per template and per site type.
+func loadSiteCounts(db *gorm.DB, stats *siteStats, activeSince time.Time) error {
+	if err := db.Raw("SELECT COUNT(DISTINCT ds.device_id) "+activeDeviceSites, activeSince).
+		Scan(&stats.ReportingDevices).Error; err != nil {
+		return err
+	}
+	if err := db.Raw(`SELECT ds.template_id, ds.site_type, COUNT(*) AS devices
+`+activeDeviceSites+`
+GROUP BY ds.template_id, ds.site_type
+ORDER BY devices DESC, ds.template_id, ds.site_type`, activeSince).Scan(&stats.Templates).Error; err != nil {
+		return err
+	}
+	return db.Raw(`SELECT ds.site_type, COUNT(DISTINCT ds.device_id) AS devices
+`+activeDeviceSites+`
+GROUP BY ds.site_type
+ORDER BY devices DESC, ds.site_type`, activeSince).Scan(&stats.SiteTypes).Error
+}
+
+// loadSiteTrend reads the daily snapshots in [from, to) of every site type
+// and of the templates.
+func loadSiteTrend(db *gorm.DB, stats *siteStats, from, to time.Time, templates []string) error {
+	var rows []struct {
+		Day        time.Time
+		TemplateID string
+		SiteType   string
+		Devices    int64
+	}
+	if err := db.Raw(`SELECT day, site_type, devices
+FROM stats_daily_site_types
+WHERE day >= (?::date) AND day < (?::date)
+ORDER BY day, site_type`, from, to).Scan(&rows).Error; err != nil {
+		return err
+	}
+	for _, r := range rows {
+		stats.SiteTypeTrend = append(stats.SiteTypeTrend, siteTrendRow{Day: r.Day.Format("2006-01-02"), SiteType: r.SiteType, Devices: r.Devices})
+	}
+	if len(templates) == 0 {
+		return nil
+	}
+	rows = nil
+	if err := db.Raw(`SELECT day, template_id, site_type, devices
+FROM stats_daily_sites
+WHERE day >= (?::date) AND day < (?::date) AND template_id IN ?
+ORDER BY day, template_id, site_type`, from, to, templates).Scan(&rows).Error; err != nil {
+		return err
+	}
+	for _, r := range rows {
+		stats.TemplateTrend = append(stats.TemplateTrend, siteTrendRow{Day: r.Day.Format("2006-01-02"), TemplateID: r.TemplateID, SiteType: r.SiteType, Devices: r.Devices})
+	}
+	return nil
+}
+
+// GET /api/v1/admin/stats/sites
+// Query: window (7d, 30d or custom with from / to) of the trends. Counts are
+// of the devices active in the last 30 days; the trends come from the daily
+// snapshots and follow the templates most configured today.
+func AdminStatsSites(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := parseRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时间范围不合法"})
+			return
+		}
+		stats := siteStats{
+			Templates:     []siteCount{},
+			SiteTypes:     []siteTypeCount{},
+			SiteTypeTrend: []siteTrendRow{},
+			TemplateTrend: []siteTrendRow{},
+		}
+		if err := loadSiteCounts(db, &stats, nowUTC().Add(-activeDeviceWindow)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch site stats"})
+			return
+		}
+		var templates []string
+		seen := make(map[string]bool)
+		for _, t := range stats.Templates {
+			if t.TemplateID != "" && !seen[t.TemplateID] && len(templates) < siteTrendTemplates {
+				seen[t.TemplateID] = true
+				templates = append(templates, t.TemplateID)
+			}
+		}
+		if err := loadSiteTrend(db, &stats, from, to, templates); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch site stats"})
+			return
+		}
+		c.JSON(http.StatusOK, stats)
+	}
+}
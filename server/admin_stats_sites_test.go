@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminStatsSites(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(DISTINCT ds.device_id) FROM device_sites ds
+JOIN app_statistics s ON s.device_id = ds.device_id
+WHERE s.dormant_at IS NULL AND s.last_seen >= $1`)).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(30))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT ds.template_id, ds.site_type, COUNT(*) AS devices`)).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"template_id", "site_type", "devices"}).
+			AddRow("mteam", "M-Team", 21).
+			AddRow("", "NexusPHPWeb", 9).
+			AddRow("afun", "NexusPHPWeb", 4))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT ds.site_type, COUNT(DISTINCT ds.device_id) AS devices`)).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"site_type", "devices"}).
+			AddRow("M-Team", 21).
+			AddRow("NexusPHPWeb", 11))
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, analyticsZone.Location)
+	to := time.Date(2024, 5, 3, 0, 0, 0, 0, analyticsZone.Location)
+	day := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT day, site_type, devices
+FROM stats_daily_site_types
+WHERE day >= ($1::date) AND day < ($2::date)`)).
+		WithArgs(from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "site_type", "devices"}).AddRow(day, "M-Team", 20))
+	// Custom sites have no template to follow
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT day, template_id, site_type, devices
+FROM stats_daily_sites
+WHERE day >= ($1::date) AND day < ($2::date) AND template_id IN ($3,$4)`)).
+		WithArgs(from, to, "mteam", "afun").
+		WillReturnRows(sqlmock.NewRows([]string{"day", "template_id", "site_type", "devices"}).AddRow(day, "mteam", "M-Team", 20))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/sites?window=custom&from=2024-05-01&to=2024-05-02", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminStatsSites(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body siteStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, int64(30), body.ReportingDevices)
+	assert.Len(t, body.Templates, 3)
+	assert.Equal(t, []siteTypeCount{{SiteType: "M-Team", Devices: 21}, {SiteType: "NexusPHPWeb", Devices: 11}}, body.SiteTypes)
+	assert.Equal(t, []siteTrendRow{{Day: "2024-05-02", SiteType: "M-Team", Devices: 20}}, body.SiteTypeTrend)
+	assert.Equal(t, []siteTrendRow{{Day: "2024-05-02", TemplateID: "mteam", SiteType: "M-Team", Devices: 20}}, body.TemplateTrend)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -14,6 +14,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+
+	"server/analytics"
+	"server/privacy"
 )
 
 func newMockGormDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock, func()) {
@@ -68,8 +71,10 @@ func TestAdminStatsVersionsReturnsTopNAndOther(t *testing.T) {
 	db, mock, cleanup := newMockGormDB(t)
 	defer cleanup()
 
+	expectDeviceSnapshot(mock)
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT s.app_version AS version, COUNT(*) AS count
 FROM app_statistics s
+WHERE s.dormant_at IS NULL
 GROUP BY s.app_version
 ORDER BY COUNT(*) DESC, s.app_version ASC`)).
 		WillReturnRows(sqlmock.NewRows([]string{"version", "count"}).
@@ -77,6 +82,19 @@ ORDER BY COUNT(*) DESC, s.app_version ASC`)).
 			AddRow("2.24.0", 4).
 			AddRow("2.23.0", 3).
 			AddRow("2.22.0", 2))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT s.platform AS platform, s.app_version AS version, COUNT(*) AS count
+FROM app_statistics s
+WHERE s.last_seen >= $1
+GROUP BY s.platform, s.app_version`)).
+		WillReturnRows(sqlmock.NewRows([]string{"platform", "version", "count"}).
+			AddRow("android", "2.25.0", 5).
+			AddRow("ios", "2.22.0", 2))
+	mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND "app_versions"."deleted_at" IS NULL ORDER BY ` + regexp.QuoteMeta(versionOrderSQL)).
+		WithArgs(true).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}))
+	mock.ExpectQuery(`SELECT \* FROM "min_supported_versions" ORDER BY channel, platform`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "channel", "platform", "min_version"}).
+			AddRow(1, "stable", "", "2.23.0"))
 
 	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/versions?limit=2", nil)
 	w := httptest.NewRecorder()
@@ -88,9 +106,11 @@ ORDER BY COUNT(*) DESC, s.app_version ASC`)).
 	require.Equal(t, http.StatusOK, w.Code)
 
 	var body struct {
-		Items []versionStatsRow `json:"items"`
+		Items             []versionStatsRow `json:"items"`
+		BelowFloorDevices int64             `json:"belowFloorDevices"`
 	}
 	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, int64(2), body.BelowFloorDevices)
 	require.Len(t, body.Items, 3)
 	assert.Equal(t, versionStatsRow{Version: "2.25.0", Count: 5}, body.Items[0])
 	assert.Equal(t, versionStatsRow{Version: "2.24.0", Count: 4}, body.Items[1])
@@ -103,8 +123,10 @@ func TestAdminStatsDevicesOtherBucketFiltersOutTopVersions(t *testing.T) {
 	defer cleanup()
 
 	mock.MatchExpectationsInOrder(true)
+	expectDeviceSnapshot(mock)
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT s.app_version AS version, COUNT(*) AS count
 FROM app_statistics s
+WHERE s.dormant_at IS NULL
 GROUP BY s.app_version
 ORDER BY COUNT(*) DESC, s.app_version ASC`)).
 		WillReturnRows(sqlmock.NewRows([]string{"version", "count"}).
@@ -142,3 +164,105 @@ ORDER BY COUNT(*) DESC, s.app_version ASC`)).
 	assert.Equal(t, "device-1", body.Items[0].DeviceID)
 	assert.Equal(t, "2.23.0", body.Items[0].AppVersion)
 }
+
+func TestAdminStatsTrendDAUSplitsDevicesAndCountsChurn(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	loc := analyticsZone.Location
+	from := time.Date(2024, 5, 10, 0, 0, 0, 0, loc)
+	to := time.Date(2024, 5, 17, 0, 0, 0, 0, loc)
+
+	// Rolled through May 12th: earlier days come from the rollups
+	mid := time.Date(2024, 5, 13, 0, 0, 0, 0, loc)
+	expectRolledThrough(mock, time.Date(2024, 5, 12, 0, 0, 0, 0, time.UTC))
+	mock.ExpectQuery(`(?s)FROM stats_daily_activity.*LEFT JOIN \(\s+SELECT day, SUM\(devices\) AS resurrected\s+FROM stats_daily_returns`).
+		WithArgs(from, mid, from, mid, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"date", "count", "new", "returning", "resurrected"}).
+			AddRow(from, 8, 1, 6, 1))
+	mock.ExpectQuery(`WITH days AS \(.*LAG\(seen_date\)`).
+		WithArgs(mid.AddDate(0, 0, -7), to, analyticsZone.Name, mid, 7, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"date", "count", "new", "returning", "resurrected"}).
+			AddRow(mid, 10, 3, 5, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(DISTINCT device_id) FROM app_activity`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM app_statistics WHERE last_seen >= $1 AND last_seen < $2`)).
+		WithArgs(time.Date(2024, 5, 2, 0, 0, 0, 0, loc), time.Date(2024, 5, 9, 0, 0, 0, 0, loc)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/trend/dau?window=custom&from=2024-05-10&to=2024-05-16&gap=7", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminStatsTrendDAU(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Items         []deviceTrendRow `json:"items"`
+		WindowDevices int64            `json:"windowDevices"`
+		Churned       int64            `json:"churned"`
+		Gap           int              `json:"gap"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Len(t, body.Items, 2)
+	assert.Equal(t, int64(6), body.Items[0].Returning)
+	assert.Equal(t, int64(3), body.Items[1].New)
+	assert.Equal(t, int64(5), body.Items[1].Returning)
+	assert.Equal(t, int64(2), body.Items[1].Resurrected)
+	assert.Equal(t, int64(12), body.WindowDevices)
+	assert.Equal(t, int64(4), body.Churned)
+	assert.Equal(t, 7, body.Gap)
+}
+
+func TestParseRangeUsesAnalyticsZone(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	saved := analyticsZone
+	defer func() { analyticsZone = saved }()
+	zone, err := analytics.LoadZone("America/New_York")
+	require.NoError(t, err)
+	analyticsZone = zone
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/overview?window=custom&from=2024-03-09&to=2024-03-10", nil)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+
+	from, to, err := parseRange(c)
+
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 9, 5, 0, 0, 0, time.UTC), from.UTC())
+	// The DST switch makes the two days 47 hours long
+	assert.Equal(t, time.Date(2024, 3, 11, 4, 0, 0, 0, time.UTC), to.UTC())
+}
+
+func TestAdminStatsDevicesSearchesHashedIPs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	policy, err := privacy.NewIPPolicy(privacy.IPHash, "secret", 0)
+	require.NoError(t, err)
+	defer func(p *privacy.IPPolicy) { clientIPPolicy = p }(clientIPPolicy)
+	clientIPPolicy = policy
+
+	hashed := policy.Anonymize("203.0.113.57")
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "app_statistics" WHERE device_id ILIKE $1 OR ip = $2`)).
+		WithArgs("%203.0.113.57%", hashed).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT .* FROM "app_statistics" WHERE device_id ILIKE \$1 OR ip = \$2 ORDER BY last_seen DESC LIMIT \$3`).
+		WithArgs("%203.0.113.57%", hashed, 20).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"device_id", "platform", "app_version", "first_seen", "last_seen", "total_launches", "ip",
+		}).AddRow("device-1", "android", "2.30.0", time.Now(), time.Now(), 3, hashed))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/stats/devices?q=203.0.113.57", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	AdminStatsDevices(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"device-1"`)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AltSourceConfig is the static metadata of the AltStore / SideStore source.
+// It uses the same keys as altsource/config.json.
+type AltSourceConfig struct {
+	RepoURL              string `json:"repo_url"`
+	SourceID             string `json:"source_id"`
+	AppID                string `json:"app_id"`
+	AppName              string `json:"app_name"`
+	DeveloperName        string `json:"developer_name"`
+	Subtitle             string `json:"subtitle"`
+	LocalizedDescription string `json:"localized_description"`
+	Category             string `json:"category"`
+	ScreenshotCount      int    `json:"screenshot_count"`
+	Caption              string `json:"caption"`
+	TintColour           string `json:"tint_colour"`
+	ImageURL             string `json:"image_url"`
+	// SourceURL is the public URL of this endpoint; AltStore uses it to refresh the source
+	SourceURL string `json:"source_url"`
+	// RawBaseURL hosts the icon and screenshots; defaults to the repo's master branch
+	RawBaseURL string `json:"raw_base_url"`
+}
+
+func defaultAltSourceConfig() AltSourceConfig {
+	return AltSourceConfig{
+		RepoURL:              "JustLookAtNow/pt_mate",
+		SourceID:             "com.github.justlookatnow.ptmate.source",
+		AppID:                "com.github.justlookatnow.ptmate",
+		AppName:              "PT Mate",
+		DeveloperName:        "JustLookAtNow",
+		Subtitle:             "PT private tracker companion",
+		LocalizedDescription: "PT Mate is a Flutter-based private tracker client for browsing, searching, and managing downloads across multiple PT sites.",
+		Category:             "utilities",
+		ScreenshotCount:      3,
+		Caption:              "Update for PT Mate now available!",
+		TintColour:           "1D4ED8",
+		ImageURL:             "https://raw.githubusercontent.com/JustLookAtNow/pt_mate/refs/heads/master/screenshots/1.png",
+	}
+}
+
+// loadAltSourceConfig reads the file at ALTSOURCE_CONFIG, or altsource/config.json
+// when running from the repository, over the built-in defaults.
+func loadAltSourceConfig() (AltSourceConfig, error) {
+	cfg := defaultAltSourceConfig()
+	paths := []string{"altsource/config.json", "../altsource/config.json"}
+	if p := os.Getenv("ALTSOURCE_CONFIG"); p != "" {
+		paths = []string{p}
+	}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) && os.Getenv("ALTSOURCE_CONFIG") == "" {
+			continue
+		}
+		if err != nil {
+			return cfg, err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %w", p, err)
+		}
+		break
+	}
+	if cfg.RawBaseURL == "" {
+		cfg.RawBaseURL = "https://raw.githubusercontent.com/" + cfg.RepoURL + "/refs/heads/master"
+	}
+	if s := os.Getenv("ALTSOURCE_SOURCE_URL"); s != "" {
+		cfg.SourceURL = s
+	}
+	return cfg, nil
+}
+
+type altSourceVersion struct {
+	Version              string `json:"version"`
+	Date                 string `json:"date"`
+	LocalizedDescription string `json:"localizedDescription"`
+	DownloadURL          string `json:"downloadURL"`
+	Size                 int64  `json:"size"`
+}
+
+var (
+	htmlTagPattern        = regexp.MustCompile(`<[^<]+?>`)
+	markdownHeaderPattern = regexp.MustCompile(`#{1,6}\s?`)
+	numericVersionPattern = regexp.MustCompile(`\d+(\.\d+)*`)
+)
+
+// formatAltSourceDescription strips HTML tags and Markdown headers, which
+// AltStore renders as plain text.
+func formatAltSourceDescription(s string) string {
+	s = htmlTagPattern.ReplaceAllString(s, "")
+	s = markdownHeaderPattern.ReplaceAllString(s, "")
+	return strings.TrimSpace(s)
+}
+
+// altSourceVersionString keeps the numeric part of a version, which is what
+// the IPA reports as CFBundleShortVersionString. Pre-releases of a version
+// share it, so releases are told apart by their full version.
+func altSourceVersionString(v string) string {
+	v = strings.TrimPrefix(v, "v")
+	if m := numericVersionPattern.FindString(v); m != "" {
+		return m
+	}
+	return v
+}
+
+// buildAltSource assembles the source document. versions must be ordered
+// newest first with their assets attached.
+func buildAltSource(cfg AltSourceConfig, versions []AppVersion, beta bool) gin.H {
+	entries := []altSourceVersion{}
+	seen := make(map[string]bool)
+	var latest *AppVersion
+	var latestIPA *ReleaseAsset
+	for i := range versions {
+		v := &versions[i]
+		ipa := selectReleaseAsset(v.Assets, "ios", "", "ipa")
+		if ipa == nil {
+			continue
+		}
+		// Several betas of a version are distinct releases of the beta feed
+		full := strings.TrimPrefix(v.Version, "v")
+		if seen[full] {
+			continue
+		}
+		seen[full] = true
+		if latest == nil {
+			latest, latestIPA = v, ipa
+		}
+		entries = append(entries, altSourceVersion{
+			Version:              altSourceVersionString(v.Version),
+			Date:                 v.CreatedAt.UTC().Format(time.RFC3339),
+			LocalizedDescription: formatAltSourceDescription(v.ReleaseNotes),
+			DownloadURL:          ipa.URL,
+			Size:                 ipa.Size,
+		})
+	}
+
+	sourceID, name := cfg.SourceID, cfg.AppName
+	if beta {
+		sourceID += ".beta"
+		name += " Beta"
+	}
+
+	apps := []gin.H{}
+	news := []gin.H{}
+	if latest != nil {
+		screenshots := make([]string, 0, cfg.ScreenshotCount)
+		for i := 1; i <= cfg.ScreenshotCount; i++ {
+			screenshots = append(screenshots, fmt.Sprintf("%s/screenshots/%d.png", cfg.RawBaseURL, i))
+		}
+		date := latest.CreatedAt.UTC().Format(time.RFC3339)
+		release := strings.TrimPrefix(latest.Version, "v")
+		apps = append(apps, gin.H{
+			"beta":                 beta,
+			"name":                 cfg.AppName,
+			"bundleIdentifier":     cfg.AppID,
+			"developerName":        cfg.DeveloperName,
+			"subtitle":             cfg.Subtitle,
+			"version":              entries[0].Version,
+			"versionDate":          date,
+			"versionDescription":   entries[0].LocalizedDescription,
+			"downloadURL":          latestIPA.URL,
+			"localizedDescription": cfg.LocalizedDescription,
+			"iconURL":              cfg.RawBaseURL + "/mt.png",
+			"tintColor":            cfg.TintColour,
+			"category":             cfg.Category,
+			"size":                 latestIPA.Size,
+			"screenshotURLs":       screenshots,
+			"versions":             entries,
+			"appPermissions": gin.H{
+				"entitlements": []string{},
+				"privacy":      gin.H{},
+			},
+		})
+		news = append(news, gin.H{
+			"appID":      cfg.AppID,
+			"title":      release + " - " + latest.CreatedAt.UTC().Format("02 Jan"),
+			"identifier": "release-" + release,
+			"caption":    cfg.Caption,
+			"date":       date,
+			"tintColor":  cfg.TintColour,
+			"imageURL":   cfg.ImageURL,
+			"notify":     true,
+			"url":        latest.DownloadURL,
+		})
+	}
+
+	return gin.H{
+		"name":       name,
+		"identifier": sourceID,
+		"sourceURL":  cfg.SourceURL,
+		"headerURL":  cfg.RawBaseURL + "/screenshots/1.png",
+		"website":    "https://github.com/" + cfg.RepoURL,
+		"iconURL":    cfg.RawBaseURL + "/mt.png",
+		"subtitle":   cfg.Subtitle,
+		"description": fmt.Sprintf("This is the official source for %s.\n\nFor full details, check the GitHub repository:\nhttps://github.com/%s",
+			cfg.AppName, cfg.RepoURL),
+		"tintColor": cfg.TintColour,
+		"apps":      apps,
+		"news":      news,
+	}
+}
+
+// AltSource serves a live AltStore / SideStore source built from published,
+// fully rolled out iOS releases.
+// GET /api/v1/altsource (stable) and /api/v1/altsource/beta (includes pre-releases)
+func AltSource(db *gorm.DB, cfg AltSourceConfig, beta bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		released, err := loadFeedReleases(db, "ios", beta)
+		if err != nil {
+			log.Printf("Failed to load releases for AltSource: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build source"})
+			return
+		}
+
+		// cfg is shared by every request: the URL of this one goes in a copy
+		source := cfg
+		if source.SourceURL == "" {
+			source.SourceURL = requestURL(c)
+		}
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, buildAltSource(source, released, beta))
+	}
+}
+
+// requestURL reconstructs the public URL of the current request, honoring
+// the scheme set by a TLS-terminating proxy.
+func requestURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if p := c.GetHeader("X-Forwarded-Proto"); p != "" {
+		scheme = p
+	}
+	return scheme + "://" + c.Request.Host + c.Request.URL.Path
+}
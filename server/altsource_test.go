@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAltSourceConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"app_name":"PT Mate Dev","screenshot_count":1,"json_file":"ignored"}`), 0o600))
+	t.Setenv("ALTSOURCE_CONFIG", path)
+	t.Setenv("ALTSOURCE_SOURCE_URL", "https://updates.example.com/api/v1/altsource")
+
+	cfg, err := loadAltSourceConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "PT Mate Dev", cfg.AppName)
+	assert.Equal(t, 1, cfg.ScreenshotCount)
+	// Keys missing from the file keep their defaults
+	assert.Equal(t, "com.github.justlookatnow.ptmate", cfg.AppID)
+	assert.Equal(t, "https://raw.githubusercontent.com/JustLookAtNow/pt_mate/refs/heads/master", cfg.RawBaseURL)
+	assert.Equal(t, "https://updates.example.com/api/v1/altsource", cfg.SourceURL)
+
+	t.Setenv("ALTSOURCE_CONFIG", filepath.Join(t.TempDir(), "missing.json"))
+	_, err = loadAltSourceConfig()
+	assert.Error(t, err)
+}
+
+func TestBuildAltSource(t *testing.T) {
+	cfg := defaultAltSourceConfig()
+	cfg.RawBaseURL = "https://raw.example.com"
+	cfg.SourceURL = "https://updates.example.com/api/v1/altsource/beta"
+	cfg.ScreenshotCount = 2
+
+	day := func(d int) time.Time { return time.Date(2026, 8, d, 12, 0, 0, 0, time.UTC) }
+	ipa := func(url string, size int64) []ReleaseAsset {
+		return []ReleaseAsset{
+			{Platform: "android", Arch: "arm64", Format: "apk", URL: url + ".apk"},
+			{Platform: "ios", Format: "ipa", URL: url + ".ipa", Size: size},
+		}
+	}
+	versions := []AppVersion{
+		{ID: 5, Version: "2.30.0-beta.2", ReleaseNotes: "Second beta", DownloadURL: "https://gh/v2.30.0-beta.2", CreatedAt: day(22), Assets: ipa("https://dl/2.30.0-beta.2", 310)},
+		{ID: 4, Version: "2.30.0-beta.1", ReleaseNotes: "## Beta\n<b>try</b> it", DownloadURL: "https://gh/v2.30.0-beta.1", CreatedAt: day(20), Assets: ipa("https://dl/2.30.0-beta.1", 300)},
+		{ID: 3, Version: "2.29.1", ReleaseNotes: "Android only", CreatedAt: day(18), Assets: ipa("https://dl/2.29.1", 0)[:1]},
+		{ID: 2, Version: "2.29.0", ReleaseNotes: "Stable", DownloadURL: "https://gh/v2.29.0", CreatedAt: day(10), Assets: ipa("https://dl/2.29.0", 200)},
+	}
+
+	src := buildAltSource(cfg, versions, true)
+
+	assert.Equal(t, "PT Mate Beta", src["name"])
+	assert.Equal(t, "com.github.justlookatnow.ptmate.source.beta", src["identifier"])
+	assert.Equal(t, cfg.SourceURL, src["sourceURL"])
+
+	apps := src["apps"].([]gin.H)
+	require.Len(t, apps, 1)
+	app := apps[0]
+	assert.Equal(t, true, app["beta"])
+	assert.Equal(t, "2.30.0", app["version"])
+	assert.Equal(t, "2026-08-22T12:00:00Z", app["versionDate"])
+	assert.Equal(t, "Second beta", app["versionDescription"])
+	assert.Equal(t, "https://dl/2.30.0-beta.2.ipa", app["downloadURL"])
+	assert.Equal(t, int64(310), app["size"])
+	assert.Equal(t, []string{"https://raw.example.com/screenshots/1.png", "https://raw.example.com/screenshots/2.png"}, app["screenshotURLs"])
+	// Releases without an IPA are skipped; betas of one version are all kept
+	assert.Equal(t, []altSourceVersion{
+		{Version: "2.30.0", Date: "2026-08-22T12:00:00Z", LocalizedDescription: "Second beta", DownloadURL: "https://dl/2.30.0-beta.2.ipa", Size: 310},
+		{Version: "2.30.0", Date: "2026-08-20T12:00:00Z", LocalizedDescription: "Beta\ntry it", DownloadURL: "https://dl/2.30.0-beta.1.ipa", Size: 300},
+		{Version: "2.29.0", Date: "2026-08-10T12:00:00Z", LocalizedDescription: "Stable", DownloadURL: "https://dl/2.29.0.ipa", Size: 200},
+	}, app["versions"])
+
+	news := src["news"].([]gin.H)
+	require.Len(t, news, 1)
+	assert.Equal(t, "2.30.0-beta.2 - 22 Aug", news[0]["title"])
+	assert.Equal(t, "release-2.30.0-beta.2", news[0]["identifier"])
+	assert.Equal(t, "https://gh/v2.30.0-beta.2", news[0]["url"])
+}
+
+func TestBuildAltSourceWithoutIPAs(t *testing.T) {
+	src := buildAltSource(defaultAltSourceConfig(), []AppVersion{{ID: 1, Version: "1.0.0"}}, false)
+	assert.Equal(t, "PT Mate", src["name"])
+	assert.Empty(t, src["apps"])
+	assert.Empty(t, src["news"])
+}
+
+func TestAltSourceKeepsConfiguredURLPerRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+	mock.MatchExpectationsInOrder(false)
+
+	handler := AltSource(db, defaultAltSourceConfig(), false)
+	for _, host := range []string{"first.example.com", "second.example.com"} {
+		mock.ExpectQuery(`SELECT \* FROM "app_versions"`).WillReturnRows(sqlmock.NewRows([]string{"id", "version"}))
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/altsource", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+
+		handler(c)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var src struct {
+			SourceURL string `json:"sourceURL"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &src))
+		// Each request sees its own host, not the one of the first request
+		assert.Equal(t, "http://"+host+"/api/v1/altsource", src.SourceURL)
+	}
+}
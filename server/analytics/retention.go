@@ -0,0 +1,239 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ActivityPurge is the name of the app_activity purge in stats_rollup_state.
+// Its rolled_through date is the last day whose raw activity may have been
+// deleted; the rollups of that day and earlier can no longer be recomputed.
+const ActivityPurge = "activity_purge"
+
+// purgeBatch is the number of app_activity rows deleted per statement.
+const purgeBatch = 10000
+
+// DeviceTables lists the tables holding rows of a single device by device_id,
+// in the order they are deleted. app_statistics comes last: it selects the
+// devices deleted with PurgeDevices. The rollup tables other than
+// stats_device_firsts only hold counts.
+var DeviceTables = []string{"app_activity", "client_events", "device_sites", "crash_reports", "stats_device_firsts", "device_tokens", "app_statistics"}
+
+// Retention configures Purge. Zero days keep the data for ever.
+type Retention struct {
+	// ActivityDays is how long app_activity rows are kept once rolled up. It
+	// is at least MaxAwayDays, the look-back of stats_daily_returns.
+	ActivityDays int
+	// DeviceDays is how long a device may go unseen before it is marked
+	// dormant, or deleted from every table of DeviceTables with PurgeDevices.
+	DeviceDays   int
+	PurgeDevices bool
+}
+
+// NewRetention validates a retention; mode is "mark" or "purge".
+func NewRetention(activityDays, deviceDays int, mode string) (Retention, error) {
+	if activityDays < 0 || (activityDays > 0 && activityDays < MaxAwayDays) {
+		return Retention{}, fmt.Errorf("activity retention must be 0 or at least %d days, got %d", MaxAwayDays, activityDays)
+	}
+	if deviceDays < 0 {
+		return Retention{}, fmt.Errorf("invalid device retention %d", deviceDays)
+	}
+	ret := Retention{ActivityDays: activityDays, DeviceDays: deviceDays}
+	switch mode {
+	case "", "mark":
+	case "purge":
+		ret.PurgeDevices = true
+	default:
+		return Retention{}, fmt.Errorf("invalid device retention mode %q, want mark or purge", mode)
+	}
+	return ret, nil
+}
+
+// RetentionFromEnv loads ACTIVITY_RETENTION_DAYS, DEVICE_RETENTION_DAYS and
+// DEVICE_RETENTION_MODE, keeping everything by default.
+func RetentionFromEnv() (Retention, error) {
+	days := func(name string) (int, error) {
+		raw := strings.TrimSpace(os.Getenv(name))
+		if raw == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q", name, raw)
+		}
+		return n, nil
+	}
+	activityDays, err := days("ACTIVITY_RETENTION_DAYS")
+	if err != nil {
+		return Retention{}, err
+	}
+	deviceDays, err := days("DEVICE_RETENTION_DAYS")
+	if err != nil {
+		return Retention{}, err
+	}
+	return NewRetention(activityDays, deviceDays, strings.ToLower(strings.TrimSpace(os.Getenv("DEVICE_RETENTION_MODE"))))
+}
+
+// Enabled reports whether the retention deletes or marks anything.
+func (ret Retention) Enabled() bool {
+	return ret.ActivityDays > 0 || ret.DeviceDays > 0
+}
+
+// PurgeResult reports what Purge deleted or, in a dry run, would delete.
+type PurgeResult struct {
+	// ActivityBefore is the first day of app_activity kept; it is zero when
+	// no activity is old enough or rolled up yet.
+	ActivityBefore time.Time
+	Activity       int64
+	DormantDevices int64
+	PurgedDevices  int64
+}
+
+// purgedThrough returns the last day whose activity may have been purged.
+func (r *Roller) purgedThrough() (*time.Time, error) {
+	through, err := RolledThrough(r.db, ActivityPurge)
+	if err != nil || through == nil {
+		return nil, err
+	}
+	day := r.zone.DateValue(*through)
+	return &day, nil
+}
+
+// activityCutoff returns the first day of app_activity to keep, or the zero
+// time when nothing may be deleted. Only rolled days go, and never the
+// MaxAwayDays before the watermark: the watermark day is rolled again and
+// looks back that far for the previous visit of its devices.
+func (r *Roller) activityCutoff(ret Retention, now time.Time) (time.Time, error) {
+	if ret.ActivityDays == 0 {
+		return time.Time{}, nil
+	}
+	state, err := loadState(r.db, ActivityRollup)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := r.checkZone(state); err != nil {
+		return time.Time{}, err
+	}
+	if state.RolledThrough == nil {
+		return time.Time{}, nil
+	}
+	cutoff := r.zone.Day(now).AddDate(0, 0, -ret.ActivityDays)
+	if limit := r.zone.DateValue(*state.RolledThrough).AddDate(0, 0, -MaxAwayDays); limit.Before(cutoff) {
+		cutoff = limit
+	}
+	return cutoff, nil
+}
+
+// Purge applies the retention at now. In a dry run it only counts the rows.
+func (r *Roller) Purge(ret Retention, now time.Time, dryRun bool) (PurgeResult, error) {
+	var res PurgeResult
+	cutoff, err := r.activityCutoff(ret, now)
+	if err != nil {
+		return res, err
+	}
+	if !cutoff.IsZero() {
+		res.ActivityBefore = cutoff
+		if res.Activity, err = r.purgeActivity(cutoff, now, dryRun); err != nil {
+			return res, err
+		}
+	}
+
+	if ret.DeviceDays == 0 {
+		return res, nil
+	}
+	unseen := now.Add(-time.Duration(ret.DeviceDays) * 24 * time.Hour)
+	switch {
+	case dryRun && ret.PurgeDevices:
+		err = r.db.Raw("SELECT COUNT(*) FROM app_statistics WHERE last_seen < ?", unseen).Scan(&res.PurgedDevices).Error
+	case dryRun:
+		err = r.db.Raw("SELECT COUNT(*) FROM app_statistics WHERE dormant_at IS NULL AND last_seen < ?", unseen).Scan(&res.DormantDevices).Error
+	case ret.PurgeDevices:
+		res.PurgedDevices, err = r.purgeDevices(unseen)
+	default:
+		tx := r.db.Exec("UPDATE app_statistics SET dormant_at = ? WHERE dormant_at IS NULL AND last_seen < ?", now.UTC(), unseen)
+		res.DormantDevices, err = tx.RowsAffected, tx.Error
+	}
+	return res, err
+}
+
+// purgeActivity deletes app_activity before cutoff in batches. The purge
+// horizon is recorded first, so rollups of days that are partly deleted are
+// never recomputed.
+func (r *Roller) purgeActivity(cutoff, now time.Time, dryRun bool) (int64, error) {
+	d := cutoff.Format(dateLayout)
+	if dryRun {
+		var n int64
+		err := r.db.Raw("SELECT COUNT(*) FROM app_activity WHERE seen_date < (?::date)", d).Scan(&n).Error
+		return n, err
+	}
+
+	purged, err := r.purgedThrough()
+	if err != nil {
+		return 0, err
+	}
+	// A longer retention cannot bring deleted days back
+	if last := cutoff.AddDate(0, 0, -1); purged == nil || last.After(*purged) {
+		if err := r.setRolledThrough(r.db, ActivityPurge, last, now.UTC()); err != nil {
+			return 0, err
+		}
+	}
+	var deleted int64
+	for {
+		tx := r.db.Exec(`DELETE FROM app_activity WHERE id IN (
+    SELECT id FROM app_activity WHERE seen_date < (?::date) LIMIT ?
+)`, d, purgeBatch)
+		if tx.Error != nil {
+			return deleted, tx.Error
+		}
+		deleted += tx.RowsAffected
+		if tx.RowsAffected < purgeBatch {
+			return deleted, nil
+		}
+	}
+}
+
+// purgeDevices deletes the devices last seen before unseen from every table
+// of DeviceTables in one transaction, and returns their number.
+func (r *Roller) purgeDevices(unseen time.Time) (int64, error) {
+	var purged int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range DeviceTables {
+			if table == "app_statistics" {
+				continue
+			}
+			if err := tx.Exec("DELETE FROM "+table+" WHERE device_id IN (SELECT device_id FROM app_statistics WHERE last_seen < ?)", unseen).Error; err != nil {
+				return err
+			}
+		}
+		res := tx.Exec("DELETE FROM app_statistics WHERE last_seen < ?", unseen)
+		purged = res.RowsAffected
+		return res.Error
+	})
+	return purged, err
+}
+
+// RunPurge applies the retention every interval until ctx is done.
+func (r *Roller) RunPurge(ctx context.Context, ret Retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if res, err := r.Purge(ret, time.Now(), false); err != nil {
+			log.Printf("analytics purge failed: %v", err)
+		} else if res.Activity > 0 || res.DormantDevices > 0 || res.PurgedDevices > 0 {
+			log.Printf("analytics purge: deleted %d app_activity rows, marked %d devices dormant, deleted %d devices",
+				res.Activity, res.DormantDevices, res.PurgedDevices)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
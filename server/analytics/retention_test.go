@@ -0,0 +1,118 @@
+package analytics
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRetentionValidates(t *testing.T) {
+	_, err := NewRetention(90, 0, "")
+	assert.Error(t, err, "activity kept shorter than the returns look-back")
+	_, err = NewRetention(0, 180, "delete")
+	assert.Error(t, err)
+
+	ret, err := NewRetention(400, 180, "purge")
+	require.NoError(t, err)
+	assert.Equal(t, Retention{ActivityDays: 400, DeviceDays: 180, PurgeDevices: true}, ret)
+	assert.True(t, ret.Enabled())
+	assert.False(t, Retention{}.Enabled())
+
+	t.Setenv("ACTIVITY_RETENTION_DAYS", "")
+	t.Setenv("DEVICE_RETENTION_DAYS", "365")
+	t.Setenv("DEVICE_RETENTION_MODE", "")
+	ret, err = RetentionFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, Retention{DeviceDays: 365}, ret)
+}
+
+func TestPurgeDeletesRolledActivityAndMarksDevices(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, testZone.Location)
+	expectState(mock, time.Date(2025, 5, 31, 0, 0, 0, 0, time.UTC))
+	expectPurged(mock, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO stats_rollup_state`)).
+		WithArgs(ActivityPurge, "2024-04-26", testZone.Name, now.UTC()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM app_activity WHERE id IN (`)).
+		WithArgs("2024-04-27", purgeBatch).
+		WillReturnResult(sqlmock.NewResult(0, purgeBatch))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM app_activity WHERE id IN (`)).
+		WithArgs("2024-04-27", purgeBatch).
+		WillReturnResult(sqlmock.NewResult(0, 25))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE app_statistics SET dormant_at = $1 WHERE dormant_at IS NULL AND last_seen < $2`)).
+		WithArgs(now.UTC(), now.AddDate(0, 0, -180)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	res, err := r.Purge(Retention{ActivityDays: 400, DeviceDays: 180}, now, false)
+
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 4, 27, 0, 0, 0, 0, testZone.Location), res.ActivityBefore)
+	assert.Equal(t, int64(purgeBatch+25), res.Activity)
+	assert.Equal(t, int64(3), res.DormantDevices)
+}
+
+func TestPurgeKeepsLookBackOfUnrolledDays(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	// The rollup stalled on January 1st: activity from MaxAwayDays before it is kept
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, testZone.Location)
+	expectState(mock, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM app_activity WHERE seen_date < ($1::date)`)).
+		WithArgs("2024-01-01").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM app_statistics WHERE last_seen < $1`)).
+		WithArgs(now.AddDate(0, 0, -30)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+
+	res, err := r.Purge(Retention{ActivityDays: MaxAwayDays, DeviceDays: 30, PurgeDevices: true}, now, true)
+
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, testZone.Location), res.ActivityBefore)
+	assert.Equal(t, int64(42), res.Activity)
+	assert.Equal(t, int64(7), res.PurgedDevices)
+}
+
+func TestPurgeDeletesUnseenDevicesFromEveryTable(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, testZone.Location)
+	unseen := now.AddDate(0, 0, -30)
+	mock.ExpectBegin()
+	for _, table := range DeviceTables[:len(DeviceTables)-1] {
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM ` + table + ` WHERE device_id IN (SELECT device_id FROM app_statistics WHERE last_seen < $1)`)).
+			WithArgs(unseen).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM app_statistics WHERE last_seen < $1`)).
+		WithArgs(unseen).
+		WillReturnResult(sqlmock.NewResult(0, 7))
+	mock.ExpectCommit()
+
+	res, err := r.Purge(Retention{DeviceDays: 30, PurgeDevices: true}, now, false)
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), res.PurgedDevices)
+	assert.Equal(t, "app_statistics", DeviceTables[len(DeviceTables)-1])
+}
+
+func TestPurgeKeepsActivityBeforeFirstRollup(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	expectState(mock)
+
+	res, err := r.Purge(Retention{ActivityDays: 400}, time.Now(), false)
+
+	require.NoError(t, err)
+	assert.True(t, res.ActivityBefore.IsZero())
+	assert.Zero(t, res.Activity)
+}
@@ -0,0 +1,327 @@
+// Package analytics maintains the pre-aggregated tables behind the admin
+// stats: daily rollups of app_activity and daily snapshots of app_statistics
+// and device_sites, and purges the raw data past its retention. It is shared
+// by the server, which keeps the rollups current in the background, and the
+// migrate CLI, which backfills them.
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ActivityRollup is the name of the app_activity rollup in stats_rollup_state.
+// Its rolled_through date is the last day of stats_daily_activity,
+// stats_daily_returns and stats_device_firsts that is complete; later days
+// are read from app_activity.
+const ActivityRollup = "daily_activity"
+
+// DeviceSnapshot is the name of the app_statistics snapshot in stats_rollup_state.
+const DeviceSnapshot = "device_snapshot"
+
+// MaxAwayDays caps stats_daily_returns.away_days. Devices without an earlier
+// visit in app_activity are recorded with this value.
+const MaxAwayDays = 366
+
+// ActiveWindow is how recently a device must have been seen to count in
+// stats_daily_devices.active_devices.
+const ActiveWindow = 30 * 24 * time.Hour
+
+const dateLayout = "2006-01-02"
+
+// Roller writes the rollup tables, counting days in its zone.
+type Roller struct {
+	db   *gorm.DB
+	zone *Zone
+}
+
+// NewRoller returns a Roller bucketing days in zone.
+func NewRoller(db *gorm.DB, zone *Zone) *Roller {
+	return &Roller{db: db, zone: zone}
+}
+
+// rollupState is a row of stats_rollup_state. Zone is the time zone the
+// rolled days were counted in.
+type rollupState struct {
+	RolledThrough *time.Time
+	Zone          string
+}
+
+func loadState(db *gorm.DB, name string) (rollupState, error) {
+	var state rollupState
+	err := db.Raw("SELECT rolled_through, zone FROM stats_rollup_state WHERE name = ?", name).Scan(&state).Error
+	return state, err
+}
+
+// RolledThrough returns the last complete day of the named rollup, or nil when
+// it has never run.
+func RolledThrough(db *gorm.DB, name string) (*time.Time, error) {
+	state, err := loadState(db, name)
+	return state.RolledThrough, err
+}
+
+func (r *Roller) setRolledThrough(tx *gorm.DB, name string, day time.Time, now time.Time) error {
+	return tx.Exec(`INSERT INTO stats_rollup_state (name, rolled_through, zone, updated_at)
+VALUES (?, (?::date), ?, ?)
+ON CONFLICT (name) DO UPDATE
+SET rolled_through = EXCLUDED.rolled_through, zone = EXCLUDED.zone, updated_at = EXCLUDED.updated_at`,
+		name, day.Format(dateLayout), r.zone.Name, now).Error
+}
+
+// checkZone refuses to extend rollups that were counted in another time zone,
+// since app_activity then holds days of both zones until it is rebucketed.
+func (r *Roller) checkZone(state rollupState) error {
+	if state.Zone != "" && state.Zone != r.zone.Name {
+		return fmt.Errorf("rollups were counted in %s, not %s; run \"migrate rebucket\" first", state.Zone, r.zone.Name)
+	}
+	return nil
+}
+
+// RollDay recomputes the rollups of one day from app_activity.
+func (r *Roller) RollDay(tx *gorm.DB, day time.Time) error {
+	d := day.Format(dateLayout)
+	if err := tx.Exec("DELETE FROM stats_daily_activity WHERE day = (?::date)", d).Error; err != nil {
+		return err
+	}
+	// A device is new on the day its first_seen falls on
+	if err := tx.Exec(`INSERT INTO stats_daily_activity (day, platform, app_version, devices, new_devices)
+SELECT a.seen_date, a.platform, a.app_version, COUNT(*),
+    COUNT(*) FILTER (WHERE (s.first_seen AT TIME ZONE ?)::date >= a.seen_date)
+FROM app_activity a
+LEFT JOIN app_statistics s ON s.device_id = a.device_id
+WHERE a.seen_date = (?::date)
+GROUP BY a.seen_date, a.platform, a.app_version`, r.zone.Name, d).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Exec("DELETE FROM stats_daily_returns WHERE day = (?::date)", d).Error; err != nil {
+		return err
+	}
+	// Days since the previous visit of every device that is not new
+	if err := tx.Exec(`INSERT INTO stats_daily_returns (day, away_days, devices)
+SELECT seen_date, away_days, COUNT(*)
+FROM (
+    SELECT a.seen_date,
+        LEAST(COALESCE(a.seen_date - 1 - (
+            SELECT MAX(p.seen_date) FROM app_activity p
+            WHERE p.device_id = a.device_id AND p.seen_date < a.seen_date
+        ), ?), ?) AS away_days
+    FROM app_activity a
+    LEFT JOIN app_statistics s ON s.device_id = a.device_id
+    WHERE a.seen_date = (?::date)
+        AND NOT COALESCE((s.first_seen AT TIME ZONE ?)::date >= a.seen_date, false)
+) returns
+GROUP BY seen_date, away_days`, MaxAwayDays, MaxAwayDays, d, r.zone.Name).Error; err != nil {
+		return err
+	}
+
+	// Keep the earliest day of every device, whatever order days are rolled in
+	return tx.Exec(`INSERT INTO stats_device_firsts (device_id, first_date, platform, app_version)
+SELECT DISTINCT ON (device_id) device_id, seen_date, platform, app_version
+FROM app_activity
+WHERE seen_date = (?::date)
+ORDER BY device_id, seen_at
+ON CONFLICT (device_id) DO UPDATE
+SET first_date = EXCLUDED.first_date, platform = EXCLUDED.platform, app_version = EXCLUDED.app_version
+WHERE EXCLUDED.first_date < stats_device_firsts.first_date`, d).Error
+}
+
+// RollDays recomputes the rollups of every day in [from, to], each in its own
+// transaction. The watermark moves forward when the range continues the
+// rolled days, so backfilling an older range never hides unrolled days.
+func (r *Roller) RollDays(ctx context.Context, from, to time.Time) (int, error) {
+	state, err := loadState(r.db, ActivityRollup)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.checkZone(state); err != nil {
+		return 0, err
+	}
+	purged, err := r.purgedThrough()
+	if err != nil {
+		return 0, err
+	}
+	if purged != nil && !r.zone.Day(from).After(*purged) {
+		return 0, fmt.Errorf("app_activity through %s was purged; roll from %s on",
+			purged.Format(dateLayout), purged.AddDate(0, 0, 1).Format(dateLayout))
+	}
+	var through *time.Time
+	if state.RolledThrough != nil {
+		t := r.zone.DateValue(*state.RolledThrough)
+		through = &t
+	}
+	rolled := 0
+	for day := r.zone.Day(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		if err := ctx.Err(); err != nil {
+			return rolled, err
+		}
+		day := day
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			if err := r.RollDay(tx, day); err != nil {
+				return err
+			}
+			if through == nil || (!day.After(through.AddDate(0, 0, 1)) && day.After(*through)) {
+				through = &day
+				return r.setRolledThrough(tx, ActivityRollup, day, time.Now().UTC())
+			}
+			return nil
+		})
+		if err != nil {
+			return rolled, err
+		}
+		rolled++
+	}
+	return rolled, nil
+}
+
+// RollPending rolls every day from the watermark through yesterday. The day of
+// the watermark itself is rolled again to pick up activity recorded late.
+// On the first run it starts from the oldest day in app_activity.
+func (r *Roller) RollPending(ctx context.Context, now time.Time) (int, error) {
+	through, err := RolledThrough(r.db, ActivityRollup)
+	if err != nil {
+		return 0, err
+	}
+	var from time.Time
+	if through != nil {
+		from = r.zone.DateValue(*through)
+	} else {
+		var oldest struct {
+			Day *time.Time
+		}
+		if err := r.db.Raw("SELECT MIN(seen_date) AS day FROM app_activity").Scan(&oldest).Error; err != nil {
+			return 0, err
+		}
+		if oldest.Day == nil {
+			return 0, nil
+		}
+		from = r.zone.DateValue(*oldest.Day)
+	}
+	yesterday := r.zone.Day(now).AddDate(0, 0, -1)
+	if from.After(yesterday) {
+		return 0, nil
+	}
+	return r.RollDays(ctx, from, yesterday)
+}
+
+// SnapshotDevices replaces today's snapshot of app_statistics and
+// device_sites.
+func (r *Roller) SnapshotDevices(now time.Time) error {
+	d := r.zone.Day(now).Format(dateLayout)
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM stats_daily_devices WHERE day = (?::date)", d).Error; err != nil {
+			return err
+		}
+		// Dormant devices are no longer part of the install base
+		if err := tx.Exec(`INSERT INTO stats_daily_devices (day, platform, app_version, devices, active_devices)
+SELECT (?::date), platform, app_version, COUNT(*), COUNT(*) FILTER (WHERE last_seen >= ?)
+FROM app_statistics
+WHERE dormant_at IS NULL
+GROUP BY platform, app_version`, d, now.Add(-ActiveWindow)).Error; err != nil {
+			return err
+		}
+		if err := snapshotSites(tx, d, now); err != nil {
+			return err
+		}
+		return r.setRolledThrough(tx, DeviceSnapshot, r.zone.Day(now), now.UTC())
+	})
+}
+
+// snapshotSites counts the active devices configuring each site template and
+// each site type on day d.
+func snapshotSites(tx *gorm.DB, d string, now time.Time) error {
+	for _, table := range []string{"stats_daily_sites", "stats_daily_site_types"} {
+		if err := tx.Exec("DELETE FROM "+table+" WHERE day = (?::date)", d).Error; err != nil {
+			return err
+		}
+	}
+	active := now.Add(-ActiveWindow)
+	if err := tx.Exec(`INSERT INTO stats_daily_sites (day, template_id, site_type, devices)
+SELECT (?::date), ds.template_id, ds.site_type, COUNT(*)
+FROM device_sites ds
+JOIN app_statistics s ON s.device_id = ds.device_id
+WHERE s.dormant_at IS NULL AND s.last_seen >= ?
+GROUP BY ds.template_id, ds.site_type`, d, active).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`INSERT INTO stats_daily_site_types (day, site_type, devices)
+SELECT (?::date), ds.site_type, COUNT(DISTINCT ds.device_id)
+FROM device_sites ds
+JOIN app_statistics s ON s.device_id = ds.device_id
+WHERE s.dormant_at IS NULL AND s.last_seen >= ?
+GROUP BY ds.site_type`, d, active).Error
+}
+
+// Run keeps the rollups current until ctx is done, refreshing them every interval.
+func (r *Roller) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := r.RollPending(ctx, time.Now()); err != nil {
+			log.Printf("analytics rollup failed: %v", err)
+		} else if n > 1 {
+			log.Printf("analytics rollup: rolled %d days", n)
+		}
+		if err := r.SnapshotDevices(time.Now()); err != nil {
+			log.Printf("analytics device snapshot failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Rebucket recomputes app_activity.seen_date in the roller's time zone after
+// ANALYTICS_TIMEZONE changed, and clears the activity rollups so they are
+// rebuilt from it. Each row keeps the day of its seen_at, the first visit of
+// the old day; when two rows of a device land on the same day the earlier
+// one is kept. Visits after the first of an old day were never recorded, so
+// a device active late on one old day may now miss an activity day.
+// It returns the number of rows whose day changed.
+func (r *Roller) Rebucket() (int64, error) {
+	purged, err := r.purgedThrough()
+	if err != nil {
+		return 0, err
+	}
+	if purged != nil {
+		return 0, fmt.Errorf("app_activity through %s was purged; its rollups cannot be rebuilt in another time zone",
+			purged.Format(dateLayout))
+	}
+	var changed int64
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`DELETE FROM app_activity a
+USING app_activity b
+WHERE a.device_id = b.device_id
+    AND (a.seen_at AT TIME ZONE ?)::date = (b.seen_at AT TIME ZONE ?)::date
+    AND (a.seen_at, a.id) > (b.seen_at, b.id)`, r.zone.Name, r.zone.Name).Error; err != nil {
+			return err
+		}
+		// Days move in both directions, so the unique index is rebuilt
+		// rather than checked row by row
+		if err := tx.Exec("DROP INDEX IF EXISTS uniq_app_activity_device_date").Error; err != nil {
+			return err
+		}
+		res := tx.Exec(`UPDATE app_activity SET seen_date = (seen_at AT TIME ZONE ?)::date
+WHERE seen_date <> (seen_at AT TIME ZONE ?)::date`, r.zone.Name, r.zone.Name)
+		if res.Error != nil {
+			return res.Error
+		}
+		changed = res.RowsAffected
+		if err := tx.Exec("CREATE UNIQUE INDEX uniq_app_activity_device_date ON app_activity (device_id, seen_date)").Error; err != nil {
+			return err
+		}
+		for _, table := range []string{"stats_daily_activity", "stats_daily_returns", "stats_device_firsts"} {
+			if err := tx.Exec("DELETE FROM " + table).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Exec("DELETE FROM stats_rollup_state WHERE name = ?", ActivityRollup).Error
+	})
+	return changed, err
+}
@@ -0,0 +1,237 @@
+package analytics
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var testZone = DefaultZone()
+
+func newMockRoller(t *testing.T) (*Roller, sqlmock.Sqlmock, func()) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: db,
+	}), &gorm.Config{})
+	require.NoError(t, err)
+
+	return NewRoller(gormDB, testZone), mock, func() {
+		mock.ExpectClose()
+		assert.NoError(t, db.Close())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func expectState(mock sqlmock.Sqlmock, through ...time.Time) {
+	rows := sqlmock.NewRows([]string{"rolled_through", "zone"})
+	for _, t := range through {
+		rows.AddRow(t, testZone.Name)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT rolled_through, zone FROM stats_rollup_state WHERE name = $1`)).
+		WithArgs(ActivityRollup).
+		WillReturnRows(rows)
+}
+
+// expectPurged expects the lookup of the app_activity purge horizon.
+func expectPurged(mock sqlmock.Sqlmock, through ...time.Time) {
+	rows := sqlmock.NewRows([]string{"rolled_through", "zone"})
+	for _, t := range through {
+		rows.AddRow(t, testZone.Name)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT rolled_through, zone FROM stats_rollup_state WHERE name = $1`)).
+		WithArgs(ActivityPurge).
+		WillReturnRows(rows)
+}
+
+// expectRollDay expects the statements of one rolled day, advancing the
+// watermark to it when advance is set.
+func expectRollDay(mock sqlmock.Sqlmock, day string, advance bool) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM stats_daily_activity WHERE day = ($1::date)`)).
+		WithArgs(day).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO stats_daily_activity`)).
+		WithArgs("Asia/Shanghai", day).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM stats_daily_returns WHERE day = ($1::date)`)).
+		WithArgs(day).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO stats_daily_returns`)).
+		WithArgs(MaxAwayDays, MaxAwayDays, day, "Asia/Shanghai").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO stats_device_firsts .*ON CONFLICT \(device_id\) DO UPDATE`).
+		WithArgs(day).WillReturnResult(sqlmock.NewResult(0, 1))
+	if advance {
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO stats_rollup_state`)).
+			WithArgs(ActivityRollup, day, testZone.Name, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+}
+
+func TestRollPendingStartsAtOldestActivity(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	expectState(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT MIN(seen_date) AS day FROM app_activity`)).
+		WillReturnRows(sqlmock.NewRows([]string{"day"}).AddRow(time.Date(2024, 5, 9, 0, 0, 0, 0, time.UTC)))
+	expectState(mock)
+	expectPurged(mock)
+	expectRollDay(mock, "2024-05-09", true)
+	expectRollDay(mock, "2024-05-10", true)
+
+	// 01:00 on May 11th locally, still May 10th in UTC
+	n, err := r.RollPending(context.Background(), time.Date(2024, 5, 10, 17, 0, 0, 0, time.UTC))
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestRollPendingRerollsWatermarkDay(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	through := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	expectState(mock, through)
+	expectState(mock, through)
+	expectPurged(mock)
+	expectRollDay(mock, "2024-05-10", false)
+	expectRollDay(mock, "2024-05-11", true)
+
+	n, err := r.RollPending(context.Background(), time.Date(2024, 5, 12, 12, 0, 0, 0, testZone.Location))
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestRollPendingWithoutActivity(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	expectState(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT MIN(seen_date) AS day FROM app_activity`)).
+		WillReturnRows(sqlmock.NewRows([]string{"day"}).AddRow(nil))
+
+	n, err := r.RollPending(context.Background(), time.Now())
+
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestRollDaysBackfillKeepsWatermarkBehindGaps(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	// Rolled through May 10th; May 12th-13th do not continue it
+	expectState(mock, time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC))
+	expectPurged(mock)
+	expectRollDay(mock, "2024-05-12", false)
+	expectRollDay(mock, "2024-05-13", false)
+
+	from := time.Date(2024, 5, 12, 0, 0, 0, 0, testZone.Location)
+	n, err := r.RollDays(context.Background(), from, from.AddDate(0, 0, 1))
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestSnapshotDevicesReplacesToday(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	now := time.Date(2024, 5, 10, 17, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM stats_daily_devices WHERE day = ($1::date)`)).
+		WithArgs("2024-05-11").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO stats_daily_devices`)).
+		WithArgs("2024-05-11", now.Add(-ActiveWindow)).WillReturnResult(sqlmock.NewResult(0, 3))
+	for _, table := range []string{"stats_daily_sites", "stats_daily_site_types"} {
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM ` + table + ` WHERE day = ($1::date)`)).
+			WithArgs("2024-05-11").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO stats_daily_sites`)).
+		WithArgs("2024-05-11", now.Add(-ActiveWindow)).WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO stats_daily_site_types`)).
+		WithArgs("2024-05-11", now.Add(-ActiveWindow)).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO stats_rollup_state`)).
+		WithArgs(DeviceSnapshot, "2024-05-11", testZone.Name, now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	require.NoError(t, r.SnapshotDevices(now))
+}
+
+func TestRollDaysRefusesRollupsOfAnotherZone(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT rolled_through, zone FROM stats_rollup_state WHERE name = $1`)).
+		WithArgs(ActivityRollup).
+		WillReturnRows(sqlmock.NewRows([]string{"rolled_through", "zone"}).
+			AddRow(time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC), "Europe/Berlin"))
+
+	day := time.Date(2024, 5, 11, 0, 0, 0, 0, testZone.Location)
+	_, err := r.RollDays(context.Background(), day, day)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rebucket")
+}
+
+func TestRebucketMovesDaysAndClearsRollups(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	expectPurged(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM app_activity a\s+USING app_activity b`).
+		WithArgs(testZone.Name, testZone.Name).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DROP INDEX IF EXISTS uniq_app_activity_device_date`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE app_activity SET seen_date = (seen_at AT TIME ZONE $1)::date`)).
+		WithArgs(testZone.Name, testZone.Name).WillReturnResult(sqlmock.NewResult(0, 40))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE UNIQUE INDEX uniq_app_activity_device_date ON app_activity (device_id, seen_date)`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, table := range []string{"stats_daily_activity", "stats_daily_returns", "stats_device_firsts"} {
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM ` + table)).WillReturnResult(sqlmock.NewResult(0, 10))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM stats_rollup_state WHERE name = $1`)).
+		WithArgs(ActivityRollup).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	changed, err := r.Rebucket()
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(40), changed)
+}
+
+func TestRollDaysRefusesPurgedDays(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	expectState(mock, time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC))
+	expectPurged(mock, time.Date(2023, 3, 31, 0, 0, 0, 0, time.UTC))
+
+	from := time.Date(2023, 3, 1, 0, 0, 0, 0, testZone.Location)
+	_, err := r.RollDays(context.Background(), from, from.AddDate(0, 1, 0))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "roll from 2023-04-01 on")
+}
+
+func TestRebucketRefusesAfterPurge(t *testing.T) {
+	r, mock, cleanup := newMockRoller(t)
+	defer cleanup()
+
+	expectPurged(mock, time.Date(2023, 3, 31, 0, 0, 0, 0, time.UTC))
+
+	_, err := r.Rebucket()
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "purged")
+}
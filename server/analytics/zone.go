@@ -0,0 +1,78 @@
+package analytics
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	// Zone data for hosts and containers without /usr/share/zoneinfo
+	_ "time/tzdata"
+)
+
+// DefaultZoneName is the analytics time zone when ANALYTICS_TIMEZONE is not
+// set. Earlier releases bucketed days in UTC+8, which it matches.
+const DefaultZoneName = "Asia/Shanghai"
+
+// Zone is the time zone analytics days are counted in: app_activity.seen_date,
+// the rollups and every stats range. Name is an IANA zone name, understood by
+// both Go and Postgres, so days computed in SQL and in Go agree, DST included.
+type Zone struct {
+	Name     string
+	Location *time.Location
+}
+
+// LoadZone loads the named IANA time zone.
+func LoadZone(name string) (*Zone, error) {
+	name = strings.TrimSpace(name)
+	// "Local" depends on the host and "" means UTC to Go but not to Postgres
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("invalid analytics time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid analytics time zone %q: %v", name, err)
+	}
+	return &Zone{Name: name, Location: loc}, nil
+}
+
+// ZoneFromEnv loads ANALYTICS_TIMEZONE, defaulting to DefaultZoneName.
+func ZoneFromEnv() (*Zone, error) {
+	name := os.Getenv("ANALYTICS_TIMEZONE")
+	if name == "" {
+		name = DefaultZoneName
+	}
+	return LoadZone(name)
+}
+
+// DefaultZone returns the zone named DefaultZoneName.
+func DefaultZone() *Zone {
+	z, err := LoadZone(DefaultZoneName)
+	if err != nil {
+		// The zone database is embedded, so this cannot happen
+		panic(err)
+	}
+	return z
+}
+
+// Day returns the start of the day containing t.
+func (z *Zone) Day(t time.Time) time.Time {
+	t = t.In(z.Location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, z.Location)
+}
+
+// Today returns the start of the current day.
+func (z *Zone) Today() time.Time {
+	return z.Day(time.Now())
+}
+
+// ParseDay parses a YYYY-MM-DD day.
+func (z *Zone) ParseDay(s string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", s, z.Location)
+}
+
+// DateValue converts a DATE column, which the driver returns as midnight UTC,
+// to the start of that day in the zone.
+func (z *Zone) DateValue(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, z.Location)
+}
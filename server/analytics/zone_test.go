@@ -0,0 +1,30 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadZoneRejectsAmbiguousNames(t *testing.T) {
+	for _, name := range []string{"", "Local", "Mars/Olympus_Mons"} {
+		_, err := LoadZone(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestZoneDaysFollowDST(t *testing.T) {
+	z, err := LoadZone("America/New_York")
+	require.NoError(t, err)
+
+	// Clocks spring forward on 2024-03-10, which lasts 23 hours
+	day, err := z.ParseDay("2024-03-10")
+	require.NoError(t, err)
+	assert.Equal(t, 23*time.Hour, day.AddDate(0, 0, 1).Sub(day))
+
+	// 03:30 UTC on March 11th is still March 10th in New York (UTC-4)
+	assert.True(t, day.Equal(z.Day(time.Date(2024, 3, 11, 3, 30, 0, 0, time.UTC))))
+	assert.True(t, day.Equal(z.DateValue(time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC))))
+}
@@ -3,8 +3,6 @@ package main
 import (
 	"log"
 	"net/http"
-	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -12,10 +10,14 @@ import (
 
 type AppService struct {
 	db *gorm.DB
+	// signer is nil when update manifests are not signed
+	signer *updateSigner
+	// ingest records update checks in app_statistics and app_activity
+	ingest *ingestQueue
 }
 
-func NewAppService(db *gorm.DB) *AppService {
-	return &AppService{db: db}
+func NewAppService(db *gorm.DB, signer *updateSigner, ingest *ingestQueue) *AppService {
+	return &AppService{db: db, signer: signer, ingest: ingest}
 }
 
 func (s *AppService) CheckUpdate(c *gin.Context) {
@@ -25,32 +27,47 @@ func (s *AppService) CheckUpdate(c *gin.Context) {
 		return
 	}
 
-	// Record client IP
-	clientIP := c.ClientIP()
+	// Locate the client IP before it is anonymized, then record it as far as IP_POLICY allows
+	geo := locateClientIP(c.ClientIP())
+	clientIP := clientIPPolicy.Anonymize(c.ClientIP())
 
-	// Process statistics and activity in background
-	go func(deviceID, platform, appVersion, ip string) {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("Recovered from panic in background analytics task: %v", r)
-			}
-		}()
-
-		// Update or insert statistics
-		if err := s.updateStatistics(deviceID, platform, appVersion, ip); err != nil {
-			log.Printf("Failed to update statistics: %v", err)
-			// Don't fail the request if statistics update fails
-		}
-
-		// Record daily activity for DAU trend (Asia/Shanghai UTC+8 day window)
-		if err := s.recordDailyActivity(deviceID, platform, appVersion); err != nil {
-			log.Printf("Failed to record daily activity: %v", err)
-			// Do not fail the main request due to analytics logging
+	// Statistics and activity are written in the background. A full queue
+	// drops the event rather than failing the update check; drops are counted
+	// in the ingest metrics
+	var secretHash string
+	if req.DeviceSecret != "" {
+		secretHash = deviceSecretHash(req.DeviceSecret)
+	}
+	s.ingest.Enqueue(ingestEvent{
+		DeviceID:   req.DeviceID,
+		Platform:   req.Platform,
+		AppVersion: req.AppVersion,
+		IP:         clientIP,
+		Geo:        geo,
+		Sites:      sanitizeReportedSites(req.Sites),
+		SecretHash: secretHash,
+		At:         nowUTC(),
+	})
+
+	// Check whether the running version is still supported on this channel and platform
+	rules, err := loadMinSupportedVersions(s.db)
+	if err != nil {
+		log.Printf("Failed to load minimum supported versions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for updates"})
+		return
+	}
+	response := CheckUpdateResponse{}
+	if floor := resolveMinSupportedVersion(rules, updateChannel(req.IsBeta), req.Platform); floor != nil {
+		response.MinVersion = floor.MinVersion
+		if s.compareVersions(req.AppVersion, floor.MinVersion) {
+			response.ForceUpdate = true
+			response.BlockedReason = floor.BlockedReason
 		}
-	}(req.DeviceID, req.Platform, req.AppVersion, clientIP)
+	}
 
-	// Get latest version according to beta opt-in
-	latestVersion, err := s.getLatestVersion(req.IsBeta)
+	// Get latest version for the caller's platform according to beta opt-in and rollout.
+	// Devices below the floor skip the staged rollout so they can always upgrade.
+	latestVersion, err := s.getLatestVersion(req.Platform, req.DeviceID, req.IsBeta, response.ForceUpdate)
 	if err != nil {
 		log.Printf("Failed to get latest version: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for updates"})
@@ -59,18 +76,13 @@ func (s *AppService) CheckUpdate(c *gin.Context) {
 
 	if latestVersion == nil {
 		// No version information available
-		c.JSON(http.StatusOK, CheckUpdateResponse{
-			HasUpdate: false,
-		})
+		c.JSON(http.StatusOK, response)
 		return
 	}
 
 	// Compare versions
 	hasUpdate := s.compareVersions(req.AppVersion, latestVersion.Version)
-
-	response := CheckUpdateResponse{
-		HasUpdate: hasUpdate,
-	}
+	response.HasUpdate = hasUpdate
 
 	if hasUpdate {
 		response.LatestVersion = latestVersion.Version
@@ -78,133 +90,126 @@ func (s *AppService) CheckUpdate(c *gin.Context) {
 		response.DownloadURL = latestVersion.DownloadURL
 		response.AndroidDownloadURL = latestVersion.AndroidDownloadURL
 
-		// Backward compatibility: Android clients that only read download_url
-		// should still get direct APK url when available.
-		if strings.EqualFold(req.Platform, "android") && latestVersion.AndroidDownloadURL != "" {
-			response.DownloadURL = latestVersion.AndroidDownloadURL
+		// Serve the release notes in the language the client asked for
+		if locales := requestedLocales(req.Locale, c.GetHeader("Accept-Language")); len(locales) > 0 {
+			translations, err := loadReleaseNotes(s.db, []int{latestVersion.ID})
+			if err != nil {
+				log.Printf("Failed to load release notes: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for updates"})
+				return
+			}
+			response.ReleaseNotes, response.ReleaseNotesLocale = localizeReleaseNotes(latestVersion, translations[latestVersion.ID], locales)
+		}
+
+		// Point download_url at the build matching the device, falling back to the release page
+		asset, err := s.resolveUpdateAsset(latestVersion, req)
+		if err != nil {
+			log.Printf("Failed to load release assets: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for updates"})
+			return
+		}
+		if asset != nil {
+			response.DownloadURL = asset.URL
+			response.Asset = &UpdateAsset{
+				Platform: asset.Platform,
+				Arch:     asset.Arch,
+				Format:   asset.Format,
+				Name:     asset.Name,
+				URL:      asset.URL,
+				Size:     asset.Size,
+				SHA256:   asset.SHA256,
+			}
+		}
+
+		// Clients verifying manifests reject updates without one, so an
+		// asset missing its digest is never offered to them
+		if s.signer != nil && manifestVerifiable(response) {
+			manifest, signatures, err := s.signer.sign(newUpdateManifest(response, req.Platform, nowUTC()))
+			if err != nil {
+				log.Printf("Failed to sign update manifest: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for updates"})
+				return
+			}
+			response.Manifest = manifest
+			response.Signatures = signatures
 		}
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-func (s *AppService) updateStatistics(deviceID, platform, appVersion, ip string) error {
-	var stat AppStatistic
-	tx := s.db.Where("device_id = ?", deviceID).First(&stat)
-	if tx.Error == nil && stat.ID != 0 {
-		// Update existing
-		stat.Platform = platform
-		stat.AppVersion = appVersion
-		stat.IP = ip
-		stat.LastSeen = nowUTC()
-		stat.TotalLaunches = stat.TotalLaunches + 1
-		return s.db.Save(&stat).Error
-	}
-	if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
-		return tx.Error
-	}
-	// Create new
-	newStat := AppStatistic{
-		DeviceID:      deviceID,
-		Platform:      platform,
-		AppVersion:    appVersion,
-		IP:            ip,
-		FirstSeen:     nowUTC(),
-		LastSeen:      nowUTC(),
-		TotalLaunches: 1,
-	}
-	return s.db.Create(&newStat).Error
-}
+// getLatestVersion returns the newest published release available on platform
+// whose staged rollout includes deviceID (or simply the newest one when
+// bypassRollout is set), or nil when there is none.
+func (s *AppService) getLatestVersion(platform, deviceID string, includeBeta, bypassRollout bool) (*AppVersion, error) {
+	candidates, err := loadPlatformReleases(s.db, platform, includeBeta)
+	if err != nil {
+		return nil, err
+	}
 
-// recordDailyActivity inserts a record into app_activity for the current day (UTC+8),
-// ensuring uniqueness per device per day.
-func (s *AppService) recordDailyActivity(deviceID, platform, appVersion string) error {
-	// Compute seen_date in UTC+8
-	// Asia/Shanghai is UTC+8 without DST; we will derive date by adding +8h to UTC and truncating
 	now := nowUTC()
-	// Shift to UTC+8
-	shifted := now.Add(8 * time.Hour)
-	// Take date component (YYYY-MM-DD) by constructing time at midnight and then back to UTC midnight for storage
-	y, m, d := shifted.Date()
-	// Build time at local midnight (in UTC+8)
-	localMidnight := time.Date(y, m, d, 0, 0, 0, 0, time.FixedZone("UTC+8", 8*3600))
-	// Convert to date by taking the same Y-M-D but store as date type; GORM with type:date needs a time.Time
-	seenDate := localMidnight
-
-	// Insert with conflict ignore
-	activity := AppActivity{
-		DeviceID:   deviceID,
-		Platform:   platform,
-		AppVersion: appVersion,
-		SeenDate:   seenDate,
-		SeenAt:     now,
-	}
-
-	// Use ON CONFLICT DO NOTHING on (device_id, seen_date)
-	// Explicitly cast seen_date to date type to avoid driver type mismatch
-	sql := `INSERT INTO app_activity (device_id, platform, app_version, seen_date, seen_at)
-            VALUES (?, ?, ?, (?::date), ?)
-            ON CONFLICT (device_id, seen_date) DO NOTHING`
-	return s.db.Exec(sql, activity.DeviceID, activity.Platform, activity.AppVersion, activity.SeenDate, activity.SeenAt).Error
+	for i := range candidates {
+		if bypassRollout || inRollout(&candidates[i], deviceID, now) {
+			return &candidates[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// resolveUpdateAsset returns the asset of release v that fits the requesting
+// device, or nil when none matches. The ABI reported by Android clients is
+// used when no arch is given.
+func (s *AppService) resolveUpdateAsset(v *AppVersion, req CheckUpdateRequest) (*ReleaseAsset, error) {
+	var assets []ReleaseAsset
+	if err := s.db.Where("version_id = ?", v.ID).Find(&assets).Error; err != nil {
+		return nil, err
+	}
+	hasAndroidAsset := false
+	for _, a := range assets {
+		hasAndroidAsset = hasAndroidAsset || a.Platform == "android"
+	}
+	if legacy := legacyAndroidAsset(v); legacy != nil && !hasAndroidAsset {
+		assets = append(assets, *legacy)
+	}
+
+	arch := req.Arch
+	if arch == "" {
+		arch = req.ABI
+	}
+	return selectReleaseAsset(assets, req.Platform, arch, req.PackageFormat), nil
 }
 
-func (s *AppService) getLatestVersion(includeBeta bool) (*AppVersion, error) {
-	var version AppVersion
-	q := s.db.Model(&AppVersion{}).Where("is_latest = ? AND is_published = ?", true, true)
+// maxReleaseCandidates bounds the releases loaded per update check or feed:
+// the highest versions, whatever order they were created in.
+const maxReleaseCandidates = 100
+
+// loadPlatformReleases returns the latest published releases available on
+// platform, at most maxReleaseCandidates, newest version first. Ordering by
+// version rather than by created_at keeps a re-posted old release from
+// becoming the latest one, or from pushing it out of the window.
+func loadPlatformReleases(db *gorm.DB, platform string, includeBeta bool) ([]AppVersion, error) {
+	var versions []AppVersion
+	q := db.Model(&AppVersion{}).
+		Where("is_published = ?", true).
+		Where("platforms = '' OR ? = ANY(string_to_array(platforms, ','))", normalizePlatform(platform))
 	if !includeBeta {
 		q = q.Where("is_beta = ?", false)
 	}
-	if err := q.Order("created_at DESC").Limit(1).First(&version).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil, nil
-		}
+	if err := q.Order(versionOrderSQL).Limit(maxReleaseCandidates).Find(&versions).Error; err != nil {
 		return nil, err
 	}
-	return &version, nil
+	sortVersionsDesc(versions)
+	return versions, nil
 }
 
-// Simple version comparison - assumes semantic versioning (x.y.z)
+// compareVersions reports whether latest is newer than current.
 func (s *AppService) compareVersions(current, latest string) bool {
-	// Remove 'v' prefix if present
-	current = strings.TrimPrefix(current, "v")
-	latest = strings.TrimPrefix(latest, "v")
-
-	// Split versions into parts
-	currentParts := strings.Split(current, ".")
-	latestParts := strings.Split(latest, ".")
-
-	// Ensure both have at least 3 parts
-	for len(currentParts) < 3 {
-		currentParts = append(currentParts, "0")
-	}
-	for len(latestParts) < 3 {
-		latestParts = append(latestParts, "0")
-	}
-
-	// Compare each part
-	for i := 0; i < 3; i++ {
-		var currentNum, latestNum int
-
-		// Parse numbers, ignore errors (default to 0)
-		if len(currentParts) > i {
-			if n, err := parseVersionNumber(currentParts[i]); err == nil {
-				currentNum = n
-			}
-		}
-		if len(latestParts) > i {
-			if n, err := parseVersionNumber(latestParts[i]); err == nil {
-				latestNum = n
-			}
-		}
-
-		if latestNum > currentNum {
-			return true
-		} else if latestNum < currentNum {
-			return false
-		}
-	}
+	return isNewerVersion(current, latest)
+}
 
-	return false // Versions are equal
+// isNewerVersion reports whether latest has higher semver precedence than current.
+func isNewerVersion(current, latest string) bool {
+	return compareVersionStrings(latest, current) > 0
 }
 
 func parseVersionNumber(s string) (int, error) {
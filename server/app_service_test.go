@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -35,7 +36,18 @@ func TestCompareVersions(t *testing.T) {
 		{"With v Prefix", "v1.0.0", "v1.0.1", true},
 		{"Mixed Prefix", "1.0.0", "v1.0.1", true},
 		{"Short Version", "1.0", "1.0.1", true},
-		{"Long Version", "1.0.0.1", "1.0.0", false}, // 4th part ignored by current implementation
+		{"Long Version", "1.0.0.1", "1.0.0", false},
+		{"Fourth Component", "1.0.0", "1.0.0.1", true},
+		{"Numeric Pre-release", "2.29.0-beta.2", "2.29.0-beta.10", true},
+		{"Numeric Pre-release Older", "2.29.0-beta.10", "2.29.0-beta.2", false},
+		{"Release Candidate To Release", "2.29.0-rc.1", "2.29.0", true},
+		{"Release To Release Candidate", "2.29.0", "2.29.0-rc.1", false},
+		{"Beta To Release Candidate", "2.29.0-beta.3", "2.29.0-rc.1", true},
+		{"Pre-release To Older Release", "2.29.0-beta.1", "2.28.9", false},
+		{"Longer Pre-release", "1.0.0-alpha", "1.0.0-alpha.1", true},
+		{"Numeric Before Alphanumeric", "1.0.0-alpha.beta", "1.0.0-alpha.1", false},
+		{"Build Metadata Ignored", "2.29.0+100", "2.29.0+200", false},
+		{"Build Metadata With Newer Patch", "2.29.0+100", "2.29.1+1", true},
 	}
 
 	for _, tt := range tests {
@@ -48,6 +60,22 @@ func TestCompareVersions(t *testing.T) {
 	}
 }
 
+func expectMinSupportedVersions(mock sqlmock.Sqlmock, rules ...MinSupportedVersion) {
+	rows := sqlmock.NewRows([]string{"id", "channel", "platform", "min_version", "blocked_reason"})
+	for _, r := range rules {
+		rows.AddRow(r.ID, r.Channel, r.Platform, r.MinVersion, r.BlockedReason)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "min_supported_versions" ORDER BY channel, platform`).WillReturnRows(rows)
+}
+
+func expectReleaseAssets(mock sqlmock.Sqlmock, versionID int, assets ...ReleaseAsset) {
+	rows := sqlmock.NewRows([]string{"id", "version_id", "platform", "arch", "format", "name", "url", "size", "sha256"})
+	for _, a := range assets {
+		rows.AddRow(a.ID, versionID, a.Platform, a.Arch, a.Format, a.Name, a.URL, a.Size, a.SHA256)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "release_assets" WHERE version_id = \$1`).WithArgs(versionID).WillReturnRows(rows)
+}
+
 func TestCheckUpdate(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -66,7 +94,8 @@ func TestCheckUpdate(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when initializing gorm", err)
 	}
 
-	service := NewAppService(gormDB)
+	ingest := newIngestQueue(gormDB, ingestConfig{QueueSize: 16, BatchSize: 16, FlushInterval: time.Second})
+	service := NewAppService(gormDB, nil, ingest)
 
 	tests := []struct {
 		name           string
@@ -84,28 +113,14 @@ func TestCheckUpdate(t *testing.T) {
 				IsBeta:     false,
 			},
 			setupMock: func(mock sqlmock.Sqlmock) {
-				// 1. updateStatistics
-				// First check if exists
-				mock.ExpectQuery(`SELECT \* FROM "app_statistics" WHERE device_id = \$1 ORDER BY "app_statistics"."id" LIMIT \$2`).
-					WithArgs("test-device", 1).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "total_launches"}).AddRow(1, 10))
-				// Update
-				mock.ExpectBegin()
-				mock.ExpectExec(`UPDATE "app_statistics" SET`).
-					WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectCommit()
-
-				// 2. recordDailyActivity
-				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity (device_id, platform, app_version, seen_date, seen_at)
-            VALUES ($1, $2, $3, ($4::date), $5)
-            ON CONFLICT (device_id, seen_date) DO NOTHING`)).
-					WillReturnResult(sqlmock.NewResult(1, 1))
-
-				// 3. getLatestVersion
-				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE .*is_latest = \$1 AND is_published = \$2.*is_beta = \$3.* LIMIT \$4`).
-					WithArgs(true, true, false, 1).
-					WillReturnRows(sqlmock.NewRows([]string{"version", "release_notes", "download_url", "android_download_url", "is_latest", "is_beta", "is_published", "created_at"}).
-						AddRow("1.1.0", "New features", "https://example.com/release", "http://example.com/app.apk", true, false, true, time.Now()))
+				expectMinSupportedVersions(mock)
+				// 1. getLatestVersion
+				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND \(platforms = '' OR \$2 = ANY\(string_to_array\(platforms, ','\)\)\) AND is_beta = \$3 AND "app_versions"."deleted_at" IS NULL ORDER BY `+regexp.QuoteMeta(versionOrderSQL)+` LIMIT \$4`).
+					WithArgs(true, "android", false, maxReleaseCandidates).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "release_notes", "download_url", "android_download_url", "is_beta", "is_published", "rollout_percent", "created_at"}).
+						AddRow("1.1.0", "New features", "https://example.com/release", "http://example.com/app.apk", false, true, 100, time.Now()))
+				// 2. assets of the release; none stored, so the legacy Android column is used
+				expectReleaseAssets(mock, 0)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: CheckUpdateResponse{
@@ -114,6 +129,7 @@ func TestCheckUpdate(t *testing.T) {
 				ReleaseNotes:       "New features",
 				DownloadURL:        "http://example.com/app.apk",
 				AndroidDownloadURL: "http://example.com/app.apk",
+				Asset:              &UpdateAsset{Platform: "android", Arch: "arm64", Format: "apk", URL: "http://example.com/app.apk"},
 			},
 		},
 		{
@@ -125,26 +141,12 @@ func TestCheckUpdate(t *testing.T) {
 				IsBeta:     false,
 			},
 			setupMock: func(mock sqlmock.Sqlmock) {
-				// 1. updateStatistics
-				mock.ExpectQuery(`SELECT \* FROM "app_statistics" WHERE device_id = \$1 ORDER BY "app_statistics"."id" LIMIT \$2`).
-					WithArgs("test-device", 1).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "total_launches"}).AddRow(1, 10))
-				mock.ExpectBegin()
-				mock.ExpectExec(`UPDATE "app_statistics" SET`).
-					WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectCommit()
-
-				// 2. recordDailyActivity
-				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity (device_id, platform, app_version, seen_date, seen_at)
-            VALUES ($1, $2, $3, ($4::date), $5)
-            ON CONFLICT (device_id, seen_date) DO NOTHING`)).
-					WillReturnResult(sqlmock.NewResult(1, 1))
-
-				// 3. getLatestVersion
-				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE .*is_latest = \$1 AND is_published = \$2.*is_beta = \$3.* LIMIT \$4`).
-					WithArgs(true, true, false, 1).
-					WillReturnRows(sqlmock.NewRows([]string{"version", "release_notes", "download_url", "android_download_url", "is_latest", "is_beta", "is_published", "created_at"}).
-						AddRow("1.1.0", "New features", "https://example.com/release", "http://example.com/app.apk", true, false, true, time.Now()))
+				expectMinSupportedVersions(mock)
+				// 1. getLatestVersion
+				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND \(platforms = '' OR \$2 = ANY\(string_to_array\(platforms, ','\)\)\) AND is_beta = \$3 AND "app_versions"."deleted_at" IS NULL ORDER BY `+regexp.QuoteMeta(versionOrderSQL)+` LIMIT \$4`).
+					WithArgs(true, "android", false, maxReleaseCandidates).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "release_notes", "download_url", "android_download_url", "is_beta", "is_published", "rollout_percent", "created_at"}).
+						AddRow("1.1.0", "New features", "https://example.com/release", "http://example.com/app.apk", false, true, 100, time.Now()))
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: CheckUpdateResponse{
@@ -160,23 +162,12 @@ func TestCheckUpdate(t *testing.T) {
 				IsBeta:     false,
 			},
 			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT \* FROM "app_statistics" WHERE device_id = \$1 ORDER BY "app_statistics"."id" LIMIT \$2`).
-					WithArgs("test-device", 1).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "total_launches"}).AddRow(1, 10))
-				mock.ExpectBegin()
-				mock.ExpectExec(`UPDATE "app_statistics" SET`).
-					WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectCommit()
-
-				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity (device_id, platform, app_version, seen_date, seen_at)
-            VALUES ($1, $2, $3, ($4::date), $5)
-            ON CONFLICT (device_id, seen_date) DO NOTHING`)).
-					WillReturnResult(sqlmock.NewResult(1, 1))
-
-				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE .*is_latest = \$1 AND is_published = \$2.*is_beta = \$3.* LIMIT \$4`).
-					WithArgs(true, true, false, 1).
-					WillReturnRows(sqlmock.NewRows([]string{"version", "release_notes", "download_url", "android_download_url", "is_latest", "is_beta", "is_published", "created_at"}).
-						AddRow("1.1.0", "New features", "https://example.com/release", "http://example.com/app.apk", true, false, true, time.Now()))
+				expectMinSupportedVersions(mock)
+				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND \(platforms = '' OR \$2 = ANY\(string_to_array\(platforms, ','\)\)\) AND is_beta = \$3 AND "app_versions"."deleted_at" IS NULL ORDER BY `+regexp.QuoteMeta(versionOrderSQL)+` LIMIT \$4`).
+					WithArgs(true, "ios", false, maxReleaseCandidates).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "release_notes", "download_url", "android_download_url", "is_beta", "is_published", "rollout_percent", "created_at"}).
+						AddRow("1.1.0", "New features", "https://example.com/release", "http://example.com/app.apk", false, true, 100, time.Now()))
+				expectReleaseAssets(mock, 0)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: CheckUpdateResponse{
@@ -187,6 +178,173 @@ func TestCheckUpdate(t *testing.T) {
 				AndroidDownloadURL: "http://example.com/app.apk",
 			},
 		},
+		{
+			name: "Device Outside Rollout Gets No Update",
+			request: CheckUpdateRequest{
+				DeviceID:   "test-device",
+				Platform:   "android",
+				AppVersion: "1.0.0",
+				IsBeta:     false,
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				expectMinSupportedVersions(mock)
+				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND \(platforms = '' OR \$2 = ANY\(string_to_array\(platforms, ','\)\)\) AND is_beta = \$3 AND "app_versions"."deleted_at" IS NULL ORDER BY `+regexp.QuoteMeta(versionOrderSQL)+` LIMIT \$4`).
+					WithArgs(true, "android", false, maxReleaseCandidates).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "release_notes", "download_url", "android_download_url", "is_beta", "is_published", "rollout_percent", "created_at"}).
+						AddRow("1.1.0", "New features", "https://example.com/release", "http://example.com/app.apk", false, true, 0, time.Now()))
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: CheckUpdateResponse{
+				HasUpdate: false,
+			},
+		},
+		{
+			name: "Falls Back To Previous Release Outside Rollout",
+			request: CheckUpdateRequest{
+				DeviceID:   "test-device",
+				Platform:   "Windows",
+				AppVersion: "1.0.0",
+				IsBeta:     false,
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				expectMinSupportedVersions(mock)
+				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND \(platforms = '' OR \$2 = ANY\(string_to_array\(platforms, ','\)\)\) AND is_beta = \$3 AND "app_versions"."deleted_at" IS NULL ORDER BY `+regexp.QuoteMeta(versionOrderSQL)+` LIMIT \$4`).
+					WithArgs(true, "windows", false, maxReleaseCandidates).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "release_notes", "download_url", "android_download_url", "is_beta", "is_published", "rollout_percent", "created_at"}).
+						AddRow("1.2.0", "Rolling out", "https://example.com/release/1.2.0", "", false, true, 0, time.Now()).
+						AddRow("1.1.0", "New features", "https://example.com/release/1.1.0", "", false, true, 100, time.Now().Add(-time.Hour)))
+				expectReleaseAssets(mock, 0)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: CheckUpdateResponse{
+				HasUpdate:     true,
+				LatestVersion: "1.1.0",
+				ReleaseNotes:  "New features",
+				DownloadURL:   "https://example.com/release/1.1.0",
+			},
+		},
+		{
+			name: "Highest Version Wins Over Newer Rows",
+			request: CheckUpdateRequest{
+				DeviceID:   "test-device",
+				Platform:   "windows",
+				AppVersion: "1.0.0",
+				IsBeta:     false,
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				expectMinSupportedVersions(mock)
+				// A backport to 1.9 posted after 2.0.0 is created later but
+				// ordered after it, so the window always holds 2.0.0
+				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND \(platforms = '' OR \$2 = ANY\(string_to_array\(platforms, ','\)\)\) AND is_beta = \$3 AND "app_versions"."deleted_at" IS NULL ORDER BY `+regexp.QuoteMeta(versionOrderSQL)+` LIMIT \$4`).
+					WithArgs(true, "windows", false, maxReleaseCandidates).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "release_notes", "download_url", "android_download_url", "is_beta", "is_published", "rollout_percent", "created_at"}).
+						AddRow("2.0.0", "Major", "https://example.com/release/2.0.0", "", false, true, 100, time.Now().Add(-30*24*time.Hour)).
+						AddRow("1.9.1", "Backport", "https://example.com/release/1.9.1", "", false, true, 100, time.Now()))
+				expectReleaseAssets(mock, 0)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: CheckUpdateResponse{
+				HasUpdate:     true,
+				LatestVersion: "2.0.0",
+				ReleaseNotes:  "Major",
+				DownloadURL:   "https://example.com/release/2.0.0",
+			},
+		},
+		{
+			name: "Below Minimum Version Forces Update Despite Rollout",
+			request: CheckUpdateRequest{
+				DeviceID:   "test-device",
+				Platform:   "ios",
+				AppVersion: "1.0.0",
+				IsBeta:     false,
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				expectMinSupportedVersions(mock,
+					MinSupportedVersion{ID: 1, Channel: "stable", Platform: "", MinVersion: "0.9.0"},
+					MinSupportedVersion{ID: 2, Channel: "stable", Platform: "ios", MinVersion: "1.1.0", BlockedReason: "Site API changed"})
+				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND \(platforms = '' OR \$2 = ANY\(string_to_array\(platforms, ','\)\)\) AND is_beta = \$3 AND "app_versions"."deleted_at" IS NULL ORDER BY `+regexp.QuoteMeta(versionOrderSQL)+` LIMIT \$4`).
+					WithArgs(true, "ios", false, maxReleaseCandidates).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "release_notes", "download_url", "android_download_url", "is_beta", "is_published", "rollout_percent", "created_at"}).
+						AddRow("1.2.0", "Fixes", "https://example.com/release/1.2.0", "", false, true, 0, time.Now()))
+				expectReleaseAssets(mock, 0)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: CheckUpdateResponse{
+				HasUpdate:     true,
+				LatestVersion: "1.2.0",
+				ReleaseNotes:  "Fixes",
+				DownloadURL:   "https://example.com/release/1.2.0",
+				ForceUpdate:   true,
+				MinVersion:    "1.1.0",
+				BlockedReason: "Site API changed",
+			},
+		},
+		{
+			name: "Reported ABI Selects Matching Asset",
+			request: CheckUpdateRequest{
+				DeviceID:   "test-device",
+				Platform:   "android",
+				AppVersion: "1.0.0",
+				ABI:        "armeabi-v7a",
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				expectMinSupportedVersions(mock)
+				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND \(platforms = '' OR \$2 = ANY\(string_to_array\(platforms, ','\)\)\) AND is_beta = \$3 AND "app_versions"."deleted_at" IS NULL ORDER BY `+regexp.QuoteMeta(versionOrderSQL)+` LIMIT \$4`).
+					WithArgs(true, "android", false, maxReleaseCandidates).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "version", "release_notes", "download_url", "android_download_url", "is_beta", "is_published", "rollout_percent", "created_at"}).
+						AddRow(7, "1.1.0", "New features", "https://example.com/release", "https://dl/arm64.apk", false, true, 100, time.Now()))
+				expectReleaseAssets(mock, 7,
+					ReleaseAsset{ID: 1, Platform: "android", Arch: "arm64", Format: "apk", Name: "app-arm64-v8a.apk", URL: "https://dl/arm64.apk", Size: 100},
+					ReleaseAsset{ID: 2, Platform: "android", Arch: "arm", Format: "apk", Name: "app-armeabi-v7a.apk", URL: "https://dl/v7a.apk", Size: 90, SHA256: strings.Repeat("ab", 32)})
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: CheckUpdateResponse{
+				HasUpdate:          true,
+				LatestVersion:      "1.1.0",
+				ReleaseNotes:       "New features",
+				DownloadURL:        "https://dl/v7a.apk",
+				AndroidDownloadURL: "https://dl/arm64.apk",
+				Asset: &UpdateAsset{
+					Platform: "android",
+					Arch:     "arm",
+					Format:   "apk",
+					Name:     "app-armeabi-v7a.apk",
+					URL:      "https://dl/v7a.apk",
+					Size:     90,
+					SHA256:   strings.Repeat("ab", 32),
+				},
+			},
+		},
+		{
+			name: "Locale Selects Translated Release Notes",
+			request: CheckUpdateRequest{
+				DeviceID:   "test-device",
+				Platform:   "ios",
+				AppVersion: "1.0.0",
+				Locale:     "en_US",
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				expectMinSupportedVersions(mock)
+				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND \(platforms = '' OR \$2 = ANY\(string_to_array\(platforms, ','\)\)\) AND is_beta = \$3 AND "app_versions"."deleted_at" IS NULL ORDER BY `+regexp.QuoteMeta(versionOrderSQL)+` LIMIT \$4`).
+					WithArgs(true, "ios", false, maxReleaseCandidates).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "version", "release_notes", "download_url", "is_beta", "is_published", "rollout_percent", "created_at"}).
+						AddRow(8, "1.1.0", "新功能", "https://example.com/release", false, true, 100, time.Now()))
+				mock.ExpectQuery(`SELECT \* FROM "release_notes" WHERE version_id IN \(\$1\)`).
+					WithArgs(8).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "version_id", "locale", "notes"}).
+						AddRow(1, 8, "en", "New features").
+						AddRow(2, 8, "ja", "新機能"))
+				expectReleaseAssets(mock, 8)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: CheckUpdateResponse{
+				HasUpdate:          true,
+				LatestVersion:      "1.1.0",
+				ReleaseNotes:       "New features",
+				ReleaseNotesLocale: "en",
+				DownloadURL:        "https://example.com/release",
+			},
+		},
 		{
 			name: "No Versions in DB",
 			request: CheckUpdateRequest{
@@ -196,25 +354,11 @@ func TestCheckUpdate(t *testing.T) {
 				IsBeta:     false,
 			},
 			setupMock: func(mock sqlmock.Sqlmock) {
-				// 1. updateStatistics
-				mock.ExpectQuery(`SELECT \* FROM "app_statistics" WHERE device_id = \$1 ORDER BY "app_statistics"."id" LIMIT \$2`).
-					WithArgs("test-device", 1).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "total_launches"}).AddRow(1, 10))
-				mock.ExpectBegin()
-				mock.ExpectExec(`UPDATE "app_statistics" SET`).
-					WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectCommit()
-
-				// 2. recordDailyActivity
-				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity (device_id, platform, app_version, seen_date, seen_at)
-            VALUES ($1, $2, $3, ($4::date), $5)
-            ON CONFLICT (device_id, seen_date) DO NOTHING`)).
-					WillReturnResult(sqlmock.NewResult(1, 1))
-
-				// 3. getLatestVersion - RecordNotFound
-				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE .*is_latest = \$1 AND is_published = \$2.*is_beta = \$3.* LIMIT \$4`).
-					WithArgs(true, true, false, 1).
-					WillReturnError(gorm.ErrRecordNotFound)
+				expectMinSupportedVersions(mock)
+				// 1. getLatestVersion - no candidates
+				mock.ExpectQuery(`SELECT \* FROM "app_versions" WHERE is_published = \$1 AND \(platforms = '' OR \$2 = ANY\(string_to_array\(platforms, ','\)\)\) AND is_beta = \$3 AND "app_versions"."deleted_at" IS NULL ORDER BY `+regexp.QuoteMeta(versionOrderSQL)+` LIMIT \$4`).
+					WithArgs(true, "android", false, maxReleaseCandidates).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}))
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: CheckUpdateResponse{
@@ -237,7 +381,6 @@ func TestCheckUpdate(t *testing.T) {
 
 			// Call handler
 			service.CheckUpdate(c)
-			time.Sleep(20 * time.Millisecond)
 
 			// Assertions
 			assert.Equal(t, tt.expectedStatus, w.Code)
@@ -247,8 +390,45 @@ func TestCheckUpdate(t *testing.T) {
 			assert.NoError(t, err)
 			assert.Equal(t, tt.expectedBody, response)
 
+			// The check is left to the analytics writer
+			select {
+			case ev := <-ingest.events:
+				assert.Equal(t, tt.request.DeviceID, ev.DeviceID)
+				assert.Equal(t, tt.request.AppVersion, ev.AppVersion)
+			default:
+				t.Error("update check was not queued")
+			}
+
 			// Ensure all expectations were met
 			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
+
+func TestCheckUpdateRejectsOversizedFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	ingest := newIngestQueue(db, ingestConfig{QueueSize: 4, BatchSize: 4, FlushInterval: time.Second})
+	service := NewAppService(db, nil, ingest)
+	for _, req := range []CheckUpdateRequest{
+		{DeviceID: strings.Repeat("d", 101), Platform: "android", AppVersion: "2.30.0"},
+		{DeviceID: "device-1", Platform: strings.Repeat("p", 51), AppVersion: "2.30.0"},
+		{DeviceID: "device-1", Platform: "android", AppVersion: strings.Repeat("9", 51)},
+	} {
+		body, _ := json.Marshal(req)
+		r, _ := http.NewRequest(http.MethodPost, "/api/v1/check-update", bytes.NewBuffer(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = r
+
+		service.CheckUpdate(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+	// Nothing reaches the batch writer, where the columns would reject it
+	assert.Zero(t, len(ingest.events))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+    "context"
+    "database/sql"
     "flag"
     "fmt"
     "log"
     "os"
     "strconv"
+    "time"
 
     "github.com/joho/godotenv"
     goose "github.com/pressly/goose/v3"
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "server/analytics"
     migfs "server/migrations"
+    "server/privacy"
 )
 
 // load .env files using godotenv; ignore missing files
@@ -51,7 +58,7 @@ func main() {
 	flag.Parse()
 	args := flag.Args()
 	if len(args) < 1 {
-		fmt.Println("migrate requires a command: status|up|down|redo|reset|up-to|down-to")
+		fmt.Println("migrate requires a command: status|up|down|redo|reset|up-to|down-to|rollup|rebucket|purge|ip-policy")
 		os.Exit(1)
 	}
 	cmd := args[0]
@@ -110,7 +117,165 @@ func main() {
         if err := goose.DownTo(db, migrationsDir, version); err != nil {
             log.Fatalf("goose down-to: %v", err)
         }
+	case "rollup":
+		if err := rollup(db, args[1:]); err != nil {
+			log.Fatalf("rollup: %v", err)
+		}
+	case "rebucket":
+		if err := rebucket(db); err != nil {
+			log.Fatalf("rebucket: %v", err)
+		}
+	case "purge":
+		if err := purge(db, args[1:]); err != nil {
+			log.Fatalf("purge: %v", err)
+		}
+	case "ip-policy":
+		if err := applyIPPolicy(db); err != nil {
+			log.Fatalf("ip-policy: %v", err)
+		}
 	default:
 		log.Fatalf("unknown command: %s", cmd)
 	}
 }
+
+// openGorm wraps the goose connection for the analytics and privacy packages.
+func openGorm(sqlDB *sql.DB) (*gorm.DB, error) {
+    return gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+}
+
+// newRoller opens the analytics rollups in ANALYTICS_TIMEZONE, the zone of
+// the seen_date buckets written by the server.
+func newRoller(sqlDB *sql.DB) (*analytics.Roller, *analytics.Zone, error) {
+    db, err := openGorm(sqlDB)
+    if err != nil {
+        return nil, nil, err
+    }
+    zone, err := analytics.ZoneFromEnv()
+    if err != nil {
+        return nil, nil, err
+    }
+    return analytics.NewRoller(db, zone), zone, nil
+}
+
+// rollup fills the analytics rollup tables. Without arguments it rolls the
+// days since the last run; "rollup <from> [to]" recomputes the given days
+// (YYYY-MM-DD, to defaults to yesterday), e.g. to backfill after an upgrade.
+func rollup(sqlDB *sql.DB, args []string) error {
+    roller, zone, err := newRoller(sqlDB)
+    if err != nil {
+        return err
+    }
+    ctx := context.Background()
+    now := time.Now()
+
+    var rolled int
+    if len(args) == 0 {
+        if rolled, err = roller.RollPending(ctx, now); err != nil {
+            return err
+        }
+    } else {
+        from, err := zone.ParseDay(args[0])
+        if err != nil {
+            return fmt.Errorf("invalid from date: %v", err)
+        }
+        to := zone.Day(now).AddDate(0, 0, -1)
+        if len(args) > 1 {
+            if to, err = zone.ParseDay(args[1]); err != nil {
+                return fmt.Errorf("invalid to date: %v", err)
+            }
+        }
+        if rolled, err = roller.RollDays(ctx, from, to); err != nil {
+            return err
+        }
+    }
+    if err := roller.SnapshotDevices(now); err != nil {
+        return err
+    }
+    log.Printf("rolled up %d days", rolled)
+    return nil
+}
+
+// rebucket moves app_activity to the days of ANALYTICS_TIMEZONE after it was
+// changed, then rebuilds the rollups.
+func rebucket(sqlDB *sql.DB) error {
+    roller, zone, err := newRoller(sqlDB)
+    if err != nil {
+        return err
+    }
+    changed, err := roller.Rebucket()
+    if err != nil {
+        return err
+    }
+    log.Printf("moved %d app_activity rows to the days of %s", changed, zone.Name)
+    return rollup(sqlDB, nil)
+}
+
+// purge applies ACTIVITY_RETENTION_DAYS and DEVICE_RETENTION_DAYS once, like
+// the server does every hour. With -dry-run it only reports what it would
+// delete or mark.
+func purge(sqlDB *sql.DB, args []string) error {
+    fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+    dryRun := fs.Bool("dry-run", false, "report what would be purged without changing anything")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    ret, err := analytics.RetentionFromEnv()
+    if err != nil {
+        return err
+    }
+    if !ret.Enabled() {
+        log.Printf("ACTIVITY_RETENTION_DAYS and DEVICE_RETENTION_DAYS are not set; nothing to purge")
+        return nil
+    }
+    roller, _, err := newRoller(sqlDB)
+    if err != nil {
+        return err
+    }
+    res, err := roller.Purge(ret, time.Now(), *dryRun)
+    if err != nil {
+        return err
+    }
+    verb := "deleted"
+    if *dryRun {
+        verb = "would delete"
+    }
+    if res.ActivityBefore.IsZero() {
+        log.Printf("app_activity: nothing to purge")
+    } else {
+        log.Printf("app_activity: %s %d rows before %s", verb, res.Activity, res.ActivityBefore.Format("2006-01-02"))
+    }
+    if ret.PurgeDevices {
+        log.Printf("devices: %s %d devices unseen for %d days with all their data", verb, res.PurgedDevices, ret.DeviceDays)
+    } else if ret.DeviceDays > 0 {
+        mark := "marked"
+        if *dryRun {
+            mark = "would mark"
+        }
+        log.Printf("app_statistics: %s %d devices unseen for %d days dormant", mark, res.DormantDevices, ret.DeviceDays)
+    }
+    return nil
+}
+
+// applyIPPolicy rewrites the stored client IPs under IP_POLICY, e.g. after
+// switching from raw to truncate or hash, then scrubs the IPs older than
+// IP_RETENTION_DAYS.
+func applyIPPolicy(sqlDB *sql.DB) error {
+    db, err := openGorm(sqlDB)
+    if err != nil {
+        return err
+    }
+    policy, err := privacy.IPPolicyFromEnv()
+    if err != nil {
+        return err
+    }
+    changed, err := policy.Apply(db)
+    if err != nil {
+        return err
+    }
+    scrubbed, err := policy.Scrub(db, time.Now())
+    if err != nil {
+        return err
+    }
+    log.Printf("IP policy %s: rewrote %d IPs, scrubbed %d past retention", policy.Mode, changed, scrubbed)
+    return nil
+}
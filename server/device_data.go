@@ -0,0 +1,446 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"server/analytics"
+)
+
+// deviceDataTables lists the tables holding rows of a single device by
+// device_id, in the order they are erased; DEVICE_RETENTION_MODE=purge deletes
+// unseen devices from the same tables.
+var deviceDataTables = analytics.DeviceTables
+
+// erasedDeviceTTL is how long an erasure is remembered in erased_devices.
+// Check-ins queued before it are written within seconds; later ones are
+// recorded as usual.
+const erasedDeviceTTL = 24 * time.Hour
+
+// erasedDeviceKey is the key of deviceID in erased_devices.
+func erasedDeviceKey(deviceID string) string {
+	sum := sha256.Sum256([]byte(deviceID))
+	return hex.EncodeToString(sum[:])
+}
+
+// Who asked for an erasure, as recorded in device_erasures.
+const (
+	erasureByAdmin  = "admin"
+	erasureByDevice = "device"
+)
+
+// deviceTokenSecret keys the self-service device tokens; self-service is
+// disabled while it is empty.
+func deviceTokenSecret() string {
+	return os.Getenv("DEVICE_TOKEN_SECRET")
+}
+
+// deviceToken returns the self-service token of deviceID.
+func deviceToken(secret, deviceID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(deviceID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// deviceSecretHash is how a device secret is stored in device_tokens.
+func deviceSecretHash(deviceSecret string) string {
+	sum := sha256.Sum256([]byte(deviceSecret))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueDeviceToken returns the token of deviceID to the holder of its device
+// secret, whenever asked, and "" to anyone else or while self-service is
+// disabled. A device without a registered secret registers deviceSecret.
+func issueDeviceToken(db *gorm.DB, deviceID, deviceSecret string, now time.Time) (string, error) {
+	secret := deviceTokenSecret()
+	if secret == "" || deviceSecret == "" {
+		return "", nil
+	}
+	res := db.Exec(`INSERT INTO device_tokens (device_id, secret_hash, issued_at) VALUES (?, ?, ?)
+ON CONFLICT (device_id) DO UPDATE
+SET secret_hash = EXCLUDED.secret_hash, issued_at = EXCLUDED.issued_at
+WHERE device_tokens.secret_hash IS NULL OR device_tokens.secret_hash = EXCLUDED.secret_hash`,
+		deviceID, deviceSecretHash(deviceSecret), now)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return "", res.Error
+	}
+	return deviceToken(secret, deviceID), nil
+}
+
+// registerDeviceSecrets registers the earliest secret sent by each of
+// checkins for the devices without one yet, in device_id order like
+// upsertStatistics. A registered secret is never replaced.
+func registerDeviceSecrets(tx *gorm.DB, checkins []*deviceCheckin) error {
+	var secrets []*ingestEvent
+	for _, ci := range checkins {
+		if ci.secret != nil {
+			secrets = append(secrets, ci.secret)
+		}
+	}
+	if len(secrets) == 0 {
+		return nil
+	}
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].DeviceID < secrets[j].DeviceID })
+	rows := make([]string, 0, len(secrets))
+	args := make([]interface{}, 0, len(secrets)*2)
+	for _, ev := range secrets {
+		rows = append(rows, "(?, ?)")
+		args = append(args, ev.DeviceID, ev.SecretHash)
+	}
+	return tx.Exec(`INSERT INTO device_tokens (device_id, secret_hash)
+VALUES `+strings.Join(rows, ", ")+`
+ON CONFLICT (device_id) DO UPDATE
+SET secret_hash = EXCLUDED.secret_hash
+WHERE device_tokens.secret_hash IS NULL`, args...).Error
+}
+
+// POST /api/v1/device/token
+// Hands a device its self-service token, see DeviceTokenRequest. Kept apart
+// from check-update so update checks never write synchronously.
+func DeviceIssueToken(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if deviceTokenSecret() == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "未启用设备自助服务"})
+			return
+		}
+		var req DeviceTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		token, err := issueDeviceToken(db, req.DeviceID, req.DeviceSecret, nowUTC())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue device token"})
+			return
+		}
+		if token == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "设备密钥与登记的不一致"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"device_token": token})
+	}
+}
+
+// DeviceAuthMiddleware authenticates a device by its X-Device-Id and the
+// X-Device-Token issued to it by check-update.
+func DeviceAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := deviceTokenSecret()
+		if secret == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "未启用设备自助服务"})
+			return
+		}
+		deviceID := strings.TrimSpace(c.GetHeader("X-Device-Id"))
+		token := c.GetHeader("X-Device-Token")
+		if deviceID == "" || !hmac.Equal([]byte(token), []byte(deviceToken(secret, deviceID))) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "设备令牌无效"})
+			return
+		}
+		c.Set("device_id", deviceID)
+		c.Next()
+	}
+}
+
+// deviceActivityRecord is a day of app_activity of a device.
+type deviceActivityRecord struct {
+	SeenDate   string    `json:"seen_date"`
+	Platform   string    `json:"platform"`
+	AppVersion string    `json:"app_version"`
+	SeenAt     time.Time `json:"seen_at"`
+	Country    string    `json:"country"`
+	Region     string    `json:"region"`
+}
+
+// deviceEventRecord is a client_events row of a device.
+type deviceEventRecord struct {
+	Name       string          `json:"name"`
+	Platform   string          `json:"platform"`
+	AppVersion string          `json:"app_version"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Properties json.RawMessage `json:"properties"`
+}
+
+// deviceCrashRecord is a crash_reports row of a device.
+type deviceCrashRecord struct {
+	Platform   string    `json:"platform"`
+	AppVersion string    `json:"app_version"`
+	SiteType   string    `json:"site_type"`
+	TemplateID string    `json:"template_id"`
+	Message    string    `json:"message"`
+	StackTrace string    `json:"stack_trace"`
+	LogTail    string    `json:"log_tail"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// deviceFirstRecord is the stats_device_firsts row of a device.
+type deviceFirstRecord struct {
+	FirstDate  string `json:"first_date"`
+	Platform   string `json:"platform"`
+	AppVersion string `json:"app_version"`
+}
+
+// deviceData is everything stored about one device.
+type deviceData struct {
+	DeviceID      string                 `json:"device_id"`
+	Statistics    *AppStatistic          `json:"statistics"`
+	Activity      []deviceActivityRecord `json:"activity"`
+	Events        []deviceEventRecord    `json:"events"`
+	Sites         []ReportedSite         `json:"sites"`
+	Crashes       []deviceCrashRecord    `json:"crash_reports"`
+	FirstSeen     *deviceFirstRecord     `json:"rollup_first_seen"`
+	TokenIssuedAt *time.Time             `json:"token_issued_at"`
+	// SecretRegistered tells whether the device registered its secret; the
+	// secret itself is not stored
+	SecretRegistered bool `json:"secret_registered"`
+}
+
+// empty reports whether nothing is stored about the device.
+func (d *deviceData) empty() bool {
+	return d.Statistics == nil && len(d.Activity) == 0 && len(d.Events) == 0 && len(d.Sites) == 0 && len(d.Crashes) == 0 && d.FirstSeen == nil && d.TokenIssuedAt == nil && !d.SecretRegistered
+}
+
+// loadDeviceData collects the rows of deviceID from every table of
+// deviceDataTables.
+func loadDeviceData(db *gorm.DB, deviceID string) (*deviceData, error) {
+	data := &deviceData{DeviceID: deviceID, Activity: []deviceActivityRecord{}, Events: []deviceEventRecord{}, Sites: []ReportedSite{}, Crashes: []deviceCrashRecord{}}
+
+	var stats []AppStatistic
+	if err := db.Where("device_id = ?", deviceID).Limit(1).Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	if len(stats) > 0 {
+		data.Statistics = &stats[0]
+	}
+
+	var activity []struct {
+		SeenDate   time.Time
+		Platform   string
+		AppVersion string
+		SeenAt     time.Time
+		Country    string
+		Region     string
+	}
+	if err := db.Raw(`SELECT seen_date, platform, app_version, seen_at, country, region
+FROM app_activity
+WHERE device_id = ?
+ORDER BY seen_date`, deviceID).Scan(&activity).Error; err != nil {
+		return nil, err
+	}
+	for _, a := range activity {
+		data.Activity = append(data.Activity, deviceActivityRecord{
+			SeenDate:   a.SeenDate.Format("2006-01-02"),
+			Platform:   a.Platform,
+			AppVersion: a.AppVersion,
+			SeenAt:     a.SeenAt,
+			Country:    a.Country,
+			Region:     a.Region,
+		})
+	}
+
+	var events []struct {
+		Name       string
+		Platform   string
+		AppVersion string
+		OccurredAt time.Time
+		Properties string
+	}
+	if err := db.Raw(`SELECT name, platform, app_version, occurred_at, properties
+FROM client_events
+WHERE device_id = ?
+ORDER BY occurred_at`, deviceID).Scan(&events).Error; err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		data.Events = append(data.Events, deviceEventRecord{
+			Name:       e.Name,
+			Platform:   e.Platform,
+			AppVersion: e.AppVersion,
+			OccurredAt: e.OccurredAt,
+			Properties: json.RawMessage(e.Properties),
+		})
+	}
+
+	if err := db.Raw("SELECT template_id, site_type FROM device_sites WHERE device_id = ? ORDER BY template_id, site_type", deviceID).
+		Scan(&data.Sites).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Raw(`SELECT platform, app_version, site_type, template_id, message, stack_trace, log_tail, occurred_at
+FROM crash_reports
+WHERE device_id = ?
+ORDER BY occurred_at`, deviceID).Scan(&data.Crashes).Error; err != nil {
+		return nil, err
+	}
+
+	var firsts []struct {
+		FirstDate  time.Time
+		Platform   string
+		AppVersion string
+	}
+	if err := db.Raw("SELECT first_date, platform, app_version FROM stats_device_firsts WHERE device_id = ?", deviceID).
+		Scan(&firsts).Error; err != nil {
+		return nil, err
+	}
+	if len(firsts) > 0 {
+		f := firsts[0]
+		data.FirstSeen = &deviceFirstRecord{FirstDate: f.FirstDate.Format("2006-01-02"), Platform: f.Platform, AppVersion: f.AppVersion}
+	}
+
+	var tokens []struct {
+		IssuedAt   *time.Time
+		SecretHash *string
+	}
+	if err := db.Raw("SELECT issued_at, secret_hash FROM device_tokens WHERE device_id = ?", deviceID).Scan(&tokens).Error; err != nil {
+		return nil, err
+	}
+	if len(tokens) > 0 {
+		data.TokenIssuedAt = tokens[0].IssuedAt
+		data.SecretRegistered = tokens[0].SecretHash != nil
+	}
+	return data, nil
+}
+
+// eraseDeviceData deletes the rows of deviceID from every table of
+// deviceDataTables and records the erasure in device_erasures, all in one
+// transaction. It returns the number of rows deleted per table; nothing is
+// recorded in device_erasures when there was nothing to delete. The device is
+// remembered in erased_devices either way, so its check-ins still queued are
+// not written afterwards.
+func eraseDeviceData(db *gorm.DB, deviceID, requestedBy string, now time.Time) (map[string]int64, error) {
+	deleted := make(map[string]int64, len(deviceDataTables))
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var total int64
+		for _, table := range deviceDataTables {
+			res := tx.Exec("DELETE FROM "+table+" WHERE device_id = ?", deviceID)
+			if res.Error != nil {
+				return res.Error
+			}
+			deleted[table] = res.RowsAffected
+			total += res.RowsAffected
+		}
+		if err := tx.Exec(`INSERT INTO erased_devices (device_hash, erased_at) VALUES (?, ?)
+ON CONFLICT (device_hash) DO UPDATE SET erased_at = EXCLUDED.erased_at`, erasedDeviceKey(deviceID), now).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM erased_devices WHERE erased_at < ?", now.Add(-erasedDeviceTTL)).Error; err != nil {
+			return err
+		}
+		if total == 0 {
+			return nil
+		}
+		counts, err := json.Marshal(deleted)
+		if err != nil {
+			return err
+		}
+		return tx.Exec("INSERT INTO device_erasures (erased_at, requested_by, deleted_rows) VALUES (?, ?, ?::jsonb)",
+			now, requestedBy, string(counts)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// totalDeleted sums the row counts of eraseDeviceData.
+func totalDeleted(deleted map[string]int64) int64 {
+	var total int64
+	for _, n := range deleted {
+		total += n
+	}
+	return total
+}
+
+// serveDeviceData writes everything stored about deviceID.
+func serveDeviceData(c *gin.Context, db *gorm.DB, deviceID string) {
+	data, err := loadDeviceData(db, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load device data"})
+		return
+	}
+	if data.empty() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有该设备的数据"})
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+// serveDeviceErasure erases deviceID on behalf of requestedBy.
+func serveDeviceErasure(c *gin.Context, db *gorm.DB, deviceID, requestedBy string) {
+	deleted, err := eraseDeviceData(db, deviceID, requestedBy, nowUTC())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase device data"})
+		return
+	}
+	if totalDeleted(deleted) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有该设备的数据"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// GET /api/v1/admin/devices/:device_id
+func AdminDeviceData(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serveDeviceData(c, db, c.Param("device_id"))
+	}
+}
+
+// DELETE /api/v1/admin/devices/:device_id
+func AdminEraseDevice(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serveDeviceErasure(c, db, c.Param("device_id"), erasureByAdmin)
+	}
+}
+
+// deviceErasure is an entry of the erasure audit trail.
+type deviceErasure struct {
+	ID          int              `json:"id"`
+	ErasedAt    time.Time        `json:"erased_at"`
+	RequestedBy string           `json:"requested_by"`
+	DeletedRows map[string]int64 `json:"deleted_rows" gorm:"serializer:json"`
+}
+
+// GET /api/v1/admin/device-erasures
+// Query: limit (default 50, at most 500), newest first.
+func AdminListDeviceErasures(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit <= 0 || limit > 500 {
+			limit = 50
+		}
+		var items []deviceErasure
+		if err := db.Table("device_erasures").Order("erased_at DESC, id DESC").Limit(limit).Find(&items).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list device erasures"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"items": items})
+	}
+}
+
+// GET /api/v1/device/data
+// The device's own data, see DeviceAuthMiddleware.
+func DeviceOwnData(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serveDeviceData(c, db, c.GetString("device_id"))
+	}
+}
+
+// DELETE /api/v1/device/data
+// Erases the device's own data, see DeviceAuthMiddleware.
+func DeviceEraseOwnData(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serveDeviceErasure(c, db, c.GetString("device_id"), erasureByDevice)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueDeviceTokenToSecretHolder(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	t.Setenv("DEVICE_TOKEN_SECRET", "secret")
+	now := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	deviceSecret := strings.Repeat("a", 43)
+	insert := regexp.QuoteMeta(`INSERT INTO device_tokens (device_id, secret_hash, issued_at) VALUES ($1, $2, $3)
+ON CONFLICT (device_id) DO UPDATE
+SET secret_hash = EXCLUDED.secret_hash, issued_at = EXCLUDED.issued_at
+WHERE device_tokens.secret_hash IS NULL OR device_tokens.secret_hash = EXCLUDED.secret_hash`)
+	mock.ExpectExec(insert).WithArgs("device-1", deviceSecretHash(deviceSecret), now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(insert).WithArgs("device-1", deviceSecretHash(deviceSecret), now).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Another secret does not match the registered one
+	mock.ExpectExec(insert).WithArgs("device-1", deviceSecretHash("guessed"), now).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	token, err := issueDeviceToken(db, "device-1", deviceSecret, now)
+	require.NoError(t, err)
+	assert.Equal(t, deviceToken("secret", "device-1"), token)
+	assert.NotEqual(t, deviceToken("secret", "device-2"), token)
+
+	// The device holding the secret gets its token again, e.g. after a reinstall restoring it
+	token, err = issueDeviceToken(db, "device-1", deviceSecret, now)
+	require.NoError(t, err)
+	assert.Equal(t, deviceToken("secret", "device-1"), token)
+
+	token, err = issueDeviceToken(db, "device-1", "guessed", now)
+	require.NoError(t, err)
+	assert.Empty(t, token)
+
+	// Without a device secret, or without the server secret, nothing is issued
+	token, err = issueDeviceToken(db, "device-1", "", now)
+	require.NoError(t, err)
+	assert.Empty(t, token)
+	t.Setenv("DEVICE_TOKEN_SECRET", "")
+	token, err = issueDeviceToken(db, "device-1", deviceSecret, now)
+	require.NoError(t, err)
+	assert.Empty(t, token)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeviceIssueToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	deviceSecret := strings.Repeat("a", 43)
+	insert := regexp.QuoteMeta(`INSERT INTO device_tokens (device_id, secret_hash, issued_at)`)
+	mock.ExpectExec(insert).WithArgs("device-1", deviceSecretHash(deviceSecret), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(insert).WithArgs("device-1", deviceSecretHash(strings.Repeat("b", 43)), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	for _, tc := range []struct {
+		name, secret, body string
+		status             int
+	}{
+		{"disabled", "", `{"device_id":"device-1","device_secret":"` + deviceSecret + `"}`, http.StatusNotFound},
+		{"registered secret", "secret", `{"device_id":"device-1","device_secret":"` + deviceSecret + `"}`, http.StatusOK},
+		{"other secret", "secret", `{"device_id":"device-1","device_secret":"` + strings.Repeat("b", 43) + `"}`, http.StatusForbidden},
+		{"short secret", "secret", `{"device_id":"device-1","device_secret":"short"}`, http.StatusBadRequest},
+	} {
+		t.Setenv("DEVICE_TOKEN_SECRET", tc.secret)
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/device/token", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+
+		DeviceIssueToken(db)(c)
+
+		require.Equal(t, tc.status, w.Code, tc.name)
+		if tc.status == http.StatusOK {
+			assert.JSONEq(t, `{"device_token":"`+deviceToken("secret", "device-1")+`"}`, w.Body.String())
+		}
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeviceAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(DeviceAuthMiddleware())
+	r.GET("/api/v1/device/data", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("device_id"))
+	})
+
+	for _, tc := range []struct {
+		name, secret, token string
+		status              int
+	}{
+		{"disabled", "", deviceToken("", "device-1"), http.StatusNotFound},
+		{"wrong token", "secret", deviceToken("other", "device-1"), http.StatusUnauthorized},
+		{"valid token", "secret", deviceToken("secret", "device-1"), http.StatusOK},
+	} {
+		t.Setenv("DEVICE_TOKEN_SECRET", tc.secret)
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/device/data", nil)
+		req.Header.Set("X-Device-Id", "device-1")
+		req.Header.Set("X-Device-Token", tc.token)
+		w := httptest.NewRecorder()
+
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.status, w.Code, tc.name)
+		if tc.status == http.StatusOK {
+			assert.Equal(t, "device-1", w.Body.String())
+		}
+	}
+}
+
+func TestAdminDeviceDataCollectsEveryTable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	seen := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "app_statistics" WHERE device_id = $1 LIMIT $2`)).
+		WithArgs("device-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "device_id", "platform", "app_version", "ip", "last_seen"}).
+			AddRow(1, "device-1", "ios", "2.30.0", "203.0.113.0", seen))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT seen_date, platform, app_version, seen_at, country, region
+FROM app_activity
+WHERE device_id = $1`)).
+		WithArgs("device-1").
+		WillReturnRows(sqlmock.NewRows([]string{"seen_date", "platform", "app_version", "seen_at", "country", "region"}).
+			AddRow(day, "ios", "2.30.0", seen, "JP", "13"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT name, platform, app_version, occurred_at, properties
+FROM client_events
+WHERE device_id = $1`)).
+		WithArgs("device-1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "platform", "app_version", "occurred_at", "properties"}).
+			AddRow("download.send", "ios", "2.30.0", seen, `{"client":"qbittorrent","success":true}`))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT template_id, site_type FROM device_sites WHERE device_id = $1`)).
+		WithArgs("device-1").
+		WillReturnRows(sqlmock.NewRows([]string{"template_id", "site_type"}).AddRow("mteam", "M-Team"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT platform, app_version, site_type, template_id, message, stack_trace, log_tail, occurred_at
+FROM crash_reports
+WHERE device_id = $1`)).
+		WithArgs("device-1").
+		WillReturnRows(sqlmock.NewRows([]string{"platform", "app_version", "site_type", "template_id", "message", "stack_trace", "log_tail", "occurred_at"}).
+			AddRow("ios", "2.30.0", "M-Team", "mteam", "FormatException: Unexpected character", "#0      MTeamAdapter.search", "", seen))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT first_date, platform, app_version FROM stats_device_firsts WHERE device_id = $1`)).
+		WithArgs("device-1").
+		WillReturnRows(sqlmock.NewRows([]string{"first_date", "platform", "app_version"}).AddRow(day, "ios", "2.29.0"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT issued_at, secret_hash FROM device_tokens WHERE device_id = $1`)).
+		WithArgs("device-1").
+		WillReturnRows(sqlmock.NewRows([]string{"issued_at", "secret_hash"}).AddRow(nil, deviceSecretHash("device secret")))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/devices/device-1", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Params = gin.Params{{Key: "device_id", Value: "device-1"}}
+
+	AdminDeviceData(db)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body deviceData
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.NotNil(t, body.Statistics)
+	assert.Equal(t, "203.0.113.0", body.Statistics.IP)
+	assert.Equal(t, []deviceActivityRecord{{SeenDate: "2024-05-10", Platform: "ios", AppVersion: "2.30.0", SeenAt: seen, Country: "JP", Region: "13"}}, body.Activity)
+	require.Len(t, body.Events, 1)
+	assert.Equal(t, "download.send", body.Events[0].Name)
+	assert.JSONEq(t, `{"client":"qbittorrent","success":true}`, string(body.Events[0].Properties))
+	assert.Equal(t, []ReportedSite{{TemplateID: "mteam", SiteType: "M-Team"}}, body.Sites)
+	require.Len(t, body.Crashes, 1)
+	assert.Equal(t, "FormatException: Unexpected character", body.Crashes[0].Message)
+	assert.Equal(t, &deviceFirstRecord{FirstDate: "2024-05-10", Platform: "ios", AppVersion: "2.29.0"}, body.FirstSeen)
+	assert.Nil(t, body.TokenIssuedAt)
+	assert.True(t, body.SecretRegistered)
+}
+
+// expectErasedDeviceMark expects deviceID to be remembered in erased_devices
+// at erasedAt, and the erasures past erasedDeviceTTL to be forgotten.
+func expectErasedDeviceMark(mock sqlmock.Sqlmock, deviceID string, erasedAt driver.Value) {
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO erased_devices (device_hash, erased_at) VALUES ($1, $2)`)).
+		WithArgs(erasedDeviceKey(deviceID), erasedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM erased_devices WHERE erased_at < $1`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestEraseDeviceDataRecordsAuditWithoutDeviceID(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	now := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	for table, n := range map[string]int64{"app_activity": 12, "client_events": 3, "device_sites": 2, "crash_reports": 1, "stats_device_firsts": 1, "device_tokens": 0, "app_statistics": 1} {
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM ` + table + ` WHERE device_id = $1`)).
+			WithArgs("device-1").WillReturnResult(sqlmock.NewResult(0, n))
+	}
+	expectErasedDeviceMark(mock, "device-1", now)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO device_erasures (erased_at, requested_by, deleted_rows) VALUES ($1, $2, $3::jsonb)`)).
+		WithArgs(now, erasureByDevice, `{"app_activity":12,"app_statistics":1,"client_events":3,"crash_reports":1,"device_sites":2,"device_tokens":0,"stats_device_firsts":1}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.MatchExpectationsInOrder(false)
+
+	deleted, err := eraseDeviceData(db, "device-1", erasureByDevice, now)
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), totalDeleted(deleted))
+}
+
+func TestAdminEraseUnknownDevice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	for _, table := range deviceDataTables {
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM ` + table + ` WHERE device_id = $1`)).
+			WithArgs("missing").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	// Nothing is audited, but a check-in still queued must not create the device
+	expectErasedDeviceMark(mock, "missing", sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest(http.MethodDelete, "/api/v1/admin/devices/missing", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Params = gin.Params{{Key: "device_id", Value: "missing"}}
+
+	AdminEraseDevice(db)(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
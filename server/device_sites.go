@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxReportedSites bounds the sites a device may report.
+const maxReportedSites = 200
+
+// siteTemplateIDs are the ids of the site templates in assets/sites of the
+// app. Anything else, such as a URL or a user name, is never stored; a test
+// keeps the list in sync with the templates.
+var siteTemplateIDs = []string{
+	"afun", "agsvpt", "audiences", "btschool", "cbg", "cspt", "dicmusic", "dubhe",
+	"frds", "freefarm", "hddolby", "hdfans", "hdkyl", "hhanclub", "htpt", "hxpt",
+	"jpopsuki", "lajidui", "luckpt", "momentpt", "monikadesign", "mteam",
+	"nicept", "opencd", "ourbits", "piggo", "ptfans", "ptgtk", "ptskit", "pttime",
+	"ptzone", "qingwapt", "rousi", "rousipro", "ssd", "ttg", "u2share", "ubits",
+	"xingtan", "xingyunge", "zmpt",
+}
+
+// sanitizeReportedSites drops the malformed and duplicate sites of a
+// check-update request, keeping nil (not reported) apart from empty.
+func sanitizeReportedSites(sites []ReportedSite) []ReportedSite {
+	if sites == nil {
+		return nil
+	}
+	out := make([]ReportedSite, 0, len(sites))
+	seen := make(map[ReportedSite]bool, len(sites))
+	for _, site := range sites {
+		site.TemplateID = strings.TrimSpace(site.TemplateID)
+		if site.TemplateID != "" && !containsString(siteTemplateIDs, site.TemplateID) {
+			continue
+		}
+		if !containsString(siteTypes, site.SiteType) || seen[site] {
+			continue
+		}
+		seen[site] = true
+		out = append(out, site)
+		if len(out) == maxReportedSites {
+			break
+		}
+	}
+	return out
+}
+
+// siteReport is the latest list of sites of a device in an ingest batch.
+type siteReport struct {
+	deviceID string
+	sites    []ReportedSite
+	at       time.Time
+}
+
+// replaceDeviceSites replaces the device_sites rows of every reporting device.
+func replaceDeviceSites(tx *gorm.DB, reports []*siteReport) error {
+	if len(reports) == 0 {
+		return nil
+	}
+	// In device_id order, like upsertStatistics
+	sort.Slice(reports, func(i, j int) bool { return reports[i].deviceID < reports[j].deviceID })
+	deviceIDs := make([]string, 0, len(reports))
+	var rows []string
+	var args []interface{}
+	for _, r := range reports {
+		deviceIDs = append(deviceIDs, r.deviceID)
+		for _, site := range r.sites {
+			rows = append(rows, "(?, ?, ?, ?)")
+			args = append(args, r.deviceID, site.TemplateID, site.SiteType, r.at)
+		}
+	}
+	if err := tx.Exec("DELETE FROM device_sites WHERE device_id IN ?", deviceIDs).Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.Exec(`INSERT INTO device_sites (device_id, template_id, site_type, reported_at)
+VALUES `+strings.Join(rows, ", "), args...).Error
+}
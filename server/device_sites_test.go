@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeReportedSites(t *testing.T) {
+	assert.Nil(t, sanitizeReportedSites(nil))
+	assert.Equal(t, []ReportedSite{}, sanitizeReportedSites([]ReportedSite{}))
+
+	sites := sanitizeReportedSites([]ReportedSite{
+		{TemplateID: "mteam", SiteType: "M-Team"},
+		{TemplateID: "mteam", SiteType: "M-Team"},
+		{TemplateID: "", SiteType: "NexusPHPWeb"},
+		{TemplateID: "https://tracker.example.com/?passkey=abc", SiteType: "NexusPHP"},
+		{TemplateID: "afun", SiteType: "SomethingElse"},
+		// Well-formed, but no such template
+		{TemplateID: "alice", SiteType: "NexusPHPWeb"},
+	})
+	assert.Equal(t, []ReportedSite{{TemplateID: "mteam", SiteType: "M-Team"}, {TemplateID: "", SiteType: "NexusPHPWeb"}}, sites)
+}
+
+func TestSiteTemplateIDsMatchAssets(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "assets", "sites", "*.json"))
+	require.NoError(t, err)
+	if len(files) == 0 {
+		t.Skip("assets/sites not found")
+	}
+	var ids []string
+	for _, f := range files {
+		raw, err := os.ReadFile(f)
+		require.NoError(t, err)
+		var template struct {
+			ID string `json:"id"`
+		}
+		require.NoError(t, json.Unmarshal(raw, &template), f)
+		ids = append(ids, template.ID)
+	}
+	sort.Strings(ids)
+	assert.Equal(t, ids, siteTemplateIDs, "siteTemplateIDs must list the ids of assets/sites")
+}
+
+func TestWriteIngestBatchReplacesReportedSites(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	first := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	later := first.Add(time.Minute)
+	batch := []ingestEvent{
+		{DeviceID: "device-1", Platform: "ios", AppVersion: "2.30.0", At: first, Sites: []ReportedSite{{TemplateID: "afun", SiteType: "NexusPHPWeb"}}},
+		// Old apps do not report their sites; device-1 reported again later
+		{DeviceID: "device-2", Platform: "ios", AppVersion: "2.29.0", At: first},
+		{DeviceID: "device-1", Platform: "ios", AppVersion: "2.30.0", At: later, Sites: []ReportedSite{{TemplateID: "mteam", SiteType: "M-Team"}, {TemplateID: "", SiteType: "Gazelle"}}},
+		{DeviceID: "device-3", Platform: "android", AppVersion: "2.30.0", At: first, Sites: []ReportedSite{}},
+	}
+
+	mock.ExpectBegin()
+	expectErasedDevices(mock, nil)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics`)).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity`)).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM device_sites WHERE device_id IN ($1,$2)`)).
+		WithArgs("device-1", "device-3").
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO device_sites (device_id, template_id, site_type, reported_at)
+VALUES ($1, $2, $3, $4), ($5, $6, $7, $8)`)).
+		WithArgs("device-1", "mteam", "M-Team", later, "device-1", "", "Gazelle", later).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	require.NoError(t, writeIngestBatch(db, batch))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
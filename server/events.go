@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxEventsBody bounds the body of an events batch
+	maxEventsBody = 256 << 10
+	// maxEventsPerBatch is the number of events a batch may carry
+	maxEventsPerBatch = 100
+	// maxEventProperties is the number of properties an event may carry
+	maxEventProperties = 16
+)
+
+// eventPropType is the JSON type of an event property.
+type eventPropType string
+
+const (
+	eventPropString eventPropType = "string"
+	eventPropInt    eventPropType = "int"
+	eventPropBool   eventPropType = "bool"
+)
+
+// eventProp describes a property of an event.
+type eventProp struct {
+	Type     eventPropType
+	Required bool
+	// Enum lists the values of a string property. Strings are always
+	// enumerated, so no free text such as URLs or user names is stored.
+	Enum []string
+}
+
+// eventSchema maps the properties of an event to their description; other
+// properties are rejected.
+type eventSchema map[string]eventProp
+
+// downloaderClients are the values of DownloaderType in the app.
+var downloaderClients = []string{"qbittorrent", "transmission", "rutorrent"}
+
+// siteTypes are the ids of SiteType in the app.
+var siteTypes = []string{"M-Team", "NexusPHP", "NexusPHPWeb", "Web", "RousiPro", "Gazelle", "Unit3D"}
+
+// eventSchemas lists the events the app may report, by name. Add new events
+// here before the app sends them; unknown events are rejected.
+var eventSchemas = map[string]eventSchema{
+	// An aggregate search over several sites
+	"search.aggregate": {
+		"sites":       {Type: eventPropInt, Required: true},
+		"results":     {Type: eventPropInt},
+		"duration_ms": {Type: eventPropInt},
+	},
+	// A search of a single site by its adapter
+	"site.search": {
+		"site_type": {Type: eventPropString, Required: true, Enum: siteTypes},
+		"success":   {Type: eventPropBool, Required: true},
+	},
+	// A torrent sent to a downloader client
+	"download.send": {
+		"client":  {Type: eventPropString, Required: true, Enum: downloaderClients},
+		"success": {Type: eventPropBool, Required: true},
+	},
+	// A downloader client added in the settings
+	"downloader.add": {
+		"client": {Type: eventPropString, Required: true, Enum: downloaderClients},
+	},
+}
+
+// validate checks props against the schema, returning them with whole
+// numbers as int64.
+func (s eventSchema) validate(props map[string]interface{}) (map[string]interface{}, error) {
+	if len(props) > maxEventProperties {
+		return nil, fmt.Errorf("属性不能超过 %d 个", maxEventProperties)
+	}
+	out := make(map[string]interface{}, len(props))
+	for key, value := range props {
+		prop, ok := s[key]
+		if !ok {
+			return nil, fmt.Errorf("未知属性 %s", key)
+		}
+		switch prop.Type {
+		case eventPropString:
+			v, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("属性 %s 应为字符串", key)
+			}
+			if !containsString(prop.Enum, v) {
+				return nil, fmt.Errorf("属性 %s 的取值 %q 不在允许范围内", key, v)
+			}
+			out[key] = v
+		case eventPropInt:
+			v, ok := value.(float64)
+			if !ok || v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+				return nil, fmt.Errorf("属性 %s 应为整数", key)
+			}
+			out[key] = int64(v)
+		case eventPropBool:
+			v, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("属性 %s 应为布尔值", key)
+			}
+			out[key] = v
+		}
+	}
+	for key, prop := range s {
+		if _, ok := out[key]; prop.Required && !ok {
+			return nil, fmt.Errorf("缺少属性 %s", key)
+		}
+	}
+	return out, nil
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// eventNames returns the names of eventSchemas, sorted.
+func eventNames() []string {
+	names := make([]string, 0, len(eventSchemas))
+	for name := range eventSchemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// clientEvent is an event as reported by the app.
+type clientEvent struct {
+	Name       string                 `json:"name"`
+	Timestamp  time.Time              `json:"timestamp"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type ClientEventsRequest struct {
+	DeviceID   string        `json:"device_id" binding:"required,max=100"`
+	Platform   string        `json:"platform" binding:"required,max=50"`
+	AppVersion string        `json:"app_version" binding:"required,max=50"`
+	Events     []clientEvent `json:"events" binding:"required,min=1"`
+}
+
+// rejectedEvent reports why an event of a batch was not stored.
+type rejectedEvent struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// validatedEvent is an event ready to be stored.
+type validatedEvent struct {
+	Name       string
+	OccurredAt time.Time
+	Properties string
+}
+
+// validateEvent checks ev against its schema and the accepted time range
+// around now.
+func validateEvent(ev clientEvent, now time.Time) (validatedEvent, error) {
+	schema, ok := eventSchemas[ev.Name]
+	if !ok {
+		return validatedEvent{}, fmt.Errorf("未知事件 %q", ev.Name)
+	}
+	if ev.Timestamp.Before(now.Add(-eventMaxAge)) || ev.Timestamp.After(now.Add(eventMaxSkew)) {
+		return validatedEvent{}, fmt.Errorf("事件时间超出允许范围")
+	}
+	props, err := schema.validate(ev.Properties)
+	if err != nil {
+		return validatedEvent{}, err
+	}
+	encoded, err := json.Marshal(props)
+	if err != nil {
+		return validatedEvent{}, err
+	}
+	return validatedEvent{Name: ev.Name, OccurredAt: ev.Timestamp.UTC(), Properties: string(encoded)}, nil
+}
+
+// insertClientEvents stores the events of a device in one statement.
+func insertClientEvents(db *gorm.DB, req *ClientEventsRequest, events []validatedEvent, now time.Time) error {
+	rows := make([]string, 0, len(events))
+	args := make([]interface{}, 0, len(events)*7)
+	for _, ev := range events {
+		rows = append(rows, "(?, ?, ?, ?, ?, ?, ?::jsonb)")
+		args = append(args, ev.Name, req.DeviceID, req.Platform, req.AppVersion, ev.OccurredAt, now, ev.Properties)
+	}
+	return db.Exec(`INSERT INTO client_events (name, device_id, platform, app_version, occurred_at, received_at, properties)
+VALUES `+strings.Join(rows, ", "), args...).Error
+}
+
+// POST /api/v1/events
+// Stores a batch of feature usage events of a device. Events that fail their
+// schema are skipped and listed in rejected, by their index in the batch; the
+// app should not send them again.
+func ClientEvents(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxEventsBody)
+		var req ClientEventsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "请求体过大"})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(req.Events) > maxEventsPerBatch {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("每批最多 %d 个事件", maxEventsPerBatch)})
+			return
+		}
+
+		now := nowUTC()
+		accepted := make([]validatedEvent, 0, len(req.Events))
+		rejected := []rejectedEvent{}
+		for i, ev := range req.Events {
+			v, err := validateEvent(ev, now)
+			if err != nil {
+				rejected = append(rejected, rejectedEvent{Index: i, Error: err.Error()})
+				continue
+			}
+			accepted = append(accepted, v)
+		}
+		if len(accepted) > 0 {
+			if err := insertClientEvents(db, &req, accepted, now); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store events"})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"accepted": len(accepted), "rejected": rejected})
+	}
+}
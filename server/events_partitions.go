@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// eventMaxAge and eventMaxSkew bound the timestamps of accepted events,
+	// so that they always fall into a partition created in advance
+	eventMaxAge  = 7 * 24 * time.Hour
+	eventMaxSkew = time.Hour
+	// eventPartitionInterval is how often partitions are created and dropped
+	eventPartitionInterval = time.Hour
+	eventPartitionPrefix   = "client_events_"
+)
+
+// eventPartitionMonth returns the first instant of the UTC month of t.
+func eventPartitionMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func eventPartitionName(month time.Time) string {
+	return eventPartitionPrefix + month.Format("2006_01")
+}
+
+// ensureEventPartitions creates the monthly partitions of client_events that
+// accepted events may fall into at now, and the one of next month.
+func ensureEventPartitions(db *gorm.DB, now time.Time) error {
+	last := eventPartitionMonth(now).AddDate(0, 1, 0)
+	for month := eventPartitionMonth(now.Add(-eventMaxAge)); !month.After(last); month = month.AddDate(0, 1, 0) {
+		// Bounds cannot be bound parameters in DDL; they are formatted dates
+		if err := db.Exec(fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s PARTITION OF client_events FOR VALUES FROM ('%s') TO ('%s')",
+			eventPartitionName(month), month.Format(time.RFC3339), month.AddDate(0, 1, 0).Format(time.RFC3339),
+		)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventRetentionMonths reads EVENT_RETENTION_MONTHS; 0 keeps the events.
+func eventRetentionMonths() (int, error) {
+	raw := strings.TrimSpace(os.Getenv("EVENT_RETENTION_MONTHS"))
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid EVENT_RETENTION_MONTHS %q", raw)
+	}
+	return n, nil
+}
+
+// dropExpiredEventPartitions drops the monthly partitions of client_events
+// that ended more than months before the month of now, returning their names.
+func dropExpiredEventPartitions(db *gorm.DB, now time.Time, months int) ([]string, error) {
+	var names []string
+	if err := db.Raw(`SELECT c.relname
+FROM pg_inherits i
+JOIN pg_class c ON c.oid = i.inhrelid
+JOIN pg_class p ON p.oid = i.inhparent
+WHERE p.relname = 'client_events'
+ORDER BY c.relname`).Scan(&names).Error; err != nil {
+		return nil, err
+	}
+	keepFrom := eventPartitionMonth(now).AddDate(0, -months, 0)
+	var dropped []string
+	for _, name := range names {
+		// The default partition and foreign tables do not parse
+		month, err := time.Parse("2006_01", strings.TrimPrefix(name, eventPartitionPrefix))
+		if err != nil || !strings.HasPrefix(name, eventPartitionPrefix) {
+			continue
+		}
+		if month.AddDate(0, 1, 0).After(keepFrom) {
+			continue
+		}
+		if err := db.Exec("DROP TABLE IF EXISTS " + eventPartitionName(month)).Error; err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, name)
+	}
+	return dropped, nil
+}
+
+// maintainEventPartitions creates the upcoming partitions and drops the
+// expired ones.
+func maintainEventPartitions(db *gorm.DB, now time.Time, retentionMonths int) error {
+	if err := ensureEventPartitions(db, now); err != nil {
+		return fmt.Errorf("create event partitions: %w", err)
+	}
+	if retentionMonths == 0 {
+		return nil
+	}
+	dropped, err := dropExpiredEventPartitions(db, now, retentionMonths)
+	if len(dropped) > 0 {
+		log.Printf("dropped expired event partitions %s", strings.Join(dropped, ", "))
+	}
+	if err != nil {
+		return fmt.Errorf("drop event partitions: %w", err)
+	}
+	return nil
+}
+
+// startEventPartitions creates the partitions of client_events before the
+// first events arrive, then keeps them current in the background.
+func startEventPartitions(db *gorm.DB) error {
+	months, err := eventRetentionMonths()
+	if err != nil {
+		return err
+	}
+	if err := maintainEventPartitions(db, time.Now(), months); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(eventPartitionInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if err := maintainEventPartitions(db, now, months); err != nil {
+				log.Printf("event partitions: %v", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateEvent(t *testing.T) {
+	now := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name  string
+		event clientEvent
+		props string
+		err   string
+	}{
+		{"valid", clientEvent{Name: "search.aggregate", Timestamp: now, Properties: map[string]interface{}{"sites": float64(5), "results": float64(120)}}, `{"results":120,"sites":5}`, ""},
+		{"unknown event", clientEvent{Name: "page.view", Timestamp: now}, "", "未知事件"},
+		{"too old", clientEvent{Name: "downloader.add", Timestamp: now.Add(-8 * 24 * time.Hour), Properties: map[string]interface{}{"client": "qbittorrent"}}, "", "时间超出"},
+		{"in the future", clientEvent{Name: "downloader.add", Timestamp: now.Add(2 * time.Hour), Properties: map[string]interface{}{"client": "qbittorrent"}}, "", "时间超出"},
+		{"unknown property", clientEvent{Name: "downloader.add", Timestamp: now, Properties: map[string]interface{}{"client": "qbittorrent", "url": "https://example.com"}}, "", "未知属性 url"},
+		{"free text", clientEvent{Name: "downloader.add", Timestamp: now, Properties: map[string]interface{}{"client": "my-seedbox"}}, "", "不在允许范围内"},
+		{"fraction", clientEvent{Name: "search.aggregate", Timestamp: now, Properties: map[string]interface{}{"sites": 1.5}}, "", "应为整数"},
+		{"wrong type", clientEvent{Name: "site.search", Timestamp: now, Properties: map[string]interface{}{"site_type": "Gazelle", "success": "yes"}}, "", "应为布尔值"},
+		{"missing property", clientEvent{Name: "download.send", Timestamp: now, Properties: map[string]interface{}{"client": "transmission"}}, "", "缺少属性 success"},
+	} {
+		ev, err := validateEvent(tc.event, now)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		assert.JSONEq(t, tc.props, ev.Properties, tc.name)
+	}
+}
+
+func postEvents(t *testing.T, handler gin.HandlerFunc, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/events", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	handler(c)
+	return w
+}
+
+func TestClientEventsStoresValidEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	at := time.Now().UTC().Add(-time.Minute).Truncate(time.Second)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO client_events (name, device_id, platform, app_version, occurred_at, received_at, properties)
+VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb), ($8, $9, $10, $11, $12, $13, $14::jsonb)`)).
+		WithArgs(
+			"download.send", "device-1", "android", "2.30.0", at, sqlmock.AnyArg(), `{"client":"qbittorrent","success":true}`,
+			"site.search", "device-1", "android", "2.30.0", at, sqlmock.AnyArg(), `{"site_type":"NexusPHP","success":false}`,
+		).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"device_id":   "device-1",
+		"platform":    "android",
+		"app_version": "2.30.0",
+		"events": []map[string]interface{}{
+			{"name": "download.send", "timestamp": at, "properties": map[string]interface{}{"client": "qbittorrent", "success": true}},
+			{"name": "page.view", "timestamp": at},
+			{"name": "site.search", "timestamp": at, "properties": map[string]interface{}{"site_type": "NexusPHP", "success": false}},
+		},
+	})
+	w := postEvents(t, ClientEvents(db), body)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Accepted int             `json:"accepted"`
+		Rejected []rejectedEvent `json:"rejected"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Accepted)
+	require.Len(t, resp.Rejected, 1)
+	assert.Equal(t, 1, resp.Rejected[0].Index)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClientEventsRejectsOversizedBatches(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	events := make([]string, maxEventsPerBatch+1)
+	for i := range events {
+		events[i] = `{"name":"downloader.add","timestamp":"2024-05-10T08:00:00Z","properties":{"client":"rutorrent"}}`
+	}
+	batch := `{"device_id":"device-1","platform":"ios","app_version":"2.30.0","events":[` + strings.Join(events, ",") + `]}`
+	assert.Equal(t, http.StatusBadRequest, postEvents(t, ClientEvents(db), []byte(batch)).Code)
+
+	huge := `{"device_id":"device-1","platform":"ios","app_version":"2.30.0","events":[],"padding":"` + strings.Repeat("x", maxEventsBody) + `"}`
+	assert.Equal(t, http.StatusRequestEntityTooLarge, postEvents(t, ClientEvents(db), []byte(huge)).Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEnsureEventPartitions(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	// Events of the last week may still belong to April
+	now := time.Date(2024, 5, 3, 8, 0, 0, 0, time.UTC)
+	for _, p := range []struct{ name, from, to string }{
+		{"client_events_2024_04", "2024-04-01", "2024-05-01"},
+		{"client_events_2024_05", "2024-05-01", "2024-06-01"},
+		{"client_events_2024_06", "2024-06-01", "2024-07-01"},
+	} {
+		mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS ` + p.name + ` PARTITION OF client_events FOR VALUES FROM ('` + p.from + `T00:00:00Z') TO ('` + p.to + `T00:00:00Z')`)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	require.NoError(t, ensureEventPartitions(db, now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDropExpiredEventPartitions(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT c.relname
+FROM pg_inherits i`)).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).
+			AddRow("client_events_2024_01").
+			AddRow("client_events_2024_02").
+			AddRow("client_events_2024_03").
+			AddRow("client_events_default"))
+	mock.ExpectExec(regexp.QuoteMeta(`DROP TABLE IF EXISTS client_events_2024_01`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DROP TABLE IF EXISTS client_events_2024_02`)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Two months of retention in May keep March and later
+	dropped, err := dropExpiredEventPartitions(db, time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC), 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"client_events_2024_01", "client_events_2024_02"}, dropped)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultFeedLimit = 10
+	maxFeedLimit     = 50
+)
+
+// loadFeedReleases returns the published releases of a channel that are fully
+// rolled out on platform, newest first, with their assets attached. Public feeds
+// are the same for every device, so releases in a staged rollout are left out.
+func loadFeedReleases(db *gorm.DB, platform string, includeBeta bool) ([]AppVersion, error) {
+	versions, err := loadPlatformReleases(db, platform, includeBeta)
+	if err != nil {
+		return nil, err
+	}
+	now := nowUTC()
+	released := versions[:0]
+	for _, v := range versions {
+		if effectiveRolloutPercent(&v, now) >= 100 {
+			released = append(released, v)
+		}
+	}
+	if err := attachReleaseAssets(db, released); err != nil {
+		return nil, err
+	}
+	return released, nil
+}
+
+// feedItem is one release in a desktop update feed together with the asset
+// picked for the requested platform.
+type feedItem struct {
+	Version      string    `json:"version"`
+	BuildNumber  int       `json:"build_number,omitempty"`
+	PubDate      time.Time `json:"pub_date"`
+	ReleaseNotes string    `json:"release_notes"`
+	ReleaseURL   string    `json:"release_url,omitempty"`
+	Arch         string    `json:"arch,omitempty"`
+	Format       string    `json:"format"`
+	URL          string    `json:"url"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256,omitempty"`
+	EdSignature  string    `json:"ed_signature,omitempty"`
+	MinOSVersion string    `json:"min_os_version,omitempty"`
+}
+
+// feedRequest holds the parameters shared by the appcast and JSON feed.
+type feedRequest struct {
+	Platform string
+	Channel  string
+	Arch     string
+	Format   string
+	Limit    int
+}
+
+func parseFeedRequest(c *gin.Context) (feedRequest, bool) {
+	req := feedRequest{
+		Platform: normalizePlatform(c.Param("platform")),
+		Channel:  c.Param("channel"),
+		Arch:     c.Query("arch"),
+		Format:   c.Query("format"),
+		Limit:    defaultFeedLimit,
+	}
+	if !isKnownPlatform(req.Platform) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown platform " + c.Param("platform")})
+		return req, false
+	}
+	if req.Channel != channelStable && req.Channel != channelBeta {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel must be stable or beta"})
+		return req, false
+	}
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		req.Limit = l
+	}
+	if req.Limit > maxFeedLimit {
+		req.Limit = maxFeedLimit
+	}
+	return req, true
+}
+
+// buildFeedItems keeps the releases that have an asset for the request,
+// newest first, up to the request limit.
+func buildFeedItems(versions []AppVersion, req feedRequest) []feedItem {
+	items := []feedItem{}
+	for _, v := range versions {
+		if len(items) >= req.Limit {
+			break
+		}
+		a := selectReleaseAsset(v.Assets, req.Platform, req.Arch, req.Format)
+		if a == nil {
+			continue
+		}
+		items = append(items, feedItem{
+			Version:      v.Version,
+			BuildNumber:  v.BuildNumber,
+			PubDate:      v.CreatedAt.UTC(),
+			ReleaseNotes: v.ReleaseNotes,
+			ReleaseURL:   v.DownloadURL,
+			Arch:         a.Arch,
+			Format:       a.Format,
+			URL:          a.URL,
+			Size:         a.Size,
+			SHA256:       a.SHA256,
+			EdSignature:  a.EdSignature,
+			MinOSVersion: a.MinOSVersion,
+		})
+	}
+	return items
+}
+
+// feed is the content shared by the appcast and JSON feed.
+type feed struct {
+	req   feedRequest
+	items []feedItem
+	// floor is the minimum supported version of the channel, if any
+	floor *MinSupportedVersion
+	// floorBuild is the build number of the floor release, 0 when unknown
+	floorBuild int
+}
+
+func (s *VersionService) loadFeed(c *gin.Context) (feed, bool) {
+	req, ok := parseFeedRequest(c)
+	if !ok {
+		return feed{req: req}, false
+	}
+	versions, err := loadFeedReleases(s.db, req.Platform, req.Channel == channelBeta)
+	if err != nil {
+		log.Printf("Failed to load releases for %s feed: %v", req.Platform, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build feed"})
+		return feed{req: req}, false
+	}
+	rules, err := loadMinSupportedVersions(s.db)
+	if err != nil {
+		log.Printf("Failed to load minimum supported versions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build feed"})
+		return feed{req: req}, false
+	}
+	f := feed{req: req, items: buildFeedItems(versions, req), floor: resolveMinSupportedVersion(rules, req.Channel, req.Platform)}
+	if f.floor != nil {
+		f.floorBuild = releaseBuildNumber(versions, f.floor.MinVersion)
+	}
+	return f, true
+}
+
+// releaseBuildNumber returns the build number of version among versions, or 0.
+func releaseBuildNumber(versions []AppVersion, version string) int {
+	for _, v := range versions {
+		if compareVersionStrings(v.Version, version) == 0 {
+			return v.BuildNumber
+		}
+	}
+	return 0
+}
+
+// Sparkle appcast documents, see https://sparkle-project.org/documentation/publishing/
+type appcastRSS struct {
+	XMLName      xml.Name       `xml:"rss"`
+	Version      string         `xml:"version,attr"`
+	XMLNSSparkle string         `xml:"xmlns:sparkle,attr"`
+	XMLNSDC      string         `xml:"xmlns:dc,attr"`
+	Channel      appcastChannel `xml:"channel"`
+}
+
+type appcastChannel struct {
+	Title string        `xml:"title"`
+	Link  string        `xml:"link"`
+	Items []appcastItem `xml:"item"`
+}
+
+type appcastItem struct {
+	Title                string           `xml:"title"`
+	PubDate              string           `xml:"pubDate"`
+	Version              string           `xml:"sparkle:version"`
+	ShortVersionString   string           `xml:"sparkle:shortVersionString"`
+	MinimumSystemVersion string           `xml:"sparkle:minimumSystemVersion,omitempty"`
+	CriticalUpdate       *appcastCritical `xml:"sparkle:criticalUpdate,omitempty"`
+	FullReleaseNotesLink string           `xml:"sparkle:fullReleaseNotesLink,omitempty"`
+	Description          appcastCDATA     `xml:"description"`
+	Enclosure            appcastEnclosure `xml:"enclosure"`
+}
+
+type appcastCritical struct {
+	Version string `xml:"sparkle:version,attr"`
+}
+
+type appcastCDATA struct {
+	Text string `xml:",cdata"`
+}
+
+type appcastEnclosure struct {
+	URL         string `xml:"url,attr"`
+	Length      int64  `xml:"length,attr"`
+	Type        string `xml:"type,attr"`
+	OS          string `xml:"sparkle:os,attr,omitempty"`
+	EdSignature string `xml:"sparkle:edSignature,attr,omitempty"`
+}
+
+// sparkleOS maps our platform names onto the values of sparkle:os.
+var sparkleOS = map[string]string{"macos": "macos", "windows": "windows"}
+
+// buildAppcast renders the items of f. Sparkle compares sparkle:version with
+// the build number of the running app (CFBundleVersion) and only shows
+// sparkle:shortVersionString, so releases without a build number are left
+// out, and updates are only marked critical when the build number of the
+// floor release is known.
+func buildAppcast(title, link string, f feed) appcastRSS {
+	rss := appcastRSS{
+		Version:      "2.0",
+		XMLNSSparkle: "http://www.andymatuschak.org/xml-namespaces/sparkle",
+		XMLNSDC:      "http://purl.org/dc/elements/1.1/",
+		Channel:      appcastChannel{Title: title, Link: link, Items: []appcastItem{}},
+	}
+	for _, it := range f.items {
+		if it.BuildNumber <= 0 {
+			continue
+		}
+		item := appcastItem{
+			Title:                "Version " + it.Version,
+			PubDate:              it.PubDate.Format(time.RFC1123Z),
+			Version:              strconv.Itoa(it.BuildNumber),
+			ShortVersionString:   it.Version,
+			MinimumSystemVersion: it.MinOSVersion,
+			FullReleaseNotesLink: it.ReleaseURL,
+			Description:          appcastCDATA{Text: it.ReleaseNotes},
+			Enclosure: appcastEnclosure{
+				URL:         it.URL,
+				Length:      it.Size,
+				Type:        "application/octet-stream",
+				OS:          sparkleOS[f.req.Platform],
+				EdSignature: it.EdSignature,
+			},
+		}
+		// Updating from below the supported floor is critical
+		if f.floor != nil && f.floorBuild > 0 && compareVersionStrings(it.Version, f.floor.MinVersion) >= 0 {
+			item.CriticalUpdate = &appcastCritical{Version: strconv.Itoa(f.floorBuild)}
+		}
+		rss.Channel.Items = append(rss.Channel.Items, item)
+	}
+	return rss
+}
+
+// Appcast renders a Sparkle / WinSparkle compatible appcast.
+// GET /api/v1/appcast/:platform/:channel
+func (s *VersionService) Appcast(c *gin.Context) {
+	f, ok := s.loadFeed(c)
+	if !ok {
+		return
+	}
+	rss := buildAppcast("PT Mate ("+f.req.Channel+")", requestURL(c), f)
+	out, err := xml.MarshalIndent(rss, "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build feed"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", append([]byte(xml.Header), out...))
+}
+
+// UpdateFeed serves the same data as Appcast as JSON.
+// GET /api/v1/feed/:platform/:channel
+func (s *VersionService) UpdateFeed(c *gin.Context) {
+	f, ok := s.loadFeed(c)
+	if !ok {
+		return
+	}
+	resp := gin.H{
+		"platform": f.req.Platform,
+		"channel":  f.req.Channel,
+		"items":    f.items,
+	}
+	if f.floor != nil {
+		resp["min_version"] = f.floor.MinVersion
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, resp)
+}
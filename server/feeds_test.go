@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildFeedItems(t *testing.T) {
+	versions := []AppVersion{
+		{ID: 3, Version: "2.30.0", CreatedAt: time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC), Assets: []ReleaseAsset{
+			{Platform: "windows", Arch: "x86_64", Format: "exe", URL: "https://dl/2.30.0.exe", Size: 30},
+		}},
+		{ID: 2, Version: "2.29.0", BuildNumber: 190, ReleaseNotes: "notes", DownloadURL: "https://gh/v2.29.0", CreatedAt: time.Date(2026, 8, 1, 8, 0, 0, 0, time.UTC), Assets: []ReleaseAsset{
+			{Platform: "macos", Arch: "x86_64", Format: "app.zip", URL: "https://dl/2.29.0.app.zip", Size: 20},
+			{Platform: "macos", Arch: "x86_64", Format: "dmg", URL: "https://dl/2.29.0.dmg", Size: 21, EdSignature: "c2ln", MinOSVersion: "10.15"},
+		}},
+		{ID: 1, Version: "2.28.0", CreatedAt: time.Date(2026, 7, 1, 8, 0, 0, 0, time.UTC), Assets: []ReleaseAsset{
+			{Platform: "macos", Arch: "x86_64", Format: "dmg", URL: "https://dl/2.28.0.dmg", Size: 19},
+		}},
+	}
+
+	items := buildFeedItems(versions, feedRequest{Platform: "macos", Limit: 10})
+	require.Len(t, items, 2)
+	assert.Equal(t, feedItem{
+		Version:      "2.29.0",
+		BuildNumber:  190,
+		PubDate:      time.Date(2026, 8, 1, 8, 0, 0, 0, time.UTC),
+		ReleaseNotes: "notes",
+		ReleaseURL:   "https://gh/v2.29.0",
+		Arch:         "x86_64",
+		Format:       "dmg",
+		URL:          "https://dl/2.29.0.dmg",
+		Size:         21,
+		EdSignature:  "c2ln",
+		MinOSVersion: "10.15",
+	}, items[0])
+	assert.Equal(t, "2.28.0", items[1].Version)
+
+	items = buildFeedItems(versions, feedRequest{Platform: "macos", Format: "app.zip", Limit: 10})
+	require.Len(t, items, 1)
+	assert.Equal(t, "https://dl/2.29.0.app.zip", items[0].URL)
+
+	assert.Len(t, buildFeedItems(versions, feedRequest{Platform: "macos", Limit: 1}), 1)
+}
+
+func TestBuildAppcast(t *testing.T) {
+	items := []feedItem{
+		{Version: "2.30.0", BuildNumber: 195, PubDate: time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC), ReleaseNotes: "<b>new</b>", ReleaseURL: "https://gh/v2.30.0",
+			Format: "dmg", URL: "https://dl/2.30.0.dmg", Size: 1234, EdSignature: "c2ln", MinOSVersion: "10.15"},
+		{Version: "2.19.0", BuildNumber: 170, PubDate: time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC), Format: "dmg", URL: "https://dl/2.19.0.dmg", Size: 99},
+		// Without a build number Sparkle could not compare the release
+		{Version: "2.18.0", PubDate: time.Date(2025, 12, 1, 8, 0, 0, 0, time.UTC), Format: "dmg", URL: "https://dl/2.18.0.dmg", Size: 98},
+	}
+	f := feed{req: feedRequest{Platform: "macos"}, items: items, floor: &MinSupportedVersion{Channel: "stable", MinVersion: "2.20.0"}, floorBuild: 180}
+
+	out, err := xml.MarshalIndent(buildAppcast("PT Mate (stable)", "https://u/appcast", f), "", "  ")
+	require.NoError(t, err)
+	doc := string(out)
+
+	assert.Contains(t, doc, `<rss version="2.0" xmlns:sparkle="http://www.andymatuschak.org/xml-namespaces/sparkle" xmlns:dc="http://purl.org/dc/elements/1.1/">`)
+	assert.Contains(t, doc, `<pubDate>Tue, 01 Sep 2026 08:00:00 +0000</pubDate>`)
+	assert.Contains(t, doc, `<sparkle:version>195</sparkle:version>`)
+	assert.Contains(t, doc, `<sparkle:shortVersionString>2.30.0</sparkle:shortVersionString>`)
+	assert.Contains(t, doc, `<sparkle:minimumSystemVersion>10.15</sparkle:minimumSystemVersion>`)
+	assert.Contains(t, doc, `<sparkle:criticalUpdate sparkle:version="180"></sparkle:criticalUpdate>`)
+	assert.Contains(t, doc, `<description><![CDATA[<b>new</b>]]></description>`)
+	assert.Contains(t, doc, `<enclosure url="https://dl/2.30.0.dmg" length="1234" type="application/octet-stream" sparkle:os="macos" sparkle:edSignature="c2ln"></enclosure>`)
+	// Releases older than the floor do not lift it
+	assert.Equal(t, 1, strings.Count(doc, "sparkle:criticalUpdate "))
+	assert.Contains(t, doc, `<enclosure url="https://dl/2.19.0.dmg" length="99" type="application/octet-stream" sparkle:os="macos"></enclosure>`)
+	assert.NotContains(t, doc, "2.18.0")
+
+	// Without the build number of the floor release nothing is marked critical
+	f.floorBuild = 0
+	out, err = xml.MarshalIndent(buildAppcast("PT Mate (stable)", "https://u/appcast", f), "", "  ")
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "sparkle:criticalUpdate")
+}
+
+func TestReleaseBuildNumber(t *testing.T) {
+	versions := []AppVersion{{Version: "2.30.0", BuildNumber: 195}, {Version: "2.20.0", BuildNumber: 180}}
+	assert.Equal(t, 180, releaseBuildNumber(versions, "2.20.0"))
+	assert.Equal(t, 0, releaseBuildNumber(versions, "2.21.0"))
+}
+
+func TestParseFeedRequestRejectsUnknownChannel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/appcast/macos/nightly?limit=500", nil)
+	c.Params = gin.Params{{Key: "platform", Value: "macOS"}, {Key: "channel", Value: "nightly"}}
+
+	_, ok := parseFeedRequest(c)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	c.Params[1].Value = "beta"
+	req, ok := parseFeedRequest(c)
+	require.True(t, ok)
+	assert.Equal(t, feedRequest{Platform: "macos", Channel: "beta", Limit: maxFeedLimit}, req)
+}
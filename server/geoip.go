@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoLocation is where a client IP is located. Country is an ISO 3166-1
+// alpha-2 code and Region the ISO code, or else the English name, of the
+// largest subdivision; either is empty when the database does not know it.
+type geoLocation struct {
+	Country string
+	Region  string
+}
+
+// geoLocator resolves client IPs to locations offline.
+type geoLocator interface {
+	Locate(ip net.IP) (geoLocation, error)
+}
+
+// geoIPLocator enriches devices with the location of their IP at ingest; it
+// is nil when GEOIP_DB is not set.
+var geoIPLocator geoLocator
+
+// mmdbLocator reads a MaxMind GeoIP2 / GeoLite2 or DB-IP Country or City
+// database. The file is memory mapped and never leaves the host.
+type mmdbLocator struct {
+	reader *maxminddb.Reader
+}
+
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	Subdivisions []struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+// loadGeoIPLocator opens the .mmdb file at GEOIP_DB, or returns nil when the
+// variable is empty.
+func loadGeoIPLocator() (geoLocator, error) {
+	path := strings.TrimSpace(os.Getenv("GEOIP_DB"))
+	if path == "" {
+		return nil, nil
+	}
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open GEOIP_DB %s: %v", path, err)
+	}
+	return &mmdbLocator{reader: reader}, nil
+}
+
+func (l *mmdbLocator) Locate(ip net.IP) (geoLocation, error) {
+	var rec mmdbRecord
+	if err := l.reader.Lookup(ip, &rec); err != nil {
+		return geoLocation{}, err
+	}
+	loc := geoLocation{Country: rec.Country.ISOCode}
+	if loc.Country == "" {
+		loc.Country = rec.RegisteredCountry.ISOCode
+	}
+	if len(rec.Subdivisions) > 0 {
+		loc.Region = rec.Subdivisions[0].ISOCode
+		if loc.Region == "" {
+			loc.Region = rec.Subdivisions[0].Names["en"]
+		}
+	}
+	return normalizeGeoLocation(loc), nil
+}
+
+// normalizeGeoLocation makes a location fit the country and region columns.
+func normalizeGeoLocation(loc geoLocation) geoLocation {
+	loc.Country = strings.ToUpper(loc.Country)
+	if len(loc.Country) != 2 {
+		loc.Country = ""
+	}
+	// Region names are localized, so they are cut on a character boundary
+	loc.Region = truncateHead(loc.Region, 100)
+	return loc
+}
+
+// locateClientIP returns the location of the raw client IP, before it is
+// anonymized, or nil when no GeoIP database is configured. Unknown and
+// unparsable addresses yield an empty location.
+func locateClientIP(ip string) *geoLocation {
+	if geoIPLocator == nil {
+		return nil
+	}
+	var loc geoLocation
+	if addr := net.ParseIP(strings.TrimSpace(ip)); addr != nil {
+		// Lookup errors mean a corrupt record; the device is left unlocated
+		loc, _ = geoIPLocator.Locate(addr)
+	}
+	return &loc
+}
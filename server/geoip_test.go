@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLocator locates the IPs it knows.
+type fakeLocator map[string]geoLocation
+
+func (f fakeLocator) Locate(ip net.IP) (geoLocation, error) {
+	return f[ip.String()], nil
+}
+
+func withGeoIPLocator(t *testing.T, l geoLocator) {
+	t.Helper()
+	prev := geoIPLocator
+	geoIPLocator = l
+	t.Cleanup(func() { geoIPLocator = prev })
+}
+
+func TestLocateClientIP(t *testing.T) {
+	assert.Nil(t, locateClientIP("203.0.113.57"))
+
+	withGeoIPLocator(t, fakeLocator{"203.0.113.57": {Country: "JP", Region: "13"}})
+	assert.Equal(t, &geoLocation{Country: "JP", Region: "13"}, locateClientIP("203.0.113.57"))
+	assert.Equal(t, &geoLocation{}, locateClientIP("198.51.100.1"))
+	assert.Equal(t, &geoLocation{}, locateClientIP("not-an-ip"))
+}
+
+func TestNormalizeGeoLocation(t *testing.T) {
+	assert.Equal(t, geoLocation{Country: "CN", Region: "GD"}, normalizeGeoLocation(geoLocation{Country: "cn", Region: "GD"}))
+	// Pseudo codes such as the EU of some databases do not fit
+	assert.Equal(t, geoLocation{}, normalizeGeoLocation(geoLocation{Country: "EUR"}))
+	// Long names are cut without splitting a character
+	region := normalizeGeoLocation(geoLocation{Country: "CN", Region: "x" + strings.Repeat("广东", 40)}).Region
+	assert.True(t, utf8.ValidString(region), region)
+	assert.Equal(t, "x"+strings.Repeat("广东", 16)+"广", region)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// githubReleaseEvent is the subset of GitHub's native `release` webhook payload we use.
+type githubReleaseEvent struct {
+	Action  string        `json:"action"`
+	Release githubRelease `json:"release"`
+}
+
+type githubRelease struct {
+	TagName    string               `json:"tag_name"`
+	Body       string               `json:"body"`
+	HTMLURL    string               `json:"html_url"`
+	Draft      bool                 `json:"draft"`
+	Prerelease bool                 `json:"prerelease"`
+	Assets     []githubReleaseAsset `json:"assets"`
+}
+
+type githubReleaseAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+	Size               int64  `json:"size"`
+	// Digest is "sha256:<hex>" for assets uploaded since GitHub started computing them
+	Digest string `json:"digest"`
+}
+
+// githubReleaseWebhookSecret returns the secret configured on the GitHub webhook,
+// falling back to the one shared with the release workflow.
+func githubReleaseWebhookSecret() string {
+	if s := os.Getenv("GITHUB_RELEASE_WEBHOOK_SECRET"); s != "" {
+		return s
+	}
+	return os.Getenv("GITHUB_WEBHOOK_SECRET")
+}
+
+// verifyGitHubSignature checks an X-Hub-Signature-256 header ("sha256=<hex>")
+// against the HMAC-SHA256 of the raw body, in constant time.
+func verifyGitHubSignature(secret string, body []byte, header string) bool {
+	if secret == "" || !strings.HasPrefix(header, "sha256=") {
+		return false
+	}
+	provided, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(provided, mac.Sum(nil))
+}
+
+// releaseMetadataMarker carries what GitHub does not know about a release in
+// its body: "<!-- build-number:<n> -->" and, per asset, the Sparkle signature
+// "<!-- ed-signature:<asset name> <signature> -->".
+var releaseMetadataMarker = regexp.MustCompile(`(?m)^[ \t]*<!--\s*(build-number|ed-signature):[ \t]*([^\r\n]*?)\s*-->[ \t]*(?:\r?\n|$)`)
+
+// githubReleaseMetadata is read from the markers of releaseMetadataMarker.
+type githubReleaseMetadata struct {
+	// BuildNumber is nil without a valid build-number marker
+	BuildNumber *int
+	// EdSignatures maps asset names to their valid signatures
+	EdSignatures map[string]string
+}
+
+// splitReleaseMetadata removes the metadata markers from a release body and
+// returns what they carry. Invalid markers are dropped.
+func splitReleaseMetadata(body string) (string, githubReleaseMetadata) {
+	meta := githubReleaseMetadata{EdSignatures: make(map[string]string)}
+	matches := releaseMetadataMarker.FindAllStringSubmatch(body, -1)
+	if len(matches) == 0 {
+		return body, meta
+	}
+	for _, m := range matches {
+		switch m[1] {
+		case "build-number":
+			if n, err := strconv.Atoi(m[2]); err == nil && n >= 0 {
+				meta.BuildNumber = &n
+			}
+		case "ed-signature":
+			if f := strings.Fields(m[2]); len(f) == 2 && isEdSignature(f[1]) {
+				meta.EdSignatures[f[0]] = f[1]
+			}
+		}
+	}
+	return strings.TrimSpace(releaseMetadataMarker.ReplaceAllString(body, "")), meta
+}
+
+// releaseFromGitHub maps a GitHub release onto the fields we store. Platforms
+// and assets are derived from the attached files; a release without
+// recognizable assets targets every platform. Translated notes follow
+// "<!-- release-notes:<locale> -->" markers in the release body, and the build
+// number and asset signatures the markers of releaseMetadataMarker.
+func releaseFromGitHub(r githubRelease) releaseUpsert {
+	published := !r.Draft
+	body, meta := splitReleaseMetadata(r.Body)
+	notes, translations := splitReleaseNotes(body)
+	rel := releaseUpsert{
+		Version:          strings.TrimPrefix(r.TagName, "v"),
+		BuildNumber:      meta.BuildNumber,
+		ReleaseNotes:     notes,
+		ReleaseNotesI18n: translations,
+		DownloadURL:      r.HTMLURL,
+		IsBeta:           r.Prerelease,
+		IsPublished:      &published,
+	}
+
+	var platformNames []string
+	assets := []ReleaseAsset{}
+	seen := make(map[string]bool)
+	for _, a := range r.Assets {
+		platform, arch, format := classifyReleaseAsset(a.Name)
+		if platform == "" {
+			continue
+		}
+		platformNames = append(platformNames, platform)
+		if key := platform + "/" + arch + "/" + format; !seen[key] {
+			seen[key] = true
+			assets = append(assets, ReleaseAsset{
+				Platform:    platform,
+				Arch:        arch,
+				Format:      format,
+				Name:        a.Name,
+				URL:         a.BrowserDownloadURL,
+				Size:        a.Size,
+				SHA256:      githubAssetSHA256(a.Digest),
+				EdSignature: meta.EdSignatures[a.Name],
+			})
+		}
+	}
+	if legacy := selectReleaseAsset(assets, "android", archARM64, "apk"); legacy != nil {
+		// Older clients only read android_download_url
+		rel.AndroidDownloadURL = legacy.URL
+	}
+	platforms, _ := parsePlatformSet(platformNames)
+	rel.Platforms = &platforms
+	rel.Assets = &assets
+	return rel
+}
+
+// githubAssetSHA256 extracts the hex SHA-256 from an asset digest, if present.
+func githubAssetSHA256(digest string) string {
+	sum := strings.ToLower(strings.TrimPrefix(digest, "sha256:"))
+	if !sha256Pattern.MatchString(sum) {
+		return ""
+	}
+	return sum
+}
+
+// GitHubRelease handles GitHub's native `release` webhook event.
+// POST /api/v1/github/release
+func (s *VersionService) GitHubRelease(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	if !verifyGitHubSignature(githubReleaseWebhookSecret(), body, c.GetHeader("X-Hub-Signature-256")) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized: invalid signature"})
+		return
+	}
+
+	switch event := c.GetHeader("X-GitHub-Event"); event {
+	case "ping":
+		c.JSON(http.StatusOK, gin.H{"message": "pong"})
+		return
+	case "release":
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "Event ignored", "event": event})
+		return
+	}
+
+	var payload githubReleaseEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rel := releaseFromGitHub(payload.Release)
+	if rel.Version == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "release tag_name is required"})
+		return
+	}
+
+	switch payload.Action {
+	case "published", "released", "prereleased", "created", "edited":
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			return upsertRelease(tx, rel)
+		})
+	case "unpublished":
+		err = s.db.Model(&AppVersion{}).Where("version = ?", rel.Version).
+			Updates(map[string]interface{}{"is_published": false, "updated_at": nowUTC()}).Error
+	case "deleted":
+		err = s.db.Where("version = ?", rel.Version).Delete(&AppVersion{}).Error
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "Action ignored", "action": payload.Action})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to apply GitHub release %s %s: %v", payload.Action, rel.Version, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update version"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Release " + payload.Action,
+		"version": rel.Version,
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signGitHubPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyGitHubSignature(t *testing.T) {
+	body := []byte(`{"action":"published"}`)
+	valid := signGitHubPayload("s3cret", body)
+
+	assert.True(t, verifyGitHubSignature("s3cret", body, valid))
+	assert.False(t, verifyGitHubSignature("other", body, valid))
+	assert.False(t, verifyGitHubSignature("s3cret", []byte(`{"action":"deleted"}`), valid))
+	assert.False(t, verifyGitHubSignature("s3cret", body, "sha1=abc"))
+	assert.False(t, verifyGitHubSignature("s3cret", body, "sha256=not-hex"))
+	assert.False(t, verifyGitHubSignature("", body, signGitHubPayload("", body)))
+}
+
+func TestReleaseFromGitHub(t *testing.T) {
+	rel := releaseFromGitHub(githubRelease{
+		TagName:    "v2.29.0-beta.1",
+		Body:       "notes",
+		HTMLURL:    "https://github.com/o/r/releases/tag/v2.29.0-beta.1",
+		Draft:      true,
+		Prerelease: true,
+		Assets: []githubReleaseAsset{
+			{Name: "pt_mate-2.29.0-beta.1-armeabi-v7a.apk", BrowserDownloadURL: "https://dl/v7a.apk"},
+			{Name: "pt_mate-2.29.0-beta.1-arm64-v8a.apk", BrowserDownloadURL: "https://dl/arm64.apk", Size: 42, Digest: "sha256:" + strings.Repeat("AB", 32)},
+			{Name: "pt_mate-2.29.0-beta.1-windows-x64.exe", BrowserDownloadURL: "https://dl/setup.exe"},
+			{Name: "pt_mate-2.29.0-beta.1-unsigned.ipa", BrowserDownloadURL: "https://dl/app.ipa"},
+			{Name: "pt_mate-2.29.0-beta.1.xcarchive.zip", BrowserDownloadURL: "https://dl/archive.zip"},
+		},
+	})
+
+	assert.Equal(t, "2.29.0-beta.1", rel.Version)
+	assert.True(t, rel.IsBeta)
+	require.NotNil(t, rel.IsPublished)
+	assert.False(t, *rel.IsPublished)
+	assert.Equal(t, "https://dl/arm64.apk", rel.AndroidDownloadURL)
+	require.NotNil(t, rel.Platforms)
+	assert.Equal(t, PlatformSet{"android", "ios", "windows"}, *rel.Platforms)
+	require.NotNil(t, rel.Assets)
+	assert.Equal(t, []ReleaseAsset{
+		{Platform: "android", Arch: "arm", Format: "apk", Name: "pt_mate-2.29.0-beta.1-armeabi-v7a.apk", URL: "https://dl/v7a.apk"},
+		{Platform: "android", Arch: "arm64", Format: "apk", Name: "pt_mate-2.29.0-beta.1-arm64-v8a.apk", URL: "https://dl/arm64.apk", Size: 42, SHA256: strings.Repeat("ab", 32)},
+		{Platform: "windows", Arch: "x86_64", Format: "exe", Name: "pt_mate-2.29.0-beta.1-windows-x64.exe", URL: "https://dl/setup.exe"},
+		{Platform: "ios", Format: "ipa", Name: "pt_mate-2.29.0-beta.1-unsigned.ipa", URL: "https://dl/app.ipa"},
+	}, *rel.Assets)
+}
+
+func TestReleaseFromGitHubReadsMetadataMarkers(t *testing.T) {
+	sig := base64.StdEncoding.EncodeToString(make([]byte, 64))
+	rel := releaseFromGitHub(githubRelease{
+		TagName: "v2.30.0",
+		Body: "notes\n<!-- build-number: 195 -->\n<!-- ed-signature: pt_mate-2.30.0-macos-x64.dmg " + sig + " -->\n" +
+			"<!-- ed-signature: pt_mate-2.30.0-windows-x64.exe not-a-signature -->\n",
+		Assets: []githubReleaseAsset{
+			{Name: "pt_mate-2.30.0-macos-x64.dmg", BrowserDownloadURL: "https://dl/app.dmg"},
+			{Name: "pt_mate-2.30.0-windows-x64.exe", BrowserDownloadURL: "https://dl/setup.exe"},
+		},
+	})
+
+	assert.Equal(t, "notes", rel.ReleaseNotes)
+	require.NotNil(t, rel.BuildNumber)
+	assert.Equal(t, 195, *rel.BuildNumber)
+	require.NotNil(t, rel.Assets)
+	require.Len(t, *rel.Assets, 2)
+	assert.Equal(t, sig, (*rel.Assets)[0].EdSignature)
+	// Invalid signatures are dropped
+	assert.Empty(t, (*rel.Assets)[1].EdSignature)
+
+	// Without a marker the stored build number is kept
+	assert.Nil(t, releaseFromGitHub(githubRelease{TagName: "v2.30.0", Body: "notes"}).BuildNumber)
+}
+
+func TestGitHubReleaseRejectsInvalidSignature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("GITHUB_RELEASE_WEBHOOK_SECRET", "s3cret")
+
+	body := []byte(`{"action":"published","release":{"tag_name":"v1.0.0"}}`)
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/github/release", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("X-Hub-Signature-256", signGitHubPayload("wrong", body))
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	NewVersionService(nil).GitHubRelease(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestGitHubReleaseDeletedSoftDeletesVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("GITHUB_RELEASE_WEBHOOK_SECRET", "s3cret")
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "app_versions" SET "deleted_at"=\$1 WHERE version = \$2 AND "app_versions"."deleted_at" IS NULL`).
+		WithArgs(sqlmock.AnyArg(), "1.0.0").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body := []byte(`{"action":"deleted","release":{"tag_name":"v1.0.0"}}`)
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/github/release", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("X-Hub-Signature-256", signGitHubPayload("s3cret", body))
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	NewVersionService(db).GitHubRelease(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultIngestQueueSize     = 10000
+	defaultIngestBatchSize     = 500
+	defaultIngestFlushInterval = time.Second
+	// maxIngestBatchSize keeps a batch statement below the 65535 parameters
+	// Postgres accepts
+	maxIngestBatchSize = 5000
+	// ingestEnqueueTimeout is how long a request waits for room in a full
+	// queue before its event is dropped
+	ingestEnqueueTimeout = 100 * time.Millisecond
+	// ingestMaxRetries is how often a failed batch is retried, backing off
+	// from ingestRetryBackoff
+	ingestMaxRetries   = 3
+	ingestRetryBackoff = 200 * time.Millisecond
+)
+
+// ingestEvent is an update check to record in app_statistics and app_activity.
+type ingestEvent struct {
+	DeviceID   string
+	Platform   string
+	AppVersion string
+	// IP is already anonymized under IP_POLICY
+	IP string
+	// Geo is nil without a GeoIP database
+	Geo *geoLocation
+	// Sites is nil when the app did not report its sites
+	Sites []ReportedSite
+	// SecretHash is the deviceSecretHash of the device secret sent with the
+	// check, if any
+	SecretHash string
+	At         time.Time
+}
+
+// ingestConfig sizes the ingestion queue.
+type ingestConfig struct {
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// ingestConfigFromEnv reads INGEST_QUEUE_SIZE, INGEST_BATCH_SIZE and
+// INGEST_FLUSH_INTERVAL (a Go duration).
+func ingestConfigFromEnv() (ingestConfig, error) {
+	cfg := ingestConfig{
+		QueueSize:     defaultIngestQueueSize,
+		BatchSize:     defaultIngestBatchSize,
+		FlushInterval: defaultIngestFlushInterval,
+	}
+	if raw := os.Getenv("INGEST_QUEUE_SIZE"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid INGEST_QUEUE_SIZE %q", raw)
+		}
+		cfg.QueueSize = n
+	}
+	if raw := os.Getenv("INGEST_BATCH_SIZE"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxIngestBatchSize {
+			return cfg, fmt.Errorf("invalid INGEST_BATCH_SIZE %q, want 1-%d", raw, maxIngestBatchSize)
+		}
+		cfg.BatchSize = n
+	}
+	if raw := os.Getenv("INGEST_FLUSH_INTERVAL"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid INGEST_FLUSH_INTERVAL %q", raw)
+		}
+		cfg.FlushInterval = d
+	}
+	return cfg, nil
+}
+
+// ingestQueue records update checks in the background. Events wait in a
+// bounded queue and a single writer stores them in batches, so a burst of
+// launches uses one database connection instead of one per request. When the
+// queue is full, requests wait briefly and then drop their event.
+type ingestQueue struct {
+	db     *gorm.DB
+	cfg    ingestConfig
+	events chan ingestEvent
+	done   chan struct{}
+
+	// mu guards closed against enqueueing on the closed channel
+	mu     sync.RWMutex
+	closed bool
+
+	enqueued atomic.Int64
+	dropped  atomic.Int64
+	written  atomic.Int64
+	failed   atomic.Int64
+	retries  atomic.Int64
+	batches  atomic.Int64
+}
+
+func newIngestQueue(db *gorm.DB, cfg ingestConfig) *ingestQueue {
+	return &ingestQueue{
+		db:     db,
+		cfg:    cfg,
+		events: make(chan ingestEvent, cfg.QueueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start runs the writer until the queue is closed.
+func (q *ingestQueue) Start() {
+	go q.run()
+}
+
+// Enqueue queues ev, waiting up to ingestEnqueueTimeout while the queue is
+// full. It reports false when the event was dropped.
+func (q *ingestQueue) Enqueue(ev ingestEvent) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		q.dropped.Add(1)
+		return false
+	}
+	select {
+	case q.events <- ev:
+		q.enqueued.Add(1)
+		return true
+	default:
+	}
+	timer := time.NewTimer(ingestEnqueueTimeout)
+	defer timer.Stop()
+	select {
+	case q.events <- ev:
+		q.enqueued.Add(1)
+		return true
+	case <-timer.C:
+		q.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting events and waits until the queued ones are written
+// or ctx is done.
+func (q *ingestQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("ingestion queue not flushed, %d events left: %v", len(q.events), ctx.Err())
+	}
+}
+
+func (q *ingestQueue) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.cfg.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]ingestEvent, 0, q.cfg.BatchSize)
+	for {
+		select {
+		case ev, ok := <-q.events:
+			if !ok {
+				q.flush(batch)
+				return
+			}
+			batch = append(batch, ev)
+			if len(batch) >= q.cfg.BatchSize {
+				q.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			q.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush writes batch, retrying with exponential backoff. A batch that still
+// fails is split so that one bad event only loses its own write.
+func (q *ingestQueue) flush(batch []ingestEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	for attempt := 0; ; attempt++ {
+		err := writeIngestBatch(q.db, batch)
+		if err == nil {
+			q.batches.Add(1)
+			q.written.Add(int64(len(batch)))
+			return
+		}
+		if attempt == ingestMaxRetries {
+			log.Printf("Failed to record %d analytics events, splitting the batch: %v", len(batch), err)
+			q.split(batch)
+			return
+		}
+		q.retries.Add(1)
+		time.Sleep(ingestRetryBackoff << attempt)
+	}
+}
+
+// split writes the halves of a batch that failed its retries, without
+// retrying, down to the single events that fail on their own.
+func (q *ingestQueue) split(batch []ingestEvent) {
+	err := writeIngestBatch(q.db, batch)
+	if err == nil {
+		q.batches.Add(1)
+		q.written.Add(int64(len(batch)))
+		return
+	}
+	if len(batch) == 1 {
+		q.failed.Add(1)
+		log.Printf("Failed to record analytics event of device %q, dropping it: %v", batch[0].DeviceID, err)
+		return
+	}
+	q.split(batch[:len(batch)/2])
+	q.split(batch[len(batch)/2:])
+}
+
+// ingestMetrics is a snapshot of the queue counters.
+type ingestMetrics struct {
+	QueueDepth    int   `json:"queue_depth"`
+	QueueCapacity int   `json:"queue_capacity"`
+	Enqueued      int64 `json:"enqueued"`
+	Dropped       int64 `json:"dropped"`
+	Written       int64 `json:"written"`
+	Failed        int64 `json:"failed"`
+	Retries       int64 `json:"retries"`
+	Batches       int64 `json:"batches"`
+}
+
+func (q *ingestQueue) Metrics() ingestMetrics {
+	return ingestMetrics{
+		QueueDepth:    len(q.events),
+		QueueCapacity: cap(q.events),
+		Enqueued:      q.enqueued.Load(),
+		Dropped:       q.dropped.Load(),
+		Written:       q.written.Load(),
+		Failed:        q.failed.Load(),
+		Retries:       q.retries.Load(),
+		Batches:       q.batches.Load(),
+	}
+}
+
+// GET /api/v1/admin/ingest
+func AdminIngestMetrics(q *ingestQueue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, q.Metrics())
+	}
+}
+
+// deviceCheckin sums the events of one device in a batch.
+type deviceCheckin struct {
+	last      ingestEvent
+	firstSeen time.Time
+	launches  int
+	// secret is the earliest event of the device carrying a secret, if any
+	secret *ingestEvent
+}
+
+// writeIngestBatch upserts the devices of batch into app_statistics, their
+// days into app_activity, their latest sites into device_sites and the secrets
+// of devices registering one into device_tokens, in one transaction. Events received before their device was erased are skipped.
+func writeIngestBatch(db *gorm.DB, batch []ingestEvent) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		batch, err := dropErasedEvents(tx, batch)
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		return writeCheckins(tx, batch)
+	})
+}
+
+// dropErasedEvents returns the events of batch received after the latest
+// erasure of their device, if any.
+func dropErasedEvents(tx *gorm.DB, batch []ingestEvent) ([]ingestEvent, error) {
+	keys := make([]string, len(batch))
+	unique := make(map[string]bool, len(batch))
+	var lookup []string
+	for i, ev := range batch {
+		keys[i] = erasedDeviceKey(ev.DeviceID)
+		if !unique[keys[i]] {
+			unique[keys[i]] = true
+			lookup = append(lookup, keys[i])
+		}
+	}
+	var rows []struct {
+		DeviceHash string
+		ErasedAt   time.Time
+	}
+	if err := tx.Raw("SELECT device_hash, erased_at FROM erased_devices WHERE device_hash IN ?", lookup).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return batch, nil
+	}
+	erased := make(map[string]time.Time, len(rows))
+	for _, r := range rows {
+		erased[r.DeviceHash] = r.ErasedAt
+	}
+	kept := make([]ingestEvent, 0, len(batch))
+	for i, ev := range batch {
+		if at, ok := erased[keys[i]]; ok && !ev.At.After(at) {
+			continue
+		}
+		kept = append(kept, ev)
+	}
+	return kept, nil
+}
+
+// writeCheckins writes the events of batch within tx.
+func writeCheckins(tx *gorm.DB, batch []ingestEvent) error {
+	// A statement may not update the same device twice, so events are merged
+	// per device; the latest one wins. Concurrent requests may queue their
+	// events slightly out of order
+	var devices []*deviceCheckin
+	checkins := make(map[string]*deviceCheckin)
+	type activityKey struct {
+		deviceID string
+		day      string
+	}
+	days := make(map[activityKey]int)
+	var activity []ingestEvent
+	sites := make(map[string]*siteReport)
+	var reports []*siteReport
+	for _, ev := range batch {
+		ci, ok := checkins[ev.DeviceID]
+		if !ok {
+			ci = &deviceCheckin{last: ev, firstSeen: ev.At}
+			checkins[ev.DeviceID] = ci
+			devices = append(devices, ci)
+		}
+		if !ev.At.Before(ci.last.At) {
+			ci.last = ev
+		}
+		if ev.At.Before(ci.firstSeen) {
+			ci.firstSeen = ev.At
+		}
+		ci.launches++
+		if ev.SecretHash != "" && (ci.secret == nil || ev.At.Before(ci.secret.At)) {
+			secret := ev
+			ci.secret = &secret
+		}
+
+		// The first check of a device on a day is its activity
+		key := activityKey{ev.DeviceID, analyticsZone.Day(ev.At).Format("2006-01-02")}
+		if i, ok := days[key]; !ok {
+			days[key] = len(activity)
+			activity = append(activity, ev)
+		} else if ev.At.Before(activity[i].At) {
+			activity[i] = ev
+		}
+
+		if ev.Sites != nil {
+			if r, ok := sites[ev.DeviceID]; !ok {
+				r = &siteReport{deviceID: ev.DeviceID, sites: ev.Sites, at: ev.At}
+				sites[ev.DeviceID] = r
+				reports = append(reports, r)
+			} else if !ev.At.Before(r.at) {
+				r.sites, r.at = ev.Sites, ev.At
+			}
+		}
+	}
+	var located, unlocated []*deviceCheckin
+	for _, ci := range devices {
+		if ci.last.Geo != nil {
+			located = append(located, ci)
+		} else {
+			unlocated = append(unlocated, ci)
+		}
+	}
+
+	if err := upsertStatistics(tx, located, true); err != nil {
+		return err
+	}
+	if err := upsertStatistics(tx, unlocated, false); err != nil {
+		return err
+	}
+	if err := insertActivity(tx, activity); err != nil {
+		return err
+	}
+	if err := replaceDeviceSites(tx, reports); err != nil {
+		return err
+	}
+	return registerDeviceSecrets(tx, devices)
+}
+
+// upsertStatistics inserts or updates the app_statistics rows of checkins
+// in one statement, so concurrent writers never lose launches or race to
+// create a device. Rows may arrive out of order, e.g. a retried batch or
+// another server instance: the attributes of the latest check win, and the
+// earliest first_seen is kept. Country and region are kept as they are unless
+// located. Rows are written in device_id order, so that writers whose batches
+// share devices lock them in the same order instead of deadlocking.
+func upsertStatistics(tx *gorm.DB, checkins []*deviceCheckin, located bool) error {
+	if len(checkins) == 0 {
+		return nil
+	}
+	sort.Slice(checkins, func(i, j int) bool { return checkins[i].last.DeviceID < checkins[j].last.DeviceID })
+	columns := []string{"device_id", "platform", "app_version", "ip", "first_seen", "last_seen", "total_launches"}
+	latest := []string{"platform", "app_version", "ip"}
+	if located {
+		columns = append(columns, "country", "region")
+		latest = append(latest, "country", "region")
+	}
+
+	set := make([]string, 0, len(latest)+4)
+	for _, col := range latest {
+		set = append(set, col+" = CASE WHEN EXCLUDED.last_seen >= app_statistics.last_seen THEN EXCLUDED."+col+" ELSE app_statistics."+col+" END")
+	}
+	set = append(set,
+		"first_seen = LEAST(app_statistics.first_seen, EXCLUDED.first_seen)",
+		"last_seen = GREATEST(app_statistics.last_seen, EXCLUDED.last_seen)",
+		"total_launches = app_statistics.total_launches + EXCLUDED.total_launches",
+		"dormant_at = NULL",
+	)
+
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	rows := make([]string, 0, len(checkins))
+	args := make([]interface{}, 0, len(checkins)*len(columns))
+	for _, ci := range checkins {
+		ev := ci.last
+		rows = append(rows, row)
+		args = append(args, ev.DeviceID, ev.Platform, ev.AppVersion, ev.IP, ci.firstSeen, ev.At, ci.launches)
+		if located {
+			args = append(args, ev.Geo.Country, ev.Geo.Region)
+		}
+	}
+	return tx.Exec(`INSERT INTO app_statistics (`+strings.Join(columns, ", ")+`)
+VALUES `+strings.Join(rows, ", ")+`
+ON CONFLICT (device_id) DO UPDATE
+SET `+strings.Join(set, ",\n    "), args...).Error
+}
+
+// insertActivity records the day of every event in the analytics time zone,
+// ignoring days already recorded for the device. Like upsertStatistics, it
+// writes in device_id order.
+func insertActivity(tx *gorm.DB, events []ingestEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].DeviceID < events[j].DeviceID })
+	rows := make([]string, 0, len(events))
+	args := make([]interface{}, 0, len(events)*7)
+	for _, ev := range events {
+		var country, region string
+		if ev.Geo != nil {
+			country, region = ev.Geo.Country, ev.Geo.Region
+		}
+		rows = append(rows, "(?, ?, ?, (?::date), ?, ?, ?)")
+		// Local midnight of the analytics time zone; the ::date cast keeps its Y-M-D
+		args = append(args, ev.DeviceID, ev.Platform, ev.AppVersion, analyticsZone.Day(ev.At), ev.At, country, region)
+	}
+	return tx.Exec(`INSERT INTO app_activity (device_id, platform, app_version, seen_date, seen_at, country, region)
+VALUES `+strings.Join(rows, ", ")+`
+ON CONFLICT (device_id, seen_date) DO NOTHING`, args...).Error
+}
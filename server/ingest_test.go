@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// expectErasedDevices expects the lookup of the erased devices of a batch,
+// returning the erasure times of the given device ids.
+func expectErasedDevices(mock sqlmock.Sqlmock, erased map[string]time.Time) {
+	rows := sqlmock.NewRows([]string{"device_hash", "erased_at"})
+	for deviceID, at := range erased {
+		rows.AddRow(erasedDeviceKey(deviceID), at)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT device_hash, erased_at FROM erased_devices WHERE device_hash IN (`)).
+		WillReturnRows(rows)
+}
+
+func TestWriteIngestBatchMergesEventsPerDevice(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	first := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	later := first.Add(time.Minute)
+	jp := &geoLocation{Country: "JP", Region: "13"}
+	batch := []ingestEvent{
+		{DeviceID: "device-1", Platform: "ios", AppVersion: "2.29.0", IP: "203.0.113.0", Geo: jp, At: first},
+		{DeviceID: "device-2", Platform: "android", AppVersion: "2.30.0", IP: "198.51.100.0", At: first},
+		{DeviceID: "device-1", Platform: "ios", AppVersion: "2.30.0", IP: "203.0.113.0", Geo: jp, At: later},
+	}
+
+	mock.ExpectBegin()
+	expectErasedDevices(mock, nil)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics (device_id, platform, app_version, ip, first_seen, last_seen, total_launches, country, region)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (device_id) DO UPDATE`)).
+		WithArgs("device-1", "ios", "2.30.0", "203.0.113.0", first, later, 2, "JP", "13").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics (device_id, platform, app_version, ip, first_seen, last_seen, total_launches)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (device_id) DO UPDATE
+SET platform = CASE WHEN EXCLUDED.last_seen >= app_statistics.last_seen THEN EXCLUDED.platform ELSE app_statistics.platform END,
+    app_version = CASE WHEN EXCLUDED.last_seen >= app_statistics.last_seen THEN EXCLUDED.app_version ELSE app_statistics.app_version END,
+    ip = CASE WHEN EXCLUDED.last_seen >= app_statistics.last_seen THEN EXCLUDED.ip ELSE app_statistics.ip END,
+    first_seen = LEAST(app_statistics.first_seen, EXCLUDED.first_seen),
+    last_seen = GREATEST(app_statistics.last_seen, EXCLUDED.last_seen),
+    total_launches = app_statistics.total_launches + EXCLUDED.total_launches,
+    dormant_at = NULL`)+`$`).
+		WithArgs("device-2", "android", "2.30.0", "198.51.100.0", first, first, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// One day per device: the first check of device-1 is kept
+	day := analyticsZone.Day(first)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity (device_id, platform, app_version, seen_date, seen_at, country, region)
+VALUES ($1, $2, $3, ($4::date), $5, $6, $7), ($8, $9, $10, ($11::date), $12, $13, $14)
+ON CONFLICT (device_id, seen_date) DO NOTHING`)).
+		WithArgs("device-1", "ios", "2.29.0", day, first, "JP", "13", "device-2", "android", "2.30.0", day, first, "", "").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	require.NoError(t, writeIngestBatch(db, batch))
+}
+
+func TestWriteIngestBatchSkipsChecksBeforeErasure(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	erasedAt := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	before := erasedAt.Add(-time.Second)
+	after := erasedAt.Add(time.Second)
+	batch := []ingestEvent{
+		// Queued while device-1 was erased: it must not bring the device back
+		{DeviceID: "device-1", Platform: "ios", AppVersion: "2.30.0", At: before, Sites: []ReportedSite{{TemplateID: "afun", SiteType: "NexusPHPWeb"}}},
+		{DeviceID: "device-2", Platform: "ios", AppVersion: "2.30.0", At: before},
+	}
+	mock.ExpectBegin()
+	expectErasedDevices(mock, map[string]time.Time{"device-1": erasedAt})
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics (device_id, platform, app_version, ip, first_seen, last_seen, total_launches)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`)).
+		WithArgs("device-2", "ios", "2.30.0", "", before, before, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity`)).
+		WithArgs("device-2", "ios", "2.30.0", analyticsZone.Day(before), before, "", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, writeIngestBatch(db, batch))
+
+	// Checks received after the erasure are recorded again
+	mock.ExpectBegin()
+	expectErasedDevices(mock, map[string]time.Time{"device-1": erasedAt})
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics`)).
+		WithArgs("device-1", "ios", "2.30.0", "", after, after, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, writeIngestBatch(db, []ingestEvent{{DeviceID: "device-1", Platform: "ios", AppVersion: "2.30.0", At: after}}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteIngestBatchRegistersDeviceSecrets(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	first := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	later := first.Add(time.Minute)
+	batch := []ingestEvent{
+		{DeviceID: "device-2", Platform: "ios", AppVersion: "2.30.0", SecretHash: "hash-2", At: first},
+		{DeviceID: "device-1", Platform: "ios", AppVersion: "2.30.0", SecretHash: "hash-1b", At: later},
+		{DeviceID: "device-1", Platform: "ios", AppVersion: "2.30.0", SecretHash: "hash-1a", At: first},
+		{DeviceID: "device-3", Platform: "ios", AppVersion: "2.30.0", At: first},
+	}
+	mock.ExpectBegin()
+	expectErasedDevices(mock, nil)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics`)).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity`)).WillReturnResult(sqlmock.NewResult(0, 3))
+	// The earliest secret of each device, in device_id order; registered secrets are kept
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO device_tokens (device_id, secret_hash)
+VALUES ($1, $2), ($3, $4)
+ON CONFLICT (device_id) DO UPDATE
+SET secret_hash = EXCLUDED.secret_hash
+WHERE device_tokens.secret_hash IS NULL`)).
+		WithArgs("device-1", "hash-1a", "device-2", "hash-2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	require.NoError(t, writeIngestBatch(db, batch))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIngestQueueDropsWhenFull(t *testing.T) {
+	db, _, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	// Without a writer the queue fills up
+	q := newIngestQueue(db, ingestConfig{QueueSize: 1, BatchSize: 1, FlushInterval: time.Second})
+	assert.True(t, q.Enqueue(ingestEvent{DeviceID: "device-1"}))
+	assert.False(t, q.Enqueue(ingestEvent{DeviceID: "device-2"}))
+
+	m := q.Metrics()
+	assert.Equal(t, 1, m.QueueDepth)
+	assert.Equal(t, 1, m.QueueCapacity)
+	assert.Equal(t, int64(1), m.Enqueued)
+	assert.Equal(t, int64(1), m.Dropped)
+}
+
+func TestIngestQueueRetriesAndFlushesOnClose(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	at := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	mock.ExpectBegin().WillReturnError(errors.New("too many connections"))
+	mock.ExpectBegin()
+	expectErasedDevices(mock, nil)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// The interval never elapses: the event is only written by Close
+	q := newIngestQueue(db, ingestConfig{QueueSize: 10, BatchSize: 10, FlushInterval: time.Hour})
+	q.Start()
+	require.True(t, q.Enqueue(ingestEvent{DeviceID: "device-1", Platform: "ios", AppVersion: "2.30.0", At: at}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, q.Close(ctx))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	m := q.Metrics()
+	assert.Equal(t, int64(1), m.Written)
+	assert.Equal(t, int64(1), m.Retries)
+	assert.Equal(t, int64(1), m.Batches)
+	assert.Zero(t, m.Failed)
+
+	// Closed queues take no more events
+	assert.False(t, q.Enqueue(ingestEvent{DeviceID: "device-2"}))
+	assert.Equal(t, int64(1), q.Metrics().Dropped)
+}
+
+func TestIngestQueueConcurrentChecksOfOneDevice(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	// Every check of the device ends up in one upsert that adds all launches,
+	// whatever order the requests queued their events in
+	const checks = 64
+	base := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	expectErasedDevices(mock, nil)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics (device_id, platform, app_version, ip, first_seen, last_seen, total_launches)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (device_id) DO UPDATE`)).
+		WithArgs("device-1", "ios", fmt.Sprintf("2.%d.0", checks-1), "203.0.113.0", base, base.Add((checks-1)*time.Second), checks).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity (device_id, platform, app_version, seen_date, seen_at, country, region)
+VALUES ($1, $2, $3, ($4::date), $5, $6, $7)
+ON CONFLICT (device_id, seen_date) DO NOTHING`)).
+		WithArgs("device-1", "ios", "2.0.0", analyticsZone.Day(base), base, "", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	q := newIngestQueue(db, ingestConfig{QueueSize: checks, BatchSize: checks, FlushInterval: time.Hour})
+	q.Start()
+	var wg sync.WaitGroup
+	for i := 0; i < checks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			q.Enqueue(ingestEvent{
+				DeviceID:   "device-1",
+				Platform:   "ios",
+				AppVersion: fmt.Sprintf("2.%d.0", i),
+				IP:         "203.0.113.0",
+				At:         base.Add(time.Duration(i) * time.Second),
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, q.Close(ctx))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, ingestMetrics{QueueCapacity: checks, Enqueued: checks, Written: checks, Batches: 1}, q.Metrics())
+}
+
+func TestIngestQueueConcurrentChecksAcrossBatches(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	// Capture the statistics upserts to check what every batch wrote
+	var mu sync.Mutex
+	var upserts [][]interface{}
+	require.NoError(t, db.Callback().Raw().After("gorm:raw").Register("test:capture", func(tx *gorm.DB) {
+		if strings.HasPrefix(tx.Statement.SQL.String(), "INSERT INTO app_statistics") {
+			mu.Lock()
+			upserts = append(upserts, tx.Statement.Vars)
+			mu.Unlock()
+		}
+	}))
+
+	// Checks of several devices from concurrent requests fill four batches,
+	// each holding any mix of devices
+	const devices, checksPerDevice, batchSize = 5, 8, 10
+	const checks = devices * checksPerDevice
+	base := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < checks/batchSize; i++ {
+		mock.ExpectBegin()
+		expectErasedDevices(mock, nil)
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	q := newIngestQueue(db, ingestConfig{QueueSize: checks, BatchSize: batchSize, FlushInterval: time.Hour})
+	q.Start()
+	var wg sync.WaitGroup
+	for i := 0; i < checks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			q.Enqueue(ingestEvent{
+				DeviceID:   fmt.Sprintf("device-%d", i%devices),
+				Platform:   "ios",
+				AppVersion: "2.30.0",
+				At:         base.Add(time.Duration(i) * time.Second),
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, q.Close(ctx))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, ingestMetrics{QueueCapacity: checks, Enqueued: checks, Written: checks, Batches: checks / batchSize}, q.Metrics())
+
+	// Every batch upserts its devices once each, in device_id order, and the
+	// launches of all batches add up to the checks of each device
+	const columns = 7
+	launches := make(map[string]int)
+	require.Len(t, upserts, checks/batchSize)
+	for _, vars := range upserts {
+		require.Zero(t, len(vars)%columns)
+		var ids []string
+		for row := 0; row < len(vars); row += columns {
+			id := vars[row].(string)
+			ids = append(ids, id)
+			launches[id] += vars[row+6].(int)
+		}
+		for k := 1; k < len(ids); k++ {
+			assert.Less(t, ids[k-1], ids[k], "devices of a batch in order: %v", ids)
+		}
+	}
+	require.Len(t, launches, devices)
+	for id, n := range launches {
+		assert.Equal(t, checksPerDevice, n, id)
+	}
+}
+
+func TestIngestQueueSplitsFailingBatch(t *testing.T) {
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+
+	at := time.Date(2024, 5, 10, 8, 0, 0, 0, time.UTC)
+	batch := []ingestEvent{
+		{DeviceID: "device-1", Platform: "ios", AppVersion: "2.30.0", At: at},
+		{DeviceID: "device-2", Platform: "ios", AppVersion: "2.30.0", At: at},
+		{DeviceID: "device-3", Platform: "ios", AppVersion: "2.30.0", At: at},
+	}
+	tooLong := errors.New("value too long for type character varying(100)")
+	// The whole batch fails, then its halves: device-1 alone, and device-2
+	// with device-3, which fails again and is split once more
+	mock.ExpectBegin()
+	expectErasedDevices(mock, nil)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics`)).WillReturnError(tooLong)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectErasedDevices(mock, nil)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics`)).WithArgs("device-1", "ios", "2.30.0", "", at, at, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	expectErasedDevices(mock, nil)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics`)).WillReturnError(tooLong)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectErasedDevices(mock, nil)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics`)).WithArgs("device-2", "ios", "2.30.0", "", at, at, 1).
+		WillReturnError(tooLong)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectErasedDevices(mock, nil)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_statistics`)).WithArgs("device-3", "ios", "2.30.0", "", at, at, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO app_activity`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	q := newIngestQueue(db, ingestConfig{QueueSize: 4, BatchSize: 4, FlushInterval: time.Hour})
+	q.split(batch)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	m := q.Metrics()
+	assert.Equal(t, int64(2), m.Written)
+	assert.Equal(t, int64(1), m.Failed)
+}
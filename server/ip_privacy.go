@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"server/privacy"
+)
+
+// ipRetentionInterval is how often IPs past IP_RETENTION_DAYS are scrubbed.
+const ipRetentionInterval = time.Hour
+
+// clientIPPolicy decides what is stored of the client IPs, see IP_POLICY.
+var clientIPPolicy = privacy.DefaultIPPolicy()
+
+// startIPRetention scrubs the IPs of devices unseen for IP_RETENTION_DAYS in
+// the background.
+func startIPRetention(db *gorm.DB) {
+	if clientIPPolicy.Retention == 0 {
+		return
+	}
+	log.Printf("IP policy %s, scrubbing IPs unseen for %v", clientIPPolicy.Mode, clientIPPolicy.Retention)
+	go clientIPPolicy.RunRetention(context.Background(), db, ipRetentionInterval)
+}
+
+// ipSearchCondition returns the condition of a device search for q on
+// device_id and, as far as IP_POLICY allows, the stored IP.
+func ipSearchCondition(q string) (string, []interface{}) {
+	like := "%" + q + "%"
+	key, exact := clientIPPolicy.SearchKey(q)
+	switch {
+	case exact:
+		return "device_id ILIKE ? OR ip = ?", []interface{}{like, key}
+	case key != "":
+		return "device_id ILIKE ? OR ip ILIKE ?", []interface{}{like, "%" + key + "%"}
+	}
+	return "device_id ILIKE ?", []interface{}{like}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+
+	"server/analytics"
+	"server/privacy"
 )
 
 // Embed static admin pages so they are available regardless of working directory
@@ -18,6 +24,10 @@ var adminIndexHTML []byte
 //go:embed admin/static/login.html
 var adminLoginHTML []byte
 
+// shutdownTimeout bounds the wait for in-flight requests and the analytics
+// queue on SIGINT / SIGTERM
+const shutdownTimeout = 15 * time.Second
+
 // load .env files using godotenv; ignore missing files
 func loadDotEnv(paths ...string) {
     for _, p := range paths {
@@ -40,24 +50,87 @@ func main() {
         log.Fatalf("init db failed: %v", err)
     }
 
+    // Update manifests are signed only when signing keys are configured
+    signer, err := loadUpdateSigner()
+    if err != nil {
+        log.Fatalf("load update signing keys failed: %v", err)
+    }
+
+    altSourceCfg, err := loadAltSourceConfig()
+    if err != nil {
+        log.Fatalf("load AltSource config failed: %v", err)
+    }
+
+    // Days of the analytics are counted in ANALYTICS_TIMEZONE
+    if analyticsZone, err = analytics.ZoneFromEnv(); err != nil {
+        log.Fatalf("load analytics time zone failed: %v", err)
+    }
+
+    // Keep the analytics rollups behind /stats current in the background
+    if err := startAnalyticsRollup(db); err != nil {
+        log.Fatalf("start analytics rollup failed: %v", err)
+    }
+
+    // Old activity and long unseen devices are purged past their retention
+    if err := startAnalyticsPurge(db); err != nil {
+        log.Fatalf("start analytics purge failed: %v", err)
+    }
+
+    // Monthly partitions of client_events exist before the first events arrive
+    if err := startEventPartitions(db); err != nil {
+        log.Fatalf("start event partitions failed: %v", err)
+    }
+
+    // Client IPs are anonymized at ingest and scrubbed after IP_RETENTION_DAYS
+    if clientIPPolicy, err = privacy.IPPolicyFromEnv(); err != nil {
+        log.Fatalf("load IP policy failed: %v", err)
+    }
+    startIPRetention(db)
+
+    // Devices are located from the offline GeoIP database at GEOIP_DB, if any
+    if geoIPLocator, err = loadGeoIPLocator(); err != nil {
+        log.Fatalf("load GeoIP database failed: %v", err)
+    }
+
+    // Update checks are recorded in batches by a single background writer
+    ingestCfg, err := ingestConfigFromEnv()
+    if err != nil {
+        log.Fatalf("load ingest config failed: %v", err)
+    }
+    ingest := newIngestQueue(db, ingestCfg)
+    ingest.Start()
+
     // Wire services
-    appSvc := NewAppService(db)
+    appSvc := NewAppService(db, signer, ingest)
     verSvc := NewVersionService(db)
 
     // Setup router
     r := gin.Default()
 
-    // Configure timezone for analytics (UTC+8)
-    // This doesn't change DB timezone; it's for reference if needed
-    _ = time.FixedZone("UTC+8", 8*3600)
-
     r.GET("/health", func(c *gin.Context) {
         c.JSON(http.StatusOK, gin.H{"status": "ok"})
     })
 
     // Routes
     r.POST("/api/v1/check-update", appSvc.CheckUpdate)
+    r.GET("/api/v1/update-keys", appSvc.UpdateKeys)
+    r.POST("/api/v1/events", ClientEvents(db))
+    r.POST("/api/v1/crash-reports", CrashReports(db))
+    r.GET("/api/v1/altsource", AltSource(db, altSourceCfg, false))
+    r.GET("/api/v1/altsource/beta", AltSource(db, altSourceCfg, true))
+    r.GET("/api/v1/appcast/:platform/:channel", verSvc.Appcast)
+    r.GET("/api/v1/feed/:platform/:channel", verSvc.UpdateFeed)
     r.POST("/api/v1/github/version-update", verSvc.UpdateVersion)
+    r.POST("/api/v1/github/release", verSvc.GitHubRelease)
+
+    // Device self-service: a device gets its token, then looks up or erases its own data
+    r.POST("/api/v1/device/token", DeviceIssueToken(db))
+    device := r.Group("/api/v1/device")
+    device.Use(DeviceAuthMiddleware())
+    {
+        device.GET("/data", DeviceOwnData(db))
+        device.DELETE("/data", DeviceEraseOwnData(db))
+    }
 
     // Admin routes: login and protected group
     r.POST("/api/v1/admin/login", AdminLoginHandler)
@@ -67,13 +140,39 @@ func main() {
         admin.GET("/stats/overview", AdminStatsOverview(db))
         admin.GET("/stats/platforms", AdminStatsPlatforms(db))
         admin.GET("/stats/versions", AdminStatsVersions(db))
+        admin.GET("/stats/countries", AdminStatsCountries(db))
+        admin.GET("/stats/sites", AdminStatsSites(db))
         admin.GET("/stats/devices", AdminStatsDevices(db))
         admin.GET("/stats/trend/dau", AdminStatsTrendDAU(db))
+        admin.GET("/stats/retention", AdminStatsRetention(db))
+        admin.GET("/stats/adoption", AdminStatsAdoption(db))
+        admin.GET("/stats/events", AdminStatsEvents(db))
+        admin.GET("/stats/events/trend", AdminStatsEventTrend(db))
+
+        // Streaming CSV / NDJSON exports for offline analysis
+        admin.GET("/export/devices", AdminExportDevices(db))
+        admin.GET("/export/activity", AdminExportActivity(db))
+        admin.GET("/export/stats/:kind", AdminExportStats(db))
+
+        // Data requests of a single device and their audit trail
+        admin.GET("/devices/:device_id", AdminDeviceData(db))
+        admin.DELETE("/devices/:device_id", AdminEraseDevice(db))
+        admin.GET("/device-erasures", AdminListDeviceErasures(db))
+
+        // Crash and error reports grouped by fingerprint
+        admin.GET("/crashes", AdminListCrashes(db))
+        admin.GET("/crashes/:id", AdminCrashDetail(db))
+
+        // Depth and drops of the analytics ingestion queue
+        admin.GET("/ingest", AdminIngestMetrics(ingest))
 
         // Version management
         admin.GET("/versions", verSvc.AdminListVersions)
         admin.POST("/versions/:id", verSvc.AdminUpdateVersion)
         admin.DELETE("/versions/:id", verSvc.AdminDeleteVersion)
+        admin.GET("/min-versions", verSvc.AdminListMinVersions)
+        admin.POST("/min-versions", verSvc.AdminUpsertMinVersion)
+        admin.DELETE("/min-versions/:id", verSvc.AdminDeleteMinVersion)
     }
 
     // Root redirect: always to /admin; the page checks token (localStorage)
@@ -93,7 +192,26 @@ func main() {
     if addr == "" {
         addr = ":8080"
     }
-    if err := r.Run(addr); err != nil {
-        log.Fatalf("server run failed: %v", err)
+    srv := &http.Server{Addr: addr, Handler: r}
+    go func() {
+        if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            log.Fatalf("server run failed: %v", err)
+        }
+    }()
+
+    // On SIGINT / SIGTERM finish the in-flight requests, then flush the
+    // update checks still queued
+    ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+    defer stop()
+    <-ctx.Done()
+    log.Printf("shutting down")
+
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+    defer cancel()
+    if err := srv.Shutdown(shutdownCtx); err != nil {
+        log.Printf("server shutdown failed: %v", err)
+    }
+    if err := ingest.Close(shutdownCtx); err != nil {
+        log.Printf("analytics flush failed: %v", err)
     }
 }
@@ -0,0 +1,12 @@
+-- +goose Up
+-- Staged rollout: percentage of devices offered the release, optional linear ramp to 100%
+ALTER TABLE app_versions ADD COLUMN rollout_percent INTEGER NOT NULL DEFAULT 100;
+ALTER TABLE app_versions ADD COLUMN rollout_paused BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE app_versions ADD COLUMN rollout_ramp_hours INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE app_versions ADD COLUMN rollout_started_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE app_versions DROP COLUMN rollout_started_at;
+ALTER TABLE app_versions DROP COLUMN rollout_ramp_hours;
+ALTER TABLE app_versions DROP COLUMN rollout_paused;
+ALTER TABLE app_versions DROP COLUMN rollout_percent;
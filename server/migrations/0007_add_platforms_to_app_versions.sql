@@ -0,0 +1,6 @@
+-- +goose Up
+-- Comma-separated list of platforms a release targets; empty means all platforms
+ALTER TABLE app_versions ADD COLUMN platforms VARCHAR(200) NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE app_versions DROP COLUMN platforms;
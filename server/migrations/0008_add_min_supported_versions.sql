@@ -0,0 +1,17 @@
+-- +goose Up
+-- Minimum app version still allowed to run, per update channel and platform.
+-- An empty platform applies to every platform of the channel.
+CREATE TABLE IF NOT EXISTS min_supported_versions (
+    id SERIAL PRIMARY KEY,
+    channel VARCHAR(20) NOT NULL,
+    platform VARCHAR(50) NOT NULL DEFAULT '',
+    min_version VARCHAR(50) NOT NULL,
+    blocked_reason TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_min_supported_versions_channel_platform ON min_supported_versions (channel, platform);
+
+-- +goose Down
+DROP TABLE IF EXISTS min_supported_versions;
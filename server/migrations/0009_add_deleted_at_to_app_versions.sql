@@ -0,0 +1,8 @@
+-- +goose Up
+-- Soft delete for releases removed on GitHub
+ALTER TABLE app_versions ADD COLUMN deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_app_versions_deleted_at ON app_versions (deleted_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_app_versions_deleted_at;
+ALTER TABLE app_versions DROP COLUMN deleted_at;
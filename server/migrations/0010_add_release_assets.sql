@@ -0,0 +1,26 @@
+-- +goose Up
+-- Downloadable build artifacts per release (one row per platform / arch / package format)
+CREATE TABLE IF NOT EXISTS release_assets (
+    id SERIAL PRIMARY KEY,
+    version_id INTEGER NOT NULL REFERENCES app_versions (id) ON DELETE CASCADE,
+    platform VARCHAR(50) NOT NULL,
+    arch VARCHAR(50) NOT NULL DEFAULT '',
+    format VARCHAR(20) NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    url VARCHAR(500) NOT NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    sha256 VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_release_assets_version_target ON release_assets (version_id, platform, arch, format);
+
+-- Existing Android direct links are arm64-v8a APKs
+INSERT INTO release_assets (version_id, platform, arch, format, url)
+SELECT id, 'android', 'arm64', 'apk', android_download_url
+FROM app_versions
+WHERE android_download_url IS NOT NULL AND android_download_url <> ''
+ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DROP TABLE IF EXISTS release_assets;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Sparkle EdDSA signature of the file and the oldest OS release the build supports
+ALTER TABLE release_assets ADD COLUMN ed_signature VARCHAR(128) NOT NULL DEFAULT '';
+ALTER TABLE release_assets ADD COLUMN min_os_version VARCHAR(50) NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE release_assets DROP COLUMN min_os_version;
+ALTER TABLE release_assets DROP COLUMN ed_signature;
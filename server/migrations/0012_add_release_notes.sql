@@ -0,0 +1,15 @@
+-- +goose Up
+-- Release notes per locale; app_versions.release_notes stays the default text
+CREATE TABLE IF NOT EXISTS release_notes (
+    id SERIAL PRIMARY KEY,
+    version_id INTEGER NOT NULL REFERENCES app_versions (id) ON DELETE CASCADE,
+    locale VARCHAR(35) NOT NULL,
+    notes TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_release_notes_version_locale ON release_notes (version_id, locale);
+
+-- +goose Down
+DROP TABLE IF EXISTS release_notes;
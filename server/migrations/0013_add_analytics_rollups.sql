@@ -0,0 +1,55 @@
+-- +goose Up
+-- Daily rollups maintained by the analytics rollup job (see server/analytics)
+
+-- Devices active per day, platform and app version, and how many of them were first seen that day
+CREATE TABLE IF NOT EXISTS stats_daily_activity (
+    day DATE NOT NULL,
+    platform VARCHAR(50) NOT NULL,
+    app_version VARCHAR(50) NOT NULL,
+    devices INTEGER NOT NULL DEFAULT 0,
+    new_devices INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, platform, app_version)
+);
+
+-- Devices active per day that were not new, by days since their previous visit
+-- (capped at 366, which also stands for "no earlier visit")
+CREATE TABLE IF NOT EXISTS stats_daily_returns (
+    day DATE NOT NULL,
+    away_days INTEGER NOT NULL,
+    devices INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, away_days)
+);
+
+-- Snapshot of app_statistics per day: devices currently on each platform and version,
+-- and how many of them were active in the 30 days before the snapshot
+CREATE TABLE IF NOT EXISTS stats_daily_devices (
+    day DATE NOT NULL,
+    platform VARCHAR(50) NOT NULL,
+    app_version VARCHAR(50) NOT NULL,
+    devices INTEGER NOT NULL DEFAULT 0,
+    active_devices INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, platform, app_version)
+);
+
+-- First activity of every device, used for retention cohorts
+CREATE TABLE IF NOT EXISTS stats_device_firsts (
+    device_id VARCHAR(100) PRIMARY KEY,
+    first_date DATE NOT NULL,
+    platform VARCHAR(50) NOT NULL,
+    app_version VARCHAR(50) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_stats_device_firsts_first_date ON stats_device_firsts (first_date);
+
+-- Watermarks of the rollup job
+CREATE TABLE IF NOT EXISTS stats_rollup_state (
+    name VARCHAR(50) PRIMARY KEY,
+    rolled_through DATE,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS stats_rollup_state;
+DROP TABLE IF EXISTS stats_device_firsts;
+DROP TABLE IF EXISTS stats_daily_devices;
+DROP TABLE IF EXISTS stats_daily_returns;
+DROP TABLE IF EXISTS stats_daily_activity;
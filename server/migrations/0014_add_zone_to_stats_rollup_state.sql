@@ -0,0 +1,6 @@
+-- +goose Up
+-- Time zone (IANA name) the rolled days were counted in, see ANALYTICS_TIMEZONE
+ALTER TABLE stats_rollup_state ADD COLUMN IF NOT EXISTS zone VARCHAR(64) NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE stats_rollup_state DROP COLUMN IF EXISTS zone;
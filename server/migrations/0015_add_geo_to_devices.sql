@@ -0,0 +1,14 @@
+-- +goose Up
+-- Country (ISO 3166-1 alpha-2) and region of the client IP at ingest, see GEOIP_DB
+ALTER TABLE app_statistics ADD COLUMN IF NOT EXISTS country VARCHAR(2) NOT NULL DEFAULT '';
+ALTER TABLE app_statistics ADD COLUMN IF NOT EXISTS region VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE app_activity ADD COLUMN IF NOT EXISTS country VARCHAR(2) NOT NULL DEFAULT '';
+ALTER TABLE app_activity ADD COLUMN IF NOT EXISTS region VARCHAR(100) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_app_statistics_country ON app_statistics (country);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_app_statistics_country;
+ALTER TABLE app_activity DROP COLUMN IF EXISTS region;
+ALTER TABLE app_activity DROP COLUMN IF EXISTS country;
+ALTER TABLE app_statistics DROP COLUMN IF EXISTS region;
+ALTER TABLE app_statistics DROP COLUMN IF EXISTS country;
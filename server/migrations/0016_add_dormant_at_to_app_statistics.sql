@@ -0,0 +1,6 @@
+-- +goose Up
+-- Set when a device has not been seen for DEVICE_RETENTION_DAYS; cleared on its next check
+ALTER TABLE app_statistics ADD COLUMN IF NOT EXISTS dormant_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE app_statistics DROP COLUMN IF EXISTS dormant_at;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- Devices that were handed their self-service token (HMAC of the device id under DEVICE_TOKEN_SECRET).
+-- It is issued once, so knowing a device id is not enough to obtain it later.
+CREATE TABLE IF NOT EXISTS device_tokens (
+    device_id VARCHAR(100) PRIMARY KEY,
+    issued_at TIMESTAMPTZ NOT NULL
+);
+
+-- Audit trail of device data erasures. It holds no device id, IP or other
+-- personal data: only when, on whose request and how many rows went.
+CREATE TABLE IF NOT EXISTS device_erasures (
+    id SERIAL PRIMARY KEY,
+    erased_at TIMESTAMPTZ NOT NULL,
+    requested_by VARCHAR(16) NOT NULL,
+    deleted_rows JSONB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_device_erasures_erased_at ON device_erasures (erased_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS device_erasures;
+DROP TABLE IF EXISTS device_tokens;
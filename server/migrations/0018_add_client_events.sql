@@ -0,0 +1,23 @@
+-- +goose Up
+-- Feature usage events reported by the app through /api/v1/events, validated
+-- against the schemas in server/events.go. Partitioned by month of occurred_at
+-- (UTC); the server creates the partitions ahead of time and drops them after
+-- EVENT_RETENTION_MONTHS. The default partition only catches what no monthly
+-- partition covers.
+CREATE TABLE IF NOT EXISTS client_events (
+    id BIGSERIAL,
+    name VARCHAR(64) NOT NULL,
+    device_id VARCHAR(100) NOT NULL,
+    platform VARCHAR(50) NOT NULL,
+    app_version VARCHAR(50) NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL,
+    properties JSONB NOT NULL DEFAULT '{}',
+    PRIMARY KEY (id, occurred_at)
+) PARTITION BY RANGE (occurred_at);
+CREATE INDEX IF NOT EXISTS idx_client_events_name_occurred_at ON client_events (name, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_client_events_device_id ON client_events (device_id);
+CREATE TABLE IF NOT EXISTS client_events_default PARTITION OF client_events DEFAULT;
+
+-- +goose Down
+DROP TABLE IF EXISTS client_events;
@@ -0,0 +1,33 @@
+-- +goose Up
+-- Site templates and adapters configured on each device, as last reported by
+-- check-update. Only the template id (empty for custom sites) and site type are
+-- stored: no URLs, user names or passkeys.
+CREATE TABLE IF NOT EXISTS device_sites (
+    device_id VARCHAR(100) NOT NULL,
+    template_id VARCHAR(64) NOT NULL,
+    site_type VARCHAR(32) NOT NULL,
+    reported_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (device_id, template_id, site_type)
+);
+CREATE INDEX IF NOT EXISTS idx_device_sites_template_id ON device_sites (template_id, site_type);
+
+-- Daily snapshots of device_sites: active devices configuring each template,
+-- and each site type (a device with several sites of a type counts once)
+CREATE TABLE IF NOT EXISTS stats_daily_sites (
+    day DATE NOT NULL,
+    template_id VARCHAR(64) NOT NULL,
+    site_type VARCHAR(32) NOT NULL,
+    devices INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, template_id, site_type)
+);
+CREATE TABLE IF NOT EXISTS stats_daily_site_types (
+    day DATE NOT NULL,
+    site_type VARCHAR(32) NOT NULL,
+    devices INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, site_type)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS stats_daily_site_types;
+DROP TABLE IF EXISTS stats_daily_sites;
+DROP TABLE IF EXISTS device_sites;
@@ -0,0 +1,50 @@
+-- +goose Up
+-- Crash and error reports sent by the app through /api/v1/crash-reports,
+-- grouped by the fingerprint of their error type and top stack frames. The
+-- counters of a group and of its affected versions cover every report; only
+-- the latest reports of each group are kept in crash_reports.
+CREATE TABLE IF NOT EXISTS crash_groups (
+    id BIGSERIAL PRIMARY KEY,
+    fingerprint CHAR(64) NOT NULL UNIQUE,
+    kind VARCHAR(16) NOT NULL,
+    error_type VARCHAR(200) NOT NULL DEFAULT '',
+    message TEXT NOT NULL DEFAULT '',
+    site_type VARCHAR(32) NOT NULL DEFAULT '',
+    occurrences BIGINT NOT NULL DEFAULT 0,
+    first_seen TIMESTAMPTZ NOT NULL,
+    last_seen TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_crash_groups_last_seen ON crash_groups (last_seen);
+
+CREATE TABLE IF NOT EXISTS crash_group_versions (
+    group_id BIGINT NOT NULL REFERENCES crash_groups (id) ON DELETE CASCADE,
+    app_version VARCHAR(50) NOT NULL,
+    platform VARCHAR(50) NOT NULL,
+    occurrences BIGINT NOT NULL DEFAULT 0,
+    first_seen TIMESTAMPTZ NOT NULL,
+    last_seen TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (group_id, app_version, platform)
+);
+
+-- Stack traces and log tails are redacted before they are stored
+CREATE TABLE IF NOT EXISTS crash_reports (
+    id BIGSERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL REFERENCES crash_groups (id) ON DELETE CASCADE,
+    device_id VARCHAR(100) NOT NULL,
+    platform VARCHAR(50) NOT NULL,
+    app_version VARCHAR(50) NOT NULL,
+    site_type VARCHAR(32) NOT NULL DEFAULT '',
+    template_id VARCHAR(64) NOT NULL DEFAULT '',
+    message TEXT NOT NULL DEFAULT '',
+    stack_trace TEXT NOT NULL DEFAULT '',
+    log_tail TEXT NOT NULL DEFAULT '',
+    occurred_at TIMESTAMPTZ NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_crash_reports_group_id ON crash_reports (group_id, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_crash_reports_device_id ON crash_reports (device_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS crash_reports;
+DROP TABLE IF EXISTS crash_group_versions;
+DROP TABLE IF EXISTS crash_groups;
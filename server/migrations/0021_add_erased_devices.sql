@@ -0,0 +1,11 @@
+-- +goose Up
+-- Devices erased in the last day, by the SHA-256 of their device id. Check-ins
+-- received before an erasure but still queued for writing are skipped, so they
+-- do not bring the erased rows back.
+CREATE TABLE IF NOT EXISTS erased_devices (
+    device_hash CHAR(64) PRIMARY KEY,
+    erased_at TIMESTAMPTZ NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS erased_devices;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- Check-update picks the newest published release of each platform by version,
+-- so the global "latest" flag no longer means anything.
+DROP INDEX IF EXISTS idx_app_versions_is_latest;
+ALTER TABLE app_versions DROP COLUMN IF EXISTS is_latest;
+
+-- +goose Down
+ALTER TABLE app_versions ADD COLUMN IF NOT EXISTS is_latest BOOLEAN DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_app_versions_is_latest ON app_versions (is_latest);
@@ -0,0 +1,11 @@
+-- +goose Up
+-- Devices register a secret of their own on their first check-in; only the
+-- device presenting it is handed its self-service token, again whenever it
+-- asks. issued_at is NULL until a token is handed out.
+ALTER TABLE device_tokens ADD COLUMN IF NOT EXISTS secret_hash CHAR(64);
+ALTER TABLE device_tokens ALTER COLUMN issued_at DROP NOT NULL;
+
+-- +goose Down
+DELETE FROM device_tokens WHERE issued_at IS NULL;
+ALTER TABLE device_tokens ALTER COLUMN issued_at SET NOT NULL;
+ALTER TABLE device_tokens DROP COLUMN IF EXISTS secret_hash;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- The build number of a release (the part after "+" in pubspec.yaml), which
+-- desktop builds carry as CFBundleVersion and Sparkle compares updates by.
+-- 0 while unknown; such releases are left out of the appcasts.
+ALTER TABLE app_versions ADD COLUMN IF NOT EXISTS build_number INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE app_versions DROP COLUMN IF EXISTS build_number;
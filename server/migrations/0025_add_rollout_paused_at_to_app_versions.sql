@@ -0,0 +1,7 @@
+-- +goose Up
+-- When the rollout was paused, so resuming can continue the ramp from the
+-- share it had reached without touching the configured rollout_percent.
+ALTER TABLE app_versions ADD COLUMN IF NOT EXISTS rollout_paused_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE app_versions DROP COLUMN IF EXISTS rollout_paused_at;
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	channelStable = "stable"
+	channelBeta   = "beta"
+)
+
+func updateChannel(isBeta bool) string {
+	if isBeta {
+		return channelBeta
+	}
+	return channelStable
+}
+
+// resolveMinSupportedVersion picks the floor that applies to a channel and
+// platform. A platform-specific rule wins over the channel-wide one, and beta
+// devices fall back to the stable rules when the beta channel has none.
+func resolveMinSupportedVersion(rules []MinSupportedVersion, channel, platform string) *MinSupportedVersion {
+	platform = normalizePlatform(platform)
+	channels := []string{channel}
+	if channel != channelStable {
+		channels = append(channels, channelStable)
+	}
+	for _, ch := range channels {
+		for _, p := range []string{platform, ""} {
+			for i := range rules {
+				if rules[i].Channel == ch && rules[i].Platform == p {
+					return &rules[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func loadMinSupportedVersions(db *gorm.DB) ([]MinSupportedVersion, error) {
+	var rules []MinSupportedVersion
+	err := db.Order("channel, platform").Find(&rules).Error
+	return rules, err
+}
+
+func (s *VersionService) AdminListMinVersions(c *gin.Context) {
+	rules, err := loadMinSupportedVersions(s.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch minimum versions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": rules})
+}
+
+// AdminUpsertMinVersion creates or replaces the floor for a channel and platform.
+func (s *VersionService) AdminUpsertMinVersion(c *gin.Context) {
+	var req AdminMinVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	channel := strings.ToLower(strings.TrimSpace(req.Channel))
+	if channel != channelStable && channel != channelBeta {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "channel must be stable or beta"})
+		return
+	}
+	platform := normalizePlatform(req.Platform)
+	if platform != "" && !isKnownPlatform(platform) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown platform " + req.Platform})
+		return
+	}
+	minVersion := strings.TrimSpace(req.MinVersion)
+	if _, err := parseSemver(minVersion); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_version must be a version such as 2.30.0"})
+		return
+	}
+
+	now := nowUTC()
+	rule := MinSupportedVersion{
+		Channel:       channel,
+		Platform:      platform,
+		MinVersion:    minVersion,
+		BlockedReason: req.BlockedReason,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "channel"}, {Name: "platform"}},
+		DoUpdates: clause.AssignmentColumns([]string{"min_version", "blocked_reason", "updated_at"}),
+	}).Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save minimum version"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Minimum version saved successfully"})
+}
+
+func (s *VersionService) AdminDeleteMinVersion(c *gin.Context) {
+	id := c.Param("id")
+	if err := s.db.Delete(&MinSupportedVersion{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete minimum version"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Minimum version deleted successfully"})
+}
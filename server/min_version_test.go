@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveMinSupportedVersion(t *testing.T) {
+	rules := []MinSupportedVersion{
+		{ID: 1, Channel: channelStable, Platform: "", MinVersion: "2.0.0"},
+		{ID: 2, Channel: channelStable, Platform: "android", MinVersion: "2.1.0"},
+		{ID: 3, Channel: channelBeta, Platform: "ios", MinVersion: "2.2.0-beta.1"},
+	}
+
+	tests := []struct {
+		name     string
+		channel  string
+		platform string
+		expected int
+	}{
+		{"Platform Specific Wins", channelStable, "Android", 2},
+		{"Channel Wide Fallback", channelStable, "windows", 1},
+		{"Beta Specific", channelBeta, "ios", 3},
+		{"Beta Falls Back To Stable", channelBeta, "android", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := resolveMinSupportedVersion(rules, tt.channel, tt.platform)
+			require.NotNil(t, rule)
+			assert.Equal(t, tt.expected, rule.ID)
+		})
+	}
+
+	assert.Nil(t, resolveMinSupportedVersion(nil, channelStable, "android"))
+}
+
+func TestAdminUpsertMinVersionRejectsInvalidVersions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, cleanup := newMockGormDB(t)
+	defer cleanup()
+	svc := NewVersionService(db)
+
+	for _, minVersion := range []string{"   ", "latest"} {
+		body := `{"channel":"stable","min_version":"` + minVersion + `"}`
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/admin/min-versions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+
+		svc.AdminUpsertMinVersion(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, minVersion)
+	}
+	// Nothing is stored
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,54 +1,191 @@
 package main
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // CheckUpdateRequest represents the request payload for update checking
 type CheckUpdateRequest struct {
-	DeviceID   string `json:"device_id" binding:"required"`
-	Platform   string `json:"platform" binding:"required"`
-	AppVersion string `json:"app_version" binding:"required"`
+	DeviceID   string `json:"device_id" binding:"required,max=100"`
+	Platform   string `json:"platform" binding:"required,max=50"`
+	AppVersion string `json:"app_version" binding:"required,max=50"`
 	IsBeta     bool   `json:"is_beta"`
+	// Optional hints used to pick the right build of a release
+	Arch          string `json:"arch"`
+	ABI           string `json:"abi"`
+	PackageFormat string `json:"package_format"`
+	// Locale selects the language of the release notes, before Accept-Language
+	Locale string `json:"locale"`
+	// DeviceSecret is a random secret the app generates once and sends with
+	// every check. The first one seen for the device is registered, see
+	// DeviceTokenRequest
+	DeviceSecret string `json:"device_secret" binding:"omitempty,min=32,max=128"`
+	// Sites lists the site templates configured in the app. Absent leaves the
+	// last reported list as it is; an empty list clears it.
+	Sites []ReportedSite `json:"sites"`
+}
+
+// DeviceTokenRequest asks for the self-service token of a device. It is handed
+// out whenever DeviceSecret is the one registered for the device.
+type DeviceTokenRequest struct {
+	DeviceID     string `json:"device_id" binding:"required,max=100"`
+	DeviceSecret string `json:"device_secret" binding:"required,min=32,max=128"`
+}
+
+// ReportedSite is a site configured in the app: the id of its template in
+// assets/sites (empty for a custom site) and its SiteType.
+type ReportedSite struct {
+	TemplateID string `json:"template_id"`
+	SiteType   string `json:"site_type"`
 }
 
 // CheckUpdateResponse represents the response for update checking
 type CheckUpdateResponse struct {
-	HasUpdate          bool   `json:"has_update"`
-	LatestVersion      string `json:"latest_version,omitempty"`
-	ReleaseNotes       string `json:"release_notes,omitempty"`
-	DownloadURL        string `json:"download_url,omitempty"`
-	AndroidDownloadURL string `json:"android_download_url,omitempty"`
+	HasUpdate          bool         `json:"has_update"`
+	LatestVersion      string       `json:"latest_version,omitempty"`
+	ReleaseNotes       string       `json:"release_notes,omitempty"`
+	ReleaseNotesLocale string       `json:"release_notes_locale,omitempty"`
+	DownloadURL        string       `json:"download_url,omitempty"`
+	AndroidDownloadURL string       `json:"android_download_url,omitempty"`
+	Asset              *UpdateAsset `json:"asset,omitempty"`
+	ForceUpdate        bool         `json:"force_update"`
+	MinVersion         string       `json:"min_version,omitempty"`
+	BlockedReason      string       `json:"blocked_reason,omitempty"`
+	// Manifest is the base64 encoded UpdateManifest JSON covered by Signatures
+	Manifest   string              `json:"manifest,omitempty"`
+	Signatures []ManifestSignature `json:"signatures,omitempty"`
 }
 
-// VersionUpdateRequest represents the request from GitHub Actions
+// UpdateAsset describes the build selected for the requesting device
+type UpdateAsset struct {
+	Platform string `json:"platform"`
+	Arch     string `json:"arch,omitempty"`
+	Format   string `json:"format"`
+	Name     string `json:"name,omitempty"`
+	URL      string `json:"url"`
+	Size     int64  `json:"size,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+}
+
+// VersionUpdateRequest represents the request from GitHub Actions.
+// ReleaseNotesI18n maps locales to translated notes; locales it omits keep
+// their stored notes and an empty value removes a translation. BuildNumber is
+// kept when omitted.
 type VersionUpdateRequest struct {
-	Version            string `json:"version" binding:"required"`
-	ReleaseNotes       string `json:"release_notes"`
-	DownloadURL        string `json:"download_url"`
-	AndroidDownloadURL string `json:"android_download_url"`
+	Version            string              `json:"version" binding:"required"`
+	BuildNumber        *int                `json:"build_number" binding:"omitempty,min=0"`
+	ReleaseNotes       string              `json:"release_notes"`
+	ReleaseNotesI18n   map[string]string   `json:"release_notes_i18n"`
+	DownloadURL        string              `json:"download_url"`
+	AndroidDownloadURL string              `json:"android_download_url"`
+	Platforms          []string            `json:"platforms"`
+	Assets             []ReleaseAssetInput `json:"assets"`
 }
 
-// AppVersion represents a version record in database
+// AppVersion represents a version record in database. BuildNumber is the
+// build number of the release (CFBundleVersion on macOS), 0 while unknown.
 type AppVersion struct {
-	ID                 int       `json:"id" gorm:"primaryKey"`
-	Version            string    `json:"version" gorm:"uniqueIndex;size:50;not null"`
-	ReleaseNotes       string    `json:"release_notes"`
-	DownloadURL        string    `json:"download_url" gorm:"size:500"`
-	AndroidDownloadURL string    `json:"android_download_url" gorm:"size:500"`
-	IsLatest           bool      `json:"is_latest" gorm:"index"`
-	IsBeta             bool      `json:"is_beta" gorm:"index"`
-	IsPublished        bool      `json:"is_published" gorm:"index;default:true"`
-	CreatedAt          time.Time `json:"created_at"`
-	UpdatedAt          time.Time `json:"updated_at"`
+	ID                 int               `json:"id" gorm:"primaryKey"`
+	Version            string            `json:"version" gorm:"uniqueIndex;size:50;not null"`
+	BuildNumber        int               `json:"build_number" gorm:"not null;default:0"`
+	ReleaseNotes       string            `json:"release_notes"`
+	DownloadURL        string            `json:"download_url" gorm:"size:500"`
+	AndroidDownloadURL string            `json:"android_download_url" gorm:"size:500"`
+	IsBeta             bool              `json:"is_beta" gorm:"index"`
+	IsPublished        bool              `json:"is_published" gorm:"index;default:true"`
+	Platforms          PlatformSet       `json:"platforms" gorm:"type:varchar(200);not null;default:''"`
+	RolloutPercent     int               `json:"rollout_percent" gorm:"not null;default:100"`
+	RolloutPaused      bool              `json:"rollout_paused" gorm:"not null;default:false"`
+	RolloutRampHours   int               `json:"rollout_ramp_hours" gorm:"not null;default:0"`
+	RolloutStartedAt   *time.Time        `json:"rollout_started_at"`
+	RolloutPausedAt    *time.Time        `json:"rollout_paused_at"`
+	CreatedAt          time.Time         `json:"created_at"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt    `json:"-" gorm:"index"`
+	Assets             []ReleaseAsset    `json:"assets,omitempty" gorm:"-"`
+	ReleaseNotesI18n   map[string]string `json:"release_notes_i18n,omitempty" gorm:"-"`
+}
+
+// ReleaseNote is the translation of a release's notes into one locale.
+// AppVersion.ReleaseNotes holds the notes in the default locale.
+type ReleaseNote struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	VersionID int       `json:"version_id" gorm:"index;not null"`
+	Locale    string    `json:"locale" gorm:"size:35;not null"`
+	Notes     string    `json:"notes" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReleaseAsset is a downloadable build of a release for one platform,
+// architecture and package format. An empty arch matches every architecture.
+// EdSignature is the base64 Sparkle EdDSA signature of the file, used by
+// desktop appcasts together with MinOSVersion.
+type ReleaseAsset struct {
+	ID           int       `json:"id" gorm:"primaryKey"`
+	VersionID    int       `json:"version_id" gorm:"index;not null"`
+	Platform     string    `json:"platform" gorm:"size:50;not null"`
+	Arch         string    `json:"arch" gorm:"size:50;not null;default:''"`
+	Format       string    `json:"format" gorm:"size:20;not null"`
+	Name         string    `json:"name" gorm:"size:255;not null;default:''"`
+	URL          string    `json:"url" gorm:"size:500;not null"`
+	Size         int64     `json:"size" gorm:"not null;default:0"`
+	SHA256       string    `json:"sha256" gorm:"column:sha256;size:64;not null;default:''"`
+	EdSignature  string    `json:"ed_signature" gorm:"size:128;not null;default:''"`
+	MinOSVersion string    `json:"min_os_version" gorm:"column:min_os_version;size:50;not null;default:''"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ReleaseAssetInput describes an asset in webhook and admin payloads. Platform,
+// arch and format are inferred from the file name when omitted.
+type ReleaseAssetInput struct {
+	Platform     string `json:"platform"`
+	Arch         string `json:"arch"`
+	Format       string `json:"format"`
+	Name         string `json:"name"`
+	URL          string `json:"url" binding:"required"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	EdSignature  string `json:"ed_signature"`
+	MinOSVersion string `json:"min_os_version"`
 }
 
 // AdminUpdateVersionRequest represents the request to update a version from admin panel
 type AdminUpdateVersionRequest struct {
-	ReleaseNotes       *string `json:"release_notes"`
-	DownloadURL        *string `json:"download_url"`
-	AndroidDownloadURL *string `json:"android_download_url"`
-	IsLatest           *bool   `json:"is_latest"`
-	IsBeta             *bool   `json:"is_beta"`
-	IsPublished        *bool   `json:"is_published"`
+	BuildNumber        *int                 `json:"build_number"`
+	ReleaseNotes       *string              `json:"release_notes"`
+	ReleaseNotesI18n   map[string]string    `json:"release_notes_i18n"`
+	DownloadURL        *string              `json:"download_url"`
+	AndroidDownloadURL *string              `json:"android_download_url"`
+	IsBeta             *bool                `json:"is_beta"`
+	IsPublished        *bool                `json:"is_published"`
+	Platforms          *[]string            `json:"platforms"`
+	RolloutPercent     *int                 `json:"rollout_percent"`
+	RolloutPaused      *bool                `json:"rollout_paused"`
+	RolloutRampHours   *int                 `json:"rollout_ramp_hours"`
+	Assets             *[]ReleaseAssetInput `json:"assets"`
+}
+
+// MinSupportedVersion is the oldest app version still allowed to run on a
+// channel ("stable" or "beta") and platform. An empty platform applies to all.
+type MinSupportedVersion struct {
+	ID            int       `json:"id" gorm:"primaryKey"`
+	Channel       string    `json:"channel" gorm:"size:20;not null"`
+	Platform      string    `json:"platform" gorm:"size:50;not null;default:''"`
+	MinVersion    string    `json:"min_version" gorm:"size:50;not null"`
+	BlockedReason string    `json:"blocked_reason"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// AdminMinVersionRequest creates or replaces the floor for a channel and platform
+type AdminMinVersionRequest struct {
+	Channel       string `json:"channel" binding:"required"`
+	Platform      string `json:"platform"`
+	MinVersion    string `json:"min_version" binding:"required"`
+	BlockedReason string `json:"blocked_reason"`
 }
 
 // AppStatistic represents usage statistics in database
@@ -58,9 +195,13 @@ type AppStatistic struct {
 	Platform      string    `json:"platform" gorm:"index;size:50;not null"`
 	AppVersion    string    `json:"app_version" gorm:"size:50;not null"`
 	IP            string    `json:"ip" gorm:"size:64"`
+	Country       string    `json:"country" gorm:"size:2;index;not null;default:''"`
+	Region        string    `json:"region" gorm:"size:100;not null;default:''"`
 	FirstSeen     time.Time `json:"first_seen"`
 	LastSeen      time.Time `json:"last_seen" gorm:"index"`
 	TotalLaunches int       `json:"total_launches"`
+	// DormantAt is set when the device went unseen past DEVICE_RETENTION_DAYS
+	DormantAt *time.Time `json:"dormant_at"`
 }
 
 // AppActivity records daily activity per device for trend analytics
@@ -71,4 +212,6 @@ type AppActivity struct {
 	AppVersion string    `json:"app_version" gorm:"index;size:50;not null"`
 	SeenDate   time.Time `json:"seen_date" gorm:"type:date;index;not null"`
 	SeenAt     time.Time `json:"seen_at" gorm:"not null"`
+	Country    string    `json:"country" gorm:"size:2;not null;default:''"`
+	Region     string    `json:"region" gorm:"size:100;not null;default:''"`
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// knownPlatforms lists the platforms the app is built for, in display order.
+var knownPlatforms = []string{"android", "ios", "linux", "macos", "windows", "web"}
+
+func normalizePlatform(p string) string {
+	return strings.ToLower(strings.TrimSpace(p))
+}
+
+func isKnownPlatform(p string) bool {
+	for _, k := range knownPlatforms {
+		if k == p {
+			return true
+		}
+	}
+	return false
+}
+
+func platformOrder(p string) int {
+	for i, k := range knownPlatforms {
+		if k == p {
+			return i
+		}
+	}
+	return len(knownPlatforms)
+}
+
+// sortPlatforms sorts platform names in the order of knownPlatforms.
+func sortPlatforms(platforms []string) {
+	sort.Slice(platforms, func(i, j int) bool { return platformOrder(platforms[i]) < platformOrder(platforms[j]) })
+}
+
+// PlatformSet is the set of platforms a release targets. An empty set means the
+// release is available on every platform. It is stored as a comma-separated
+// string and serialized as a JSON array.
+type PlatformSet []string
+
+// parsePlatformSet normalizes, de-duplicates and validates a list of platform names.
+func parsePlatformSet(values []string) (PlatformSet, error) {
+	seen := make(map[string]bool, len(values))
+	set := PlatformSet{}
+	for _, raw := range values {
+		p := normalizePlatform(raw)
+		if p == "" || seen[p] {
+			continue
+		}
+		if !isKnownPlatform(p) {
+			return nil, fmt.Errorf("unknown platform %q", raw)
+		}
+		seen[p] = true
+		set = append(set, p)
+	}
+	sortPlatforms(set)
+	return set, nil
+}
+
+// Covers reports whether a release with this platform set is available on platform.
+func (ps PlatformSet) Covers(platform string) bool {
+	if len(ps) == 0 {
+		return true
+	}
+	platform = normalizePlatform(platform)
+	for _, p := range ps {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand returns the concrete platforms the set covers.
+func (ps PlatformSet) Expand() []string {
+	if len(ps) == 0 {
+		return knownPlatforms
+	}
+	return ps
+}
+
+func (ps PlatformSet) Value() (driver.Value, error) {
+	return strings.Join(ps, ","), nil
+}
+
+func (ps *PlatformSet) Scan(src interface{}) error {
+	var raw string
+	switch v := src.(type) {
+	case nil:
+		raw = ""
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("unsupported platforms value %T", src)
+	}
+	*ps = nil
+	for _, p := range strings.Split(raw, ",") {
+		if p = normalizePlatform(p); p != "" {
+			*ps = append(*ps, p)
+		}
+	}
+	return nil
+}
+
+func (ps PlatformSet) MarshalJSON() ([]byte, error) {
+	if ps == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]string(ps))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatformSet(t *testing.T) {
+	set, err := parsePlatformSet([]string{" Windows", "ios", "android", "ios", ""})
+	require.NoError(t, err)
+	assert.Equal(t, PlatformSet{"android", "ios", "windows"}, set)
+
+	_, err = parsePlatformSet([]string{"symbian"})
+	assert.Error(t, err)
+}
+
+func TestPlatformSetCovers(t *testing.T) {
+	assert.True(t, PlatformSet{}.Covers("linux"))
+	assert.True(t, PlatformSet{"ios"}.Covers("iOS"))
+	assert.False(t, PlatformSet{"ios"}.Covers("windows"))
+}
+
+func TestPlatformSetScanAndJSON(t *testing.T) {
+	var set PlatformSet
+	require.NoError(t, set.Scan("android,ios"))
+	assert.Equal(t, PlatformSet{"android", "ios"}, set)
+
+	require.NoError(t, set.Scan(""))
+	b, err := json.Marshal(set)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[]`, string(b))
+}
+
+func TestLatestVersionsByPlatform(t *testing.T) {
+	versions := []AppVersion{
+		{Version: "2.1.0-beta.1", IsBeta: true},
+		{Version: "2.0.1", Platforms: PlatformSet{"ios"}},
+		{Version: "2.0.0"},
+	}
+
+	stable := latestVersionsByPlatform(versions, false)
+	assert.Equal(t, "2.0.1", stable["ios"])
+	assert.Equal(t, "2.0.0", stable["windows"])
+
+	beta := latestVersionsByPlatform(versions, true)
+	assert.Equal(t, "2.1.0-beta.1", beta["ios"])
+}
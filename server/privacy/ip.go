@@ -0,0 +1,236 @@
+// Package privacy decides what is kept of the client IP addresses recorded in
+// app_statistics.ip. It is shared by the server, which anonymizes IPs at
+// ingest and scrubs old ones, and the migrate CLI, which applies a new policy
+// to the stored rows.
+package privacy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IPMode is what is stored of a client IP.
+type IPMode string
+
+const (
+	// IPRaw stores the address as received.
+	IPRaw IPMode = "raw"
+	// IPTruncate stores the /24 network of IPv4 and the /48 of IPv6 addresses.
+	IPTruncate IPMode = "truncate"
+	// IPHash stores the hex HMAC-SHA256 of the address under IP_HASH_KEY, so
+	// a device can still be looked up by its exact IP.
+	IPHash IPMode = "hash"
+	// IPNone stores nothing.
+	IPNone IPMode = "none"
+)
+
+const (
+	ipv4Prefix = 24
+	ipv6Prefix = 48
+	// applyBatch is the number of rows rewritten per transaction by Apply
+	applyBatch = 1000
+)
+
+// IPPolicy anonymizes client IPs and scrubs them after Retention; a zero
+// Retention keeps them as long as the device.
+type IPPolicy struct {
+	Mode      IPMode
+	Retention time.Duration
+	key       []byte
+}
+
+// NewIPPolicy returns the policy of mode, hashing with key.
+func NewIPPolicy(mode IPMode, key string, retention time.Duration) (*IPPolicy, error) {
+	switch mode {
+	case IPRaw, IPTruncate, IPNone:
+	case IPHash:
+		if key == "" {
+			return nil, fmt.Errorf("IP_POLICY=hash requires IP_HASH_KEY")
+		}
+	default:
+		return nil, fmt.Errorf("invalid IP policy %q, want raw, truncate, hash or none", mode)
+	}
+	if retention < 0 {
+		return nil, fmt.Errorf("invalid IP retention %v", retention)
+	}
+	return &IPPolicy{Mode: mode, Retention: retention, key: []byte(key)}, nil
+}
+
+// IPPolicyFromEnv loads IP_POLICY (default raw), IP_HASH_KEY and
+// IP_RETENTION_DAYS (default 0).
+func IPPolicyFromEnv() (*IPPolicy, error) {
+	mode := IPMode(strings.ToLower(strings.TrimSpace(os.Getenv("IP_POLICY"))))
+	if mode == "" {
+		mode = IPRaw
+	}
+	var days int
+	if raw := os.Getenv("IP_RETENTION_DAYS"); raw != "" {
+		var err error
+		if days, err = strconv.Atoi(raw); err != nil || days < 0 {
+			return nil, fmt.Errorf("invalid IP_RETENTION_DAYS %q", raw)
+		}
+	}
+	return NewIPPolicy(mode, os.Getenv("IP_HASH_KEY"), time.Duration(days)*24*time.Hour)
+}
+
+// DefaultIPPolicy stores raw IPs for ever, as earlier releases did.
+func DefaultIPPolicy() *IPPolicy {
+	return &IPPolicy{Mode: IPRaw}
+}
+
+// Anonymize returns what is stored of the client IP ip. Values that are not
+// an IP address are dropped unless the policy is raw.
+func (p *IPPolicy) Anonymize(ip string) string {
+	if p.Mode == IPRaw {
+		return ip
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return ""
+	}
+	return p.anonymizeAddr(addr)
+}
+
+func (p *IPPolicy) anonymizeAddr(addr netip.Addr) string {
+	addr = addr.Unmap().WithZone("")
+	switch p.Mode {
+	case IPTruncate:
+		bits := ipv6Prefix
+		if addr.Is4() {
+			bits = ipv4Prefix
+		}
+		prefix, _ := addr.Prefix(bits)
+		return prefix.Addr().String()
+	case IPHash:
+		mac := hmac.New(sha256.New, p.key)
+		mac.Write([]byte(addr.String()))
+		return hex.EncodeToString(mac.Sum(nil))
+	case IPNone:
+		return ""
+	}
+	return addr.String()
+}
+
+// rewrite returns the value of a stored IP under the policy and whether it
+// changed. Values that are not an IP address were anonymized before, e.g.
+// hashed, and are kept unless the policy is none.
+func (p *IPPolicy) rewrite(stored string) (string, bool) {
+	if p.Mode == IPNone {
+		return "", stored != ""
+	}
+	if p.Mode == IPRaw {
+		return stored, false
+	}
+	addr, err := netip.ParseAddr(stored)
+	if err != nil {
+		return stored, false
+	}
+	v := p.anonymizeAddr(addr)
+	return v, v != stored
+}
+
+// SearchKey returns what a device search for q matches in app_statistics.ip:
+// an exact stored value when q is an IP address the policy rewrites, or a
+// substring otherwise. It returns "" when IPs cannot be searched for q.
+func (p *IPPolicy) SearchKey(q string) (key string, exact bool) {
+	switch p.Mode {
+	case IPRaw:
+		return q, false
+	case IPNone:
+		return "", false
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSpace(q)); err == nil {
+		return p.anonymizeAddr(addr), true
+	}
+	if p.Mode == IPTruncate {
+		return q, false
+	}
+	// Substrings of a hash are meaningless
+	return "", false
+}
+
+// Apply rewrites the IPs stored in app_statistics under the policy, e.g.
+// after IP_POLICY was tightened, and returns the number of rows changed.
+// Raw cannot restore anonymized IPs, so it changes nothing.
+func (p *IPPolicy) Apply(db *gorm.DB) (int64, error) {
+	switch p.Mode {
+	case IPRaw:
+		return 0, nil
+	case IPNone:
+		res := db.Exec(`UPDATE app_statistics SET ip = '' WHERE ip <> ''`)
+		return res.RowsAffected, res.Error
+	}
+
+	var changed int64
+	lastID := 0
+	for {
+		var rows []struct {
+			ID int
+			IP string
+		}
+		if err := db.Raw(`SELECT id, ip FROM app_statistics WHERE id > ? AND ip <> '' ORDER BY id LIMIT ?`,
+			lastID, applyBatch).Scan(&rows).Error; err != nil {
+			return changed, err
+		}
+		if len(rows) == 0 {
+			return changed, nil
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, r := range rows {
+				v, ok := p.rewrite(r.IP)
+				if !ok {
+					continue
+				}
+				if err := tx.Exec(`UPDATE app_statistics SET ip = ? WHERE id = ?`, v, r.ID).Error; err != nil {
+					return err
+				}
+				changed++
+			}
+			return nil
+		})
+		if err != nil {
+			return changed, err
+		}
+		lastID = rows[len(rows)-1].ID
+	}
+}
+
+// Scrub clears the IPs of devices not seen within the retention before now
+// and returns the number of rows cleared. The IP is refreshed on every check,
+// so last_seen is also when it was recorded.
+func (p *IPPolicy) Scrub(db *gorm.DB, now time.Time) (int64, error) {
+	if p.Retention == 0 {
+		return 0, nil
+	}
+	res := db.Exec(`UPDATE app_statistics SET ip = '' WHERE ip <> '' AND last_seen < ?`, now.Add(-p.Retention))
+	return res.RowsAffected, res.Error
+}
+
+// RunRetention scrubs expired IPs every interval until ctx is done.
+func (p *IPPolicy) RunRetention(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := p.Scrub(db, time.Now()); err != nil {
+			log.Printf("IP retention scrub failed: %v", err)
+		} else if n > 0 {
+			log.Printf("IP retention: scrubbed %d IPs", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
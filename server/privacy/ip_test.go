@@ -0,0 +1,156 @@
+package privacy
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock, func()) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: db,
+	}), &gorm.Config{})
+	require.NoError(t, err)
+
+	return gormDB, mock, func() {
+		mock.ExpectClose()
+		assert.NoError(t, db.Close())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func mustPolicy(t *testing.T, mode IPMode) *IPPolicy {
+	t.Helper()
+	p, err := NewIPPolicy(mode, "secret", 0)
+	require.NoError(t, err)
+	return p
+}
+
+func TestAnonymize(t *testing.T) {
+	truncate := mustPolicy(t, IPTruncate)
+	assert.Equal(t, "203.0.113.0", truncate.Anonymize("203.0.113.57"))
+	assert.Equal(t, "203.0.113.0", truncate.Anonymize("::ffff:203.0.113.57"))
+	assert.Equal(t, "2001:db8:1::", truncate.Anonymize("2001:db8:1:2:3:4:5:6"))
+	assert.Equal(t, "", truncate.Anonymize("not-an-ip"))
+
+	hash := mustPolicy(t, IPHash)
+	h := hash.Anonymize("203.0.113.57")
+	assert.Len(t, h, 64)
+	assert.Equal(t, h, hash.Anonymize("::ffff:203.0.113.57"))
+	assert.NotEqual(t, h, hash.Anonymize("203.0.113.58"))
+	other, err := NewIPPolicy(IPHash, "another secret", 0)
+	require.NoError(t, err)
+	assert.NotEqual(t, h, other.Anonymize("203.0.113.57"))
+
+	assert.Equal(t, "", mustPolicy(t, IPNone).Anonymize("203.0.113.57"))
+	assert.Equal(t, "203.0.113.57", DefaultIPPolicy().Anonymize("203.0.113.57"))
+}
+
+func TestNewIPPolicyValidates(t *testing.T) {
+	_, err := NewIPPolicy(IPHash, "", 0)
+	assert.Error(t, err)
+	_, err = NewIPPolicy("mask", "", 0)
+	assert.Error(t, err)
+
+	t.Setenv("IP_POLICY", "Truncate")
+	t.Setenv("IP_RETENTION_DAYS", "30")
+	p, err := IPPolicyFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, IPTruncate, p.Mode)
+	assert.Equal(t, 30*24*time.Hour, p.Retention)
+
+	t.Setenv("IP_RETENTION_DAYS", "-1")
+	_, err = IPPolicyFromEnv()
+	assert.Error(t, err)
+}
+
+func TestSearchKey(t *testing.T) {
+	key, exact := mustPolicy(t, IPTruncate).SearchKey("203.0.113.57")
+	assert.Equal(t, "203.0.113.0", key)
+	assert.True(t, exact)
+	key, exact = mustPolicy(t, IPTruncate).SearchKey("203.0")
+	assert.Equal(t, "203.0", key)
+	assert.False(t, exact)
+
+	hash := mustPolicy(t, IPHash)
+	key, exact = hash.SearchKey("203.0.113.57")
+	assert.Equal(t, hash.Anonymize("203.0.113.57"), key)
+	assert.True(t, exact)
+	key, _ = hash.SearchKey("203.0")
+	assert.Empty(t, key)
+
+	key, _ = mustPolicy(t, IPNone).SearchKey("203.0.113.57")
+	assert.Empty(t, key)
+}
+
+func TestApplyRewritesStoredIPs(t *testing.T) {
+	db, mock, cleanup := newMockDB(t)
+	defer cleanup()
+
+	p := mustPolicy(t, IPHash)
+	hashed := p.Anonymize("198.51.100.1")
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, ip FROM app_statistics WHERE id > $1 AND ip <> '' ORDER BY id LIMIT $2`)).
+		WithArgs(0, applyBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ip"}).
+			AddRow(3, "203.0.113.57").
+			AddRow(7, hashed))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE app_statistics SET ip = $1 WHERE id = $2`)).
+		WithArgs(p.Anonymize("203.0.113.57"), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, ip FROM app_statistics WHERE id > $1 AND ip <> '' ORDER BY id LIMIT $2`)).
+		WithArgs(7, applyBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ip"}))
+
+	changed, err := p.Apply(db)
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), changed)
+}
+
+func TestApplyNoneClearsAllIPs(t *testing.T) {
+	db, mock, cleanup := newMockDB(t)
+	defer cleanup()
+
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE app_statistics SET ip = '' WHERE ip <> ''`)).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+
+	changed, err := mustPolicy(t, IPNone).Apply(db)
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), changed)
+}
+
+func TestScrubClearsExpiredIPs(t *testing.T) {
+	db, mock, cleanup := newMockDB(t)
+	defer cleanup()
+
+	p, err := NewIPPolicy(IPTruncate, "", 30*24*time.Hour)
+	require.NoError(t, err)
+	now := time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE app_statistics SET ip = '' WHERE ip <> '' AND last_seen < $1`)).
+		WithArgs(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+
+	n, err := p.Scrub(db, now)
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+
+	// Without a retention nothing is scrubbed
+	n, err = mustPolicy(t, IPTruncate).Scrub(db, now)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
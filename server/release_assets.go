@@ -0,0 +1,298 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Canonical architecture names stored in release_assets.arch. An empty arch
+// means the asset runs on every architecture of its platform.
+const (
+	archARM64     = "arm64"
+	archARM       = "arm"
+	archX86_64    = "x86_64"
+	archX86       = "x86"
+	archUniversal = "universal"
+)
+
+// archAliases maps the names used by Android ABIs, Go, uname and our asset
+// file names onto canonical architectures.
+var archAliases = map[string]string{
+	"arm64":       archARM64,
+	"arm64-v8a":   archARM64,
+	"aarch64":     archARM64,
+	"arm":         archARM,
+	"armv7":       archARM,
+	"armv7l":      archARM,
+	"armeabi-v7a": archARM,
+	"x86_64":      archX86_64,
+	"x86-64":      archX86_64,
+	"x64":         archX86_64,
+	"amd64":       archX86_64,
+	"x86":         archX86,
+	"i386":        archX86,
+	"i686":        archX86,
+	"universal":   archUniversal,
+}
+
+// defaultArch is assumed for clients that do not report their architecture.
+var defaultArch = map[string]string{
+	"android": archARM64,
+	"ios":     archARM64,
+	"linux":   archX86_64,
+	"macos":   archX86_64,
+	"windows": archX86_64,
+}
+
+// preferredFormats orders package formats per platform, most preferred first.
+var preferredFormats = map[string][]string{
+	"android": {"apk"},
+	"ios":     {"ipa"},
+	"linux":   {"appimage", "deb", "rpm", "tar.gz"},
+	"macos":   {"dmg", "app.zip", "pkg"},
+	"windows": {"exe", "msix", "msi", "zip"},
+}
+
+// assetFormats lists recognized file extensions, longest first so that
+// "app.zip" and "tar.gz" win over "zip" and "gz".
+var assetFormats = []string{"app.zip", "tar.gz", "appimage", "msix", "apk", "aab", "ipa", "exe", "msi", "dmg", "pkg", "deb", "rpm", "zip"}
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func normalizeArch(a string) string {
+	a = strings.ToLower(strings.TrimSpace(a))
+	if canonical, ok := archAliases[a]; ok {
+		return canonical
+	}
+	return a
+}
+
+func normalizeFormat(f string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(f)), ".")
+}
+
+// classifyReleaseAsset infers platform, architecture and package format from
+// the file names produced by the release workflow, e.g.
+// pt_mate-2.29.0-arm64-v8a.apk or pt_mate-2.29.0-windows-x64.exe.
+// It returns an empty platform for files that are not installable builds.
+func classifyReleaseAsset(name string) (platform, arch, format string) {
+	n := strings.ToLower(name)
+	for _, f := range assetFormats {
+		if strings.HasSuffix(n, "."+f) {
+			format = f
+			break
+		}
+	}
+
+	switch format {
+	case "apk", "aab":
+		platform = "android"
+	case "ipa":
+		platform = "ios"
+	case "exe", "msi", "msix":
+		platform = "windows"
+	case "dmg", "app.zip", "pkg":
+		platform = "macos"
+	case "appimage", "deb", "rpm":
+		platform = "linux"
+	case "tar.gz", "zip":
+		for _, p := range []string{"linux", "windows", "macos"} {
+			if strings.Contains(n, p) {
+				platform = p
+			}
+		}
+	}
+	if platform == "" {
+		return "", "", ""
+	}
+
+	// Match the longest alias so "arm64-v8a" is not read as "arm"
+	base := strings.TrimSuffix(n, "."+format)
+	best := ""
+	for alias := range archAliases {
+		if len(alias) > len(best) && containsToken(base, alias) {
+			best = alias
+		}
+	}
+	if best != "" {
+		arch = archAliases[best]
+	}
+	return platform, arch, format
+}
+
+// containsToken reports whether token appears in s delimited by '-', '_' or '.'.
+func containsToken(s, token string) bool {
+	for i := strings.Index(s, token); i >= 0; {
+		end := i + len(token)
+		if (i == 0 || strings.ContainsRune("-_.", rune(s[i-1]))) && (end == len(s) || strings.ContainsRune("-_.", rune(s[end]))) {
+			return true
+		}
+		next := strings.Index(s[i+1:], token)
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return false
+}
+
+// isEdSignature reports whether sig is a base64 Ed25519 signature, as
+// written by Sparkle's sign_update.
+func isEdSignature(sig string) bool {
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	return err == nil && len(raw) == ed25519.SignatureSize
+}
+
+// buildReleaseAssets validates and normalizes asset input from the webhook or admin API.
+func buildReleaseAssets(inputs []ReleaseAssetInput) ([]ReleaseAsset, error) {
+	assets := make([]ReleaseAsset, 0, len(inputs))
+	seen := make(map[string]bool, len(inputs))
+	for _, in := range inputs {
+		a := ReleaseAsset{
+			Platform:     normalizePlatform(in.Platform),
+			Arch:         normalizeArch(in.Arch),
+			Format:       normalizeFormat(in.Format),
+			Name:         strings.TrimSpace(in.Name),
+			URL:          strings.TrimSpace(in.URL),
+			Size:         in.Size,
+			SHA256:       strings.ToLower(strings.TrimSpace(in.SHA256)),
+			EdSignature:  strings.TrimSpace(in.EdSignature),
+			MinOSVersion: strings.TrimSpace(in.MinOSVersion),
+		}
+		if a.Platform == "" || a.Format == "" {
+			if p, arch, f := classifyReleaseAsset(a.Name); p != "" {
+				if a.Platform == "" {
+					a.Platform = p
+				}
+				if a.Arch == "" {
+					a.Arch = arch
+				}
+				if a.Format == "" {
+					a.Format = f
+				}
+			}
+		}
+		if !isKnownPlatform(a.Platform) {
+			return nil, fmt.Errorf("asset %q: unknown platform %q", a.Name, in.Platform)
+		}
+		if a.Format == "" || a.URL == "" {
+			return nil, fmt.Errorf("asset %q: format and url are required", a.Name)
+		}
+		if a.SHA256 != "" && !sha256Pattern.MatchString(a.SHA256) {
+			return nil, fmt.Errorf("asset %q: sha256 must be 64 hex characters", a.Name)
+		}
+		if a.EdSignature != "" && !isEdSignature(a.EdSignature) {
+			return nil, fmt.Errorf("asset %q: ed_signature must be a base64 encoded Ed25519 signature", a.Name)
+		}
+		key := a.Platform + "/" + a.Arch + "/" + a.Format
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate asset for %s", key)
+		}
+		seen[key] = true
+		assets = append(assets, a)
+	}
+	return assets, nil
+}
+
+// replaceReleaseAssets swaps the stored assets of a release for the given set.
+func replaceReleaseAssets(tx *gorm.DB, versionID int, assets []ReleaseAsset) error {
+	if err := tx.Where("version_id = ?", versionID).Delete(&ReleaseAsset{}).Error; err != nil {
+		return err
+	}
+	if len(assets) == 0 {
+		return nil
+	}
+	now := nowUTC()
+	for i := range assets {
+		assets[i].ID = 0
+		assets[i].VersionID = versionID
+		assets[i].CreatedAt = now
+	}
+	return tx.Create(&assets).Error
+}
+
+// loadReleaseAssets returns the assets of the given releases keyed by version ID.
+func loadReleaseAssets(db *gorm.DB, versionIDs []int) (map[int][]ReleaseAsset, error) {
+	byVersion := make(map[int][]ReleaseAsset, len(versionIDs))
+	if len(versionIDs) == 0 {
+		return byVersion, nil
+	}
+	var assets []ReleaseAsset
+	if err := db.Where("version_id IN ?", versionIDs).Order("platform, arch, format").Find(&assets).Error; err != nil {
+		return nil, err
+	}
+	for _, a := range assets {
+		byVersion[a.VersionID] = append(byVersion[a.VersionID], a)
+	}
+	return byVersion, nil
+}
+
+// attachReleaseAssets fills in the Assets of each release.
+func attachReleaseAssets(db *gorm.DB, versions []AppVersion) error {
+	ids := make([]int, 0, len(versions))
+	for _, v := range versions {
+		ids = append(ids, v.ID)
+	}
+	byVersion, err := loadReleaseAssets(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range versions {
+		versions[i].Assets = byVersion[versions[i].ID]
+	}
+	return nil
+}
+
+// legacyAndroidAsset exposes the android_download_url column of releases
+// announced before assets existed as an arm64 APK asset.
+func legacyAndroidAsset(v *AppVersion) *ReleaseAsset {
+	if v.AndroidDownloadURL == "" {
+		return nil
+	}
+	return &ReleaseAsset{VersionID: v.ID, Platform: "android", Arch: archARM64, Format: "apk", URL: v.AndroidDownloadURL}
+}
+
+// selectReleaseAsset picks the asset a device can install. An explicit arch or
+// package format must match exactly; otherwise architecture-independent
+// assets, the platform's default architecture and its preferred formats win.
+func selectReleaseAsset(assets []ReleaseAsset, platform, arch, format string) *ReleaseAsset {
+	platform = normalizePlatform(platform)
+	arch = normalizeArch(arch)
+	format = normalizeFormat(format)
+
+	var best *ReleaseAsset
+	bestScore := -1
+	for i := range assets {
+		a := &assets[i]
+		if a.Platform != platform || (format != "" && a.Format != format) {
+			continue
+		}
+		score := 0
+		switch {
+		case a.Arch == "" || a.Arch == archUniversal:
+			score += 10
+		case arch != "" && a.Arch == arch:
+			score += 20
+		case arch != "":
+			continue
+		case a.Arch == defaultArch[platform]:
+			score += 5
+		}
+		formats := preferredFormats[platform]
+		for rank, f := range formats {
+			if a.Format == f {
+				score += len(formats) - rank
+				break
+			}
+		}
+		if score > bestScore {
+			best, bestScore = a, score
+		}
+	}
+	return best
+}
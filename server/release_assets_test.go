@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyReleaseAsset(t *testing.T) {
+	tests := []struct {
+		name, platform, arch, format string
+	}{
+		{"pt_mate-2.29.0-arm64-v8a.apk", "android", "arm64", "apk"},
+		{"pt_mate-2.29.0-armeabi-v7a.apk", "android", "arm", "apk"},
+		{"pt_mate-2.29.0-windows-x64.exe", "windows", "x86_64", "exe"},
+		{"pt_mate-2.29.0-linux-x64.AppImage", "linux", "x86_64", "appimage"},
+		{"pt_mate-2.29.0-linux-aarch64.tar.gz", "linux", "arm64", "tar.gz"},
+		{"pt_mate-2.29.0-macos-x64.dmg", "macos", "x86_64", "dmg"},
+		{"pt_mate-2.29.0-macos-x64.app.zip", "macos", "x86_64", "app.zip"},
+		{"pt_mate-2.29.0-macos-universal.dmg", "macos", "universal", "dmg"},
+		{"pt_mate-2.29.0-unsigned.ipa", "ios", "", "ipa"},
+		{"pt_mate-2.29.0.xcarchive.zip", "", "", ""},
+		{"checksums.txt", "", "", ""},
+	}
+	for _, tt := range tests {
+		platform, arch, format := classifyReleaseAsset(tt.name)
+		assert.Equal(t, tt.platform, platform, tt.name)
+		assert.Equal(t, tt.arch, arch, tt.name)
+		assert.Equal(t, tt.format, format, tt.name)
+	}
+}
+
+func TestSelectReleaseAsset(t *testing.T) {
+	assets := []ReleaseAsset{
+		{ID: 1, Platform: "android", Arch: "arm64", Format: "apk"},
+		{ID: 2, Platform: "android", Arch: "arm", Format: "apk"},
+		{ID: 3, Platform: "windows", Arch: "x86_64", Format: "msi"},
+		{ID: 4, Platform: "windows", Arch: "x86_64", Format: "exe"},
+		{ID: 5, Platform: "linux", Arch: "x86_64", Format: "appimage"},
+		{ID: 6, Platform: "linux", Arch: "x86_64", Format: "deb"},
+		{ID: 7, Platform: "macos", Arch: "universal", Format: "dmg"},
+	}
+
+	tests := []struct {
+		platform, arch, format string
+		want                   int
+	}{
+		{"android", "", "", 1},
+		{"Android", "armeabi-v7a", "", 2},
+		{"android", "aarch64", "apk", 1},
+		{"android", "x86_64", "", 0},
+		{"windows", "", "", 4},
+		{"windows", "amd64", "msi", 3},
+		{"linux", "x64", "", 5},
+		{"linux", "", "deb", 6},
+		{"linux", "", "rpm", 0},
+		{"macos", "arm64", "", 7},
+		{"ios", "", "", 0},
+	}
+	for _, tt := range tests {
+		got := selectReleaseAsset(assets, tt.platform, tt.arch, tt.format)
+		if tt.want == 0 {
+			assert.Nil(t, got, "%s/%s/%s", tt.platform, tt.arch, tt.format)
+			continue
+		}
+		require.NotNil(t, got, "%s/%s/%s", tt.platform, tt.arch, tt.format)
+		assert.Equal(t, tt.want, got.ID, "%s/%s/%s", tt.platform, tt.arch, tt.format)
+	}
+}
+
+func TestBuildReleaseAssets(t *testing.T) {
+	assets, err := buildReleaseAssets([]ReleaseAssetInput{
+		{Name: "pt_mate-2.29.0-arm64-v8a.apk", URL: "https://dl/a.apk", Size: 10, SHA256: "AB"},
+	})
+	assert.Error(t, err, "sha256 must be hex of the right length")
+	assert.Nil(t, assets)
+
+	assets, err = buildReleaseAssets([]ReleaseAssetInput{
+		{Name: "pt_mate-2.29.0-arm64-v8a.apk", URL: "https://dl/a.apk", Size: 10},
+		{Platform: "Windows", Arch: "x64", Format: ".EXE", URL: "https://dl/setup.exe"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []ReleaseAsset{
+		{Platform: "android", Arch: "arm64", Format: "apk", Name: "pt_mate-2.29.0-arm64-v8a.apk", URL: "https://dl/a.apk", Size: 10},
+		{Platform: "windows", Arch: "x86_64", Format: "exe", URL: "https://dl/setup.exe"},
+	}, assets)
+
+	_, err = buildReleaseAssets([]ReleaseAssetInput{
+		{Name: "a-arm64.apk", URL: "https://dl/1.apk"},
+		{Name: "b-aarch64.apk", URL: "https://dl/2.apk"},
+	})
+	assert.Error(t, err, "duplicate target")
+
+	_, err = buildReleaseAssets([]ReleaseAssetInput{{Name: "notes.txt", URL: "https://dl/notes.txt"}})
+	assert.Error(t, err, "unknown platform")
+}
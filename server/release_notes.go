@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultReleaseNotesLocale is the language of app_versions.release_notes,
+// configured with RELEASE_NOTES_DEFAULT_LOCALE.
+func defaultReleaseNotesLocale() string {
+	if l := normalizeLocale(os.Getenv("RELEASE_NOTES_DEFAULT_LOCALE")); l != "" {
+		return l
+	}
+	return "zh-CN"
+}
+
+var (
+	localeLanguagePattern = regexp.MustCompile(`^[a-z]{2,3}$`)
+	localeScriptPattern   = regexp.MustCompile(`^[a-z]{4}$`)
+	localeRegionPattern   = regexp.MustCompile(`^([a-z]{2}|[0-9]{3})$`)
+)
+
+// normalizeLocale canonicalizes the language, script and region subtags of a
+// BCP 47 or POSIX style tag, e.g. "zh_hant_tw" becomes "zh-Hant-TW" and
+// "en-US.UTF-8" becomes "en-US". It returns "" when the tag is not usable.
+func normalizeLocale(tag string) string {
+	tag = strings.TrimSpace(tag)
+	if i := strings.IndexAny(tag, ".@"); i >= 0 {
+		tag = tag[:i]
+	}
+	parts := strings.FieldsFunc(strings.ToLower(tag), func(r rune) bool { return r == '-' || r == '_' })
+	if len(parts) == 0 || !localeLanguagePattern.MatchString(parts[0]) {
+		return ""
+	}
+	out := []string{parts[0]}
+	rest := parts[1:]
+	if len(rest) > 0 && localeScriptPattern.MatchString(rest[0]) {
+		out = append(out, strings.ToUpper(rest[0][:1])+rest[0][1:])
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && localeRegionPattern.MatchString(rest[0]) {
+		out = append(out, strings.ToUpper(rest[0]))
+	}
+	return strings.Join(out, "-")
+}
+
+// splitLocale returns the subtags of a normalized locale.
+func splitLocale(locale string) (language, script, region string) {
+	parts := strings.Split(locale, "-")
+	language = parts[0]
+	for _, p := range parts[1:] {
+		if len(p) == 4 {
+			script = p
+		} else {
+			region = p
+		}
+	}
+	return language, script, region
+}
+
+// localeScript returns the explicit script of a locale or, for Chinese, the
+// one implied by its region.
+func localeScript(locale string) string {
+	language, script, region := splitLocale(locale)
+	if script != "" || language != "zh" {
+		return script
+	}
+	switch region {
+	case "TW", "HK", "MO":
+		return "Hant"
+	}
+	return "Hans"
+}
+
+// localeFallbacks lists the tags to try for a locale, most specific first:
+// "zh-TW" tries "zh-TW", "zh-Hant-TW", "zh-Hant" and finally "zh".
+func localeFallbacks(locale string) []string {
+	language, _, region := splitLocale(locale)
+	script := localeScript(locale)
+	var tags []string
+	add := func(t string) {
+		for _, existing := range tags {
+			if existing == t {
+				return
+			}
+		}
+		tags = append(tags, t)
+	}
+	add(locale)
+	if script != "" && region != "" {
+		add(language + "-" + script + "-" + region)
+	}
+	if region != "" {
+		add(language + "-" + region)
+	}
+	if script != "" {
+		add(language + "-" + script)
+	}
+	add(language)
+	return tags
+}
+
+// parseAcceptLanguage returns the locales of an Accept-Language header ordered
+// by quality, skipping wildcards and q=0 entries.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		locale string
+		q      float64
+	}
+	var entries []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		locale := normalizeLocale(fields[0])
+		if locale == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			entries = append(entries, weighted{locale, q})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].q > entries[j].q })
+	locales := make([]string, 0, len(entries))
+	for _, e := range entries {
+		locales = append(locales, e.locale)
+	}
+	return locales
+}
+
+// matchLocale picks the available locale that best serves the wanted ones,
+// which are ordered by preference. Each wanted locale is tried against its
+// fallback chain and then against any available locale of the same language
+// and script before moving on to the next one. It returns "" when nothing fits.
+func matchLocale(available []string, wanted []string) string {
+	if len(available) == 0 {
+		return ""
+	}
+	sorted := append([]string(nil), available...)
+	sort.Strings(sorted)
+	has := make(map[string]bool, len(sorted))
+	for _, a := range sorted {
+		has[a] = true
+	}
+	for _, w := range wanted {
+		for _, candidate := range localeFallbacks(w) {
+			if has[candidate] {
+				return candidate
+			}
+		}
+		language, _, _ := splitLocale(w)
+		script := localeScript(w)
+		for _, a := range sorted {
+			l, _, _ := splitLocale(a)
+			if l == language && (script == "" || localeScript(a) == "" || localeScript(a) == script) {
+				return a
+			}
+		}
+	}
+	return ""
+}
+
+// requestedLocales returns the locales a client asked for: the explicit locale
+// of the request first, then the Accept-Language header.
+func requestedLocales(explicit, acceptLanguage string) []string {
+	var locales []string
+	if l := normalizeLocale(explicit); l != "" {
+		locales = append(locales, l)
+	}
+	return append(locales, parseAcceptLanguage(acceptLanguage)...)
+}
+
+// localizeReleaseNotes returns the notes of v in the best matching locale and
+// that locale. The default notes are used when no translation fits.
+func localizeReleaseNotes(v *AppVersion, translations map[string]string, wanted []string) (string, string) {
+	defaultLocale := defaultReleaseNotesLocale()
+	available := make([]string, 0, len(translations)+1)
+	for locale := range translations {
+		available = append(available, locale)
+	}
+	if _, ok := translations[defaultLocale]; !ok && v.ReleaseNotes != "" {
+		available = append(available, defaultLocale)
+	}
+	locale := matchLocale(available, wanted)
+	if notes, ok := translations[locale]; ok {
+		return notes, locale
+	}
+	return v.ReleaseNotes, defaultLocale
+}
+
+// buildReleaseNoteTranslations validates a locale to notes map from the
+// webhook or admin API. Keys are normalized; empty notes are kept so that
+// callers can treat them as deletions.
+func buildReleaseNoteTranslations(in map[string]string) (map[string]string, error) {
+	out := make(map[string]string, len(in))
+	for tag, notes := range in {
+		locale := normalizeLocale(tag)
+		if locale == "" {
+			return nil, fmt.Errorf("invalid release notes locale %q", tag)
+		}
+		if _, dup := out[locale]; dup {
+			return nil, fmt.Errorf("duplicate release notes locale %s", locale)
+		}
+		out[locale] = strings.TrimSpace(notes)
+	}
+	return out, nil
+}
+
+// mergeReleaseNotes stores the given translations of a release, leaving other
+// locales untouched. Empty notes delete the translation.
+func mergeReleaseNotes(tx *gorm.DB, versionID int, translations map[string]string) error {
+	locales := make([]string, 0, len(translations))
+	for locale := range translations {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	now := nowUTC()
+	for _, locale := range locales {
+		notes := translations[locale]
+		if notes == "" {
+			if err := tx.Where("version_id = ? AND locale = ?", versionID, locale).Delete(&ReleaseNote{}).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		note := ReleaseNote{VersionID: versionID, Locale: locale, Notes: notes, CreatedAt: now, UpdatedAt: now}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "version_id"}, {Name: "locale"}},
+			DoUpdates: clause.AssignmentColumns([]string{"notes", "updated_at"}),
+		}).Create(&note).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadReleaseNotes returns the translations of the given releases keyed by
+// version ID and locale.
+func loadReleaseNotes(db *gorm.DB, versionIDs []int) (map[int]map[string]string, error) {
+	byVersion := make(map[int]map[string]string, len(versionIDs))
+	if len(versionIDs) == 0 {
+		return byVersion, nil
+	}
+	var notes []ReleaseNote
+	if err := db.Where("version_id IN ?", versionIDs).Find(&notes).Error; err != nil {
+		return nil, err
+	}
+	for _, n := range notes {
+		if byVersion[n.VersionID] == nil {
+			byVersion[n.VersionID] = make(map[string]string)
+		}
+		byVersion[n.VersionID][n.Locale] = n.Notes
+	}
+	return byVersion, nil
+}
+
+// attachReleaseNotes fills in the ReleaseNotesI18n of each release.
+func attachReleaseNotes(db *gorm.DB, versions []AppVersion) error {
+	ids := make([]int, 0, len(versions))
+	for _, v := range versions {
+		ids = append(ids, v.ID)
+	}
+	byVersion, err := loadReleaseNotes(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range versions {
+		versions[i].ReleaseNotesI18n = byVersion[versions[i].ID]
+	}
+	return nil
+}
+
+// releaseNotesMarker starts a translated section in a GitHub release body,
+// e.g. "<!-- release-notes:en -->".
+var releaseNotesMarker = regexp.MustCompile(`(?m)^[ \t]*<!--\s*release-notes:\s*([A-Za-z0-9_-]+)\s*-->[ \t]*\r?$`)
+
+// splitReleaseNotes separates a release body into the default notes, which
+// precede the first marker, and the translated sections that follow markers.
+// Sections with an invalid locale stay part of the preceding text.
+func splitReleaseNotes(body string) (string, map[string]string) {
+	matches := releaseNotesMarker.FindAllStringSubmatchIndex(body, -1)
+	translations := make(map[string]string)
+	var valid [][]int
+	for _, m := range matches {
+		if normalizeLocale(body[m[2]:m[3]]) != "" {
+			valid = append(valid, m)
+		}
+	}
+	if len(valid) == 0 {
+		return body, translations
+	}
+	for i, m := range valid {
+		end := len(body)
+		if i+1 < len(valid) {
+			end = valid[i+1][0]
+		}
+		translations[normalizeLocale(body[m[2]:m[3]])] = strings.TrimSpace(body[m[1]:end])
+	}
+	return strings.TrimSpace(body[:valid[0][0]]), translations
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeLocale(t *testing.T) {
+	tests := map[string]string{
+		"zh_cn":        "zh-CN",
+		"zh-hant-tw":   "zh-Hant-TW",
+		"en-US.UTF-8":  "en-US",
+		"EN":           "en",
+		"es-419":       "es-419",
+		"de-DE-1996":   "de-DE",
+		" ja ":         "ja",
+		"":             "",
+		"*":            "",
+		"english":      "",
+		"x-klingon-01": "",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, normalizeLocale(in), in)
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	got := parseAcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5, ja;q=0")
+	assert.Equal(t, []string{"fr-CH", "fr", "en", "de"}, got)
+	assert.Empty(t, parseAcceptLanguage(""))
+}
+
+func TestMatchLocale(t *testing.T) {
+	available := []string{"en", "zh-CN", "zh-Hant", "ja-JP"}
+	tests := []struct {
+		wanted []string
+		want   string
+	}{
+		{[]string{"en-GB"}, "en"},
+		{[]string{"zh-TW"}, "zh-Hant"},
+		{[]string{"zh-HK"}, "zh-Hant"},
+		{[]string{"zh-Hans-CN"}, "zh-CN"},
+		{[]string{"zh"}, "zh-CN"},
+		{[]string{"ja"}, "ja-JP"},
+		// Language preference beats an exact match further down the list
+		{[]string{"fr-CA", "en-US"}, "en"},
+		{[]string{"ko", "de"}, ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchLocale(available, tt.wanted), "%v", tt.wanted)
+	}
+	assert.Equal(t, "", matchLocale([]string{"zh-CN"}, []string{"zh-TW"}), "scripts must agree")
+}
+
+func TestLocalizeReleaseNotes(t *testing.T) {
+	t.Setenv("RELEASE_NOTES_DEFAULT_LOCALE", "zh_CN")
+	v := &AppVersion{ReleaseNotes: "修复问题"}
+	translations := map[string]string{"en": "Bug fixes"}
+
+	notes, locale := localizeReleaseNotes(v, translations, []string{"en-US"})
+	assert.Equal(t, "Bug fixes", notes)
+	assert.Equal(t, "en", locale)
+
+	// The default notes are a candidate in their own locale
+	notes, locale = localizeReleaseNotes(v, translations, []string{"zh-CN", "en"})
+	assert.Equal(t, "修复问题", notes)
+	assert.Equal(t, "zh-CN", locale)
+
+	notes, locale = localizeReleaseNotes(v, translations, []string{"ko"})
+	assert.Equal(t, "修复问题", notes)
+	assert.Equal(t, "zh-CN", locale)
+}
+
+func TestBuildReleaseNoteTranslations(t *testing.T) {
+	got, err := buildReleaseNoteTranslations(map[string]string{"en_US": " Fixes ", "ja": ""})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"en-US": "Fixes", "ja": ""}, got)
+
+	_, err = buildReleaseNoteTranslations(map[string]string{"english": "Fixes"})
+	assert.Error(t, err)
+	_, err = buildReleaseNoteTranslations(map[string]string{"en-us": "a", "en_US": "b"})
+	assert.Error(t, err)
+}
+
+func TestSplitReleaseNotes(t *testing.T) {
+	body := "## 更新内容\n- 修复问题\n\n<!-- release-notes:en -->\n## What's new\n- Bug fixes\n<!-- release-notes: zh_TW -->\n- 修復問題\n"
+	notes, translations := splitReleaseNotes(body)
+	assert.Equal(t, "## 更新内容\n- 修复问题", notes)
+	assert.Equal(t, map[string]string{
+		"en":    "## What's new\n- Bug fixes",
+		"zh-TW": "- 修復問題",
+	}, translations)
+
+	notes, translations = splitReleaseNotes("plain body")
+	assert.Equal(t, "plain body", notes)
+	assert.Empty(t, translations)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolloutBucketIsDeterministic(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		id := fmt.Sprintf("device-%d", i)
+		b := rolloutBucket(id, "2.30.0")
+		assert.Equal(t, b, rolloutBucket(id, "2.30.0"))
+		assert.GreaterOrEqual(t, b, 0)
+		assert.Less(t, b, rolloutBuckets)
+	}
+}
+
+func TestRolloutBucketDistribution(t *testing.T) {
+	offered := 0
+	v := &AppVersion{Version: "2.30.0", RolloutPercent: 20}
+	for i := 0; i < 10000; i++ {
+		if inRollout(v, fmt.Sprintf("device-%d", i), time.Now()) {
+			offered++
+		}
+	}
+	assert.InDelta(t, 2000, offered, 300)
+}
+
+func TestEffectiveRolloutPercent(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	started := now.Add(-12 * time.Hour)
+
+	tests := []struct {
+		name     string
+		version  AppVersion
+		expected int
+	}{
+		{"Full", AppVersion{RolloutPercent: 100}, 100},
+		{"Fixed", AppVersion{RolloutPercent: 10}, 10},
+		{"Paused", AppVersion{RolloutPercent: 50, RolloutPaused: true}, 0},
+		{"Ramp Halfway", AppVersion{RolloutPercent: 0, RolloutRampHours: 24, RolloutStartedAt: &started}, 50},
+		{"Ramp Done", AppVersion{RolloutPercent: 10, RolloutRampHours: 6, RolloutStartedAt: &started}, 100},
+		{"Ramp Without Start", AppVersion{RolloutPercent: 10, RolloutRampHours: 6}, 10},
+		{"Out Of Range", AppVersion{RolloutPercent: 150}, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, effectiveRolloutPercent(&tt.version, now))
+		})
+	}
+}
+
+func TestApplyRolloutUpdatePauseResumeKeepsTarget(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	started := now.Add(-12 * time.Hour)
+	v := AppVersion{RolloutPercent: 20, RolloutRampHours: 24, RolloutStartedAt: &started}
+	paused, resumed := true, false
+
+	assert.Equal(t, 60, effectiveRolloutPercent(&v, now))
+	applyRolloutUpdate(&v, AdminUpdateVersionRequest{RolloutPaused: &paused}, now)
+	assert.True(t, v.RolloutPaused)
+	assert.Equal(t, 20, v.RolloutPercent)
+	assert.Equal(t, now, *v.RolloutPausedAt)
+	assert.Equal(t, 0, effectiveRolloutPercent(&v, now))
+
+	later := now.Add(48 * time.Hour)
+	applyRolloutUpdate(&v, AdminUpdateVersionRequest{RolloutPaused: &resumed}, later)
+	assert.False(t, v.RolloutPaused)
+	assert.Nil(t, v.RolloutPausedAt)
+	assert.Equal(t, 20, v.RolloutPercent)
+	assert.Equal(t, 24, v.RolloutRampHours)
+	assert.Equal(t, 60, effectiveRolloutPercent(&v, later))
+	assert.Equal(t, 100, effectiveRolloutPercent(&v, later.Add(12*time.Hour)))
+}
+
+func TestApplyRolloutUpdateResumeWithoutPausedAtRestartsRamp(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	started := now.Add(-12 * time.Hour)
+	v := AppVersion{RolloutPercent: 20, RolloutRampHours: 24, RolloutStartedAt: &started, RolloutPaused: true}
+	resumed := false
+
+	applyRolloutUpdate(&v, AdminUpdateVersionRequest{RolloutPaused: &resumed}, now)
+	assert.Equal(t, now, *v.RolloutStartedAt)
+	assert.Equal(t, 20, v.RolloutPercent)
+	assert.Equal(t, 20, effectiveRolloutPercent(&v, now))
+}
+
+func TestApplyRolloutUpdateChangeWhilePausedStartsRampOnResume(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	started := now.Add(-12 * time.Hour)
+	v := AppVersion{RolloutPercent: 20, RolloutRampHours: 24, RolloutStartedAt: &started}
+	paused, resumed, percent := true, false, 40
+
+	applyRolloutUpdate(&v, AdminUpdateVersionRequest{RolloutPaused: &paused}, now)
+	changed := now.Add(time.Hour)
+	applyRolloutUpdate(&v, AdminUpdateVersionRequest{RolloutPercent: &percent}, changed)
+	assert.True(t, v.RolloutPaused)
+
+	later := now.Add(48 * time.Hour)
+	applyRolloutUpdate(&v, AdminUpdateVersionRequest{RolloutPaused: &resumed}, later)
+	assert.Equal(t, later, *v.RolloutStartedAt)
+	assert.Equal(t, 40, effectiveRolloutPercent(&v, later))
+}
+
+func TestApplyRolloutUpdateUnchangedValuesKeepRampClock(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	started := now.Add(-12 * time.Hour)
+	v := AppVersion{RolloutPercent: 10, RolloutRampHours: 24, RolloutStartedAt: &started}
+	percent, ramp := 10, 24
+
+	applyRolloutUpdate(&v, AdminUpdateVersionRequest{RolloutPercent: &percent, RolloutRampHours: &ramp}, now)
+	assert.Equal(t, started, *v.RolloutStartedAt)
+}
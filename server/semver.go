@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// semVersion is a version parsed according to Semantic Versioning 2.0.0.
+// Parsing is lenient to match what clients report: a leading "v" is accepted,
+// missing minor/patch parts default to 0 and extra numeric components
+// (1.0.0.1) take part in the comparison.
+type semVersion struct {
+	core       []int
+	prerelease []string
+	build      string
+}
+
+func parseSemVersion(s string) semVersion {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+
+	var v semVersion
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		v.build = s[i+1:]
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		if pre := s[i+1:]; pre != "" {
+			v.prerelease = strings.Split(pre, ".")
+		}
+		s = s[:i]
+	}
+	for _, part := range strings.Split(s, ".") {
+		n, _ := parseVersionNumber(part)
+		v.core = append(v.core, n)
+	}
+	for len(v.core) < 3 {
+		v.core = append(v.core, 0)
+	}
+	return v
+}
+
+// semverPattern matches the versions parseSemVersion understands: an optional
+// leading "v", numeric components, and dot-separated pre-release and build
+// identifiers.
+var semverPattern = regexp.MustCompile(`^[vV]?[0-9]+(\.[0-9]+)*(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+// parseSemver parses s like parseSemVersion, but rejects anything that is not
+// a version, for values that are compared against every client later on.
+func parseSemver(s string) (semVersion, error) {
+	s = strings.TrimSpace(s)
+	if !semverPattern.MatchString(s) {
+		return semVersion{}, fmt.Errorf("invalid version %q", s)
+	}
+	return parseSemVersion(s), nil
+}
+
+// compareSemVersion returns -1, 0 or 1 following semver precedence rules.
+// Build metadata does not affect precedence.
+func compareSemVersion(a, b semVersion) int {
+	n := len(a.core)
+	if len(b.core) > n {
+		n = len(b.core)
+	}
+	for i := 0; i < n; i++ {
+		var x, y int
+		if i < len(a.core) {
+			x = a.core[i]
+		}
+		if i < len(b.core) {
+			y = b.core[i]
+		}
+		if x != y {
+			return compareInt(x, y)
+		}
+	}
+
+	// A pre-release has lower precedence than the associated normal version
+	switch {
+	case len(a.prerelease) == 0 && len(b.prerelease) == 0:
+		return 0
+	case len(a.prerelease) == 0:
+		return 1
+	case len(b.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(a.prerelease) && i < len(b.prerelease); i++ {
+		if c := comparePrereleaseIdentifier(a.prerelease[i], b.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(a.prerelease), len(b.prerelease))
+}
+
+// comparePrereleaseIdentifier compares numeric identifiers numerically and
+// alphanumeric ones in ASCII order; numeric identifiers sort first.
+func comparePrereleaseIdentifier(a, b string) int {
+	aNum, bNum := isNumericIdentifier(a), isNumericIdentifier(b)
+	switch {
+	case aNum && bNum:
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			return compareInt(len(a), len(b))
+		}
+		return strings.Compare(a, b)
+	case aNum:
+		return -1
+	case bNum:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func isNumericIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareVersionStrings compares two version strings by semver precedence.
+func compareVersionStrings(a, b string) int {
+	return compareSemVersion(parseSemVersion(a), parseSemVersion(b))
+}
+
+// versionOrderSQL orders app_versions rows by the numeric part of their
+// version, highest first, then releases before pre-releases of the same
+// numbers. It is as far as SQL gets to semver precedence, so a LIMIT on it
+// never cuts off the highest version; sortVersionsDesc finishes the job.
+const versionOrderSQL = `string_to_array(substring(version from '^[vV]*([0-9]+(\.[0-9]+)*)'), '.')::bigint[] DESC NULLS LAST, position('-' in version) = 0 DESC`
+
+// sortVersionsDesc orders releases newest version first. Releases with equal
+// precedence keep their relative order.
+func sortVersionsDesc(versions []AppVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return compareVersionStrings(versions[i].Version, versions[j].Version) > 0
+	})
+}
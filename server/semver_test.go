@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSemVersion(t *testing.T) {
+	v := parseSemVersion("v2.29.0-beta.10+build.5")
+	assert.Equal(t, []int{2, 29, 0}, v.core)
+	assert.Equal(t, []string{"beta", "10"}, v.prerelease)
+	assert.Equal(t, "build.5", v.build)
+
+	short := parseSemVersion("2.1")
+	assert.Equal(t, []int{2, 1, 0}, short.core)
+	assert.Empty(t, short.prerelease)
+}
+
+func TestParseSemverRejectsNonVersions(t *testing.T) {
+	for _, s := range []string{"2.30.0", " v2.30 ", "2.29.0-beta.10+build.5", "1.0.0.1"} {
+		_, err := parseSemver(s)
+		assert.NoError(t, err, s)
+	}
+	for _, s := range []string{"", "   ", "latest", "2.30.x", "2..0", "2.30.0-", "2.30.0+"} {
+		_, err := parseSemver(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestCompareVersionStringsPrecedenceChain(t *testing.T) {
+	// Example ordering from the Semantic Versioning 2.0.0 specification
+	chain := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0",
+	}
+	for i := 0; i+1 < len(chain); i++ {
+		assert.Equal(t, -1, compareVersionStrings(chain[i], chain[i+1]), "%s < %s", chain[i], chain[i+1])
+		assert.Equal(t, 1, compareVersionStrings(chain[i+1], chain[i]), "%s > %s", chain[i+1], chain[i])
+	}
+	assert.Equal(t, 0, compareVersionStrings("1.0.0+a", "v1.0.0+b"))
+}
+
+func TestSortVersionsDescIgnoresCreationOrder(t *testing.T) {
+	// Candidates as returned by the database, most recently created first:
+	// 2.28.0 was re-posted after 2.29.0 was released.
+	versions := []AppVersion{
+		{Version: "2.28.0"},
+		{Version: "2.29.0"},
+		{Version: "2.29.0-rc.1"},
+		{Version: "2.29.0-beta.10"},
+		{Version: "2.29.0-beta.2"},
+	}
+
+	sortVersionsDesc(versions)
+
+	got := make([]string, 0, len(versions))
+	for _, v := range versions {
+		got = append(got, v.Version)
+	}
+	assert.Equal(t, []string{"2.29.0", "2.29.0-rc.1", "2.29.0-beta.10", "2.29.0-beta.2", "2.28.0"}, got)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpdateManifest is the signed statement of what a device should install.
+// Clients verify the signature before trusting any of these fields.
+type UpdateManifest struct {
+	Version     string `json:"version"`
+	Platform    string `json:"platform"`
+	Arch        string `json:"arch,omitempty"`
+	Format      string `json:"format,omitempty"`
+	DownloadURL string `json:"download_url"`
+	Size        int64  `json:"size,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	ForceUpdate bool   `json:"force_update"`
+	MinVersion  string `json:"min_version,omitempty"`
+	IssuedAt    int64  `json:"issued_at"`
+}
+
+// ManifestSignature is an Ed25519 signature over the raw manifest bytes.
+type ManifestSignature struct {
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"`
+}
+
+type updateSigningKey struct {
+	id         string
+	privateKey ed25519.PrivateKey
+}
+
+// updateSigner signs manifests with every configured key so that clients
+// trusting either the outgoing or the incoming key keep working during rotation.
+type updateSigner struct {
+	keys []updateSigningKey
+}
+
+// loadUpdateSigner reads UPDATE_SIGNING_KEYS, a comma-separated list of
+// "<key id>:<base64 Ed25519 seed>" entries. The key id may be omitted, in which
+// case it is derived from the public key. Returns nil when signing is disabled.
+func loadUpdateSigner() (*updateSigner, error) {
+	return parseUpdateSigningKeys(os.Getenv("UPDATE_SIGNING_KEYS"))
+}
+
+func parseUpdateSigningKeys(spec string) (*updateSigner, error) {
+	var signer updateSigner
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded := "", entry
+		if i := strings.LastIndexByte(entry, ':'); i >= 0 {
+			id, encoded = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		}
+		seed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("update signing key %q: expected a base64 encoded %d-byte Ed25519 seed", id, ed25519.SeedSize)
+		}
+		key := ed25519.NewKeyFromSeed(seed)
+		if id == "" {
+			id = defaultKeyID(key.Public().(ed25519.PublicKey))
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate update signing key id %q", id)
+		}
+		seen[id] = true
+		signer.keys = append(signer.keys, updateSigningKey{id: id, privateKey: key})
+	}
+	if len(signer.keys) == 0 {
+		return nil, nil
+	}
+	return &signer, nil
+}
+
+// defaultKeyID is the first 8 hex digits of the SHA-256 of the public key.
+func defaultKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:4])
+}
+
+// sign serializes the manifest and returns it base64 encoded together with one
+// signature per key. Signatures cover the decoded bytes, so clients never need
+// to re-serialize JSON to verify them.
+func (s *updateSigner) sign(m UpdateManifest) (string, []ManifestSignature, error) {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return "", nil, err
+	}
+	sigs := make([]ManifestSignature, 0, len(s.keys))
+	for _, k := range s.keys {
+		sigs = append(sigs, ManifestSignature{
+			KeyID:     k.id,
+			Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(k.privateKey, payload)),
+		})
+	}
+	return base64.StdEncoding.EncodeToString(payload), sigs, nil
+}
+
+// newUpdateManifest describes the update offered in response.
+func newUpdateManifest(response CheckUpdateResponse, platform string, now time.Time) UpdateManifest {
+	m := UpdateManifest{
+		Version:     response.LatestVersion,
+		Platform:    normalizePlatform(platform),
+		DownloadURL: response.DownloadURL,
+		ForceUpdate: response.ForceUpdate,
+		MinVersion:  response.MinVersion,
+		IssuedAt:    now.Unix(),
+	}
+	if a := response.Asset; a != nil {
+		m.Arch = a.Arch
+		m.Format = a.Format
+		m.Size = a.Size
+		m.SHA256 = a.SHA256
+	}
+	return m
+}
+
+// manifestVerifiable reports whether a client can check the file of the
+// update offered in response. Signing a manifest without the digest and size
+// of an asset would vouch for a download nobody verifies.
+func manifestVerifiable(response CheckUpdateResponse) bool {
+	a := response.Asset
+	return a != nil && a.SHA256 != "" && a.Size > 0
+}
+
+// UpdateKeys lists the public keys currently used to sign update manifests.
+// GET /api/v1/update-keys
+func (s *AppService) UpdateKeys(c *gin.Context) {
+	keys := []gin.H{}
+	if s.signer != nil {
+		for _, k := range s.signer.keys {
+			keys = append(keys, gin.H{
+				"key_id":     k.id,
+				"algorithm":  "ed25519",
+				"public_key": base64.StdEncoding.EncodeToString(k.privateKey.Public().(ed25519.PublicKey)),
+			})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
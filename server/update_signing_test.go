@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSeed(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), ed25519.SeedSize)))
+}
+
+func TestParseUpdateSigningKeys(t *testing.T) {
+	signer, err := parseUpdateSigningKeys("")
+	require.NoError(t, err)
+	assert.Nil(t, signer)
+
+	signer, err = parseUpdateSigningKeys(" 2026a:" + testSeed('a') + " , " + testSeed('b'))
+	require.NoError(t, err)
+	require.Len(t, signer.keys, 2)
+	assert.Equal(t, "2026a", signer.keys[0].id)
+	assert.Equal(t, defaultKeyID(signer.keys[1].privateKey.Public().(ed25519.PublicKey)), signer.keys[1].id)
+	assert.Len(t, signer.keys[1].id, 8)
+
+	_, err = parseUpdateSigningKeys("k1:not-base64")
+	assert.Error(t, err)
+	_, err = parseUpdateSigningKeys("k1:" + base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+	_, err = parseUpdateSigningKeys("k1:" + testSeed('a') + ",k1:" + testSeed('b'))
+	assert.Error(t, err)
+}
+
+func TestUpdateSignerSign(t *testing.T) {
+	signer, err := parseUpdateSigningKeys("old:" + testSeed('a') + ",new:" + testSeed('b'))
+	require.NoError(t, err)
+
+	response := CheckUpdateResponse{
+		HasUpdate:     true,
+		LatestVersion: "2.30.0",
+		DownloadURL:   "https://dl/app.apk",
+		MinVersion:    "2.20.0",
+		Asset:         &UpdateAsset{Platform: "android", Arch: "arm64", Format: "apk", URL: "https://dl/app.apk", Size: 42, SHA256: strings.Repeat("ab", 32)},
+	}
+	issued := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	encoded, sigs, err := signer.sign(newUpdateManifest(response, "Android", issued))
+	require.NoError(t, err)
+
+	payload, err := base64.StdEncoding.DecodeString(encoded)
+	require.NoError(t, err)
+	var m UpdateManifest
+	require.NoError(t, json.Unmarshal(payload, &m))
+	assert.Equal(t, UpdateManifest{
+		Version:     "2.30.0",
+		Platform:    "android",
+		Arch:        "arm64",
+		Format:      "apk",
+		DownloadURL: "https://dl/app.apk",
+		Size:        42,
+		SHA256:      strings.Repeat("ab", 32),
+		MinVersion:  "2.20.0",
+		IssuedAt:    issued.Unix(),
+	}, m)
+
+	// Every configured key signs, so clients trusting either key accept the manifest
+	require.Len(t, sigs, 2)
+	for i, sig := range sigs {
+		assert.Equal(t, signer.keys[i].id, sig.KeyID)
+		raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+		require.NoError(t, err)
+		pub := signer.keys[i].privateKey.Public().(ed25519.PublicKey)
+		assert.True(t, ed25519.Verify(pub, payload, raw))
+		assert.False(t, ed25519.Verify(pub, append(payload, ' '), raw))
+	}
+}
+
+func TestManifestVerifiable(t *testing.T) {
+	asset := &UpdateAsset{Platform: "android", Format: "apk", URL: "https://dl/app.apk", Size: 42, SHA256: strings.Repeat("ab", 32)}
+	assert.True(t, manifestVerifiable(CheckUpdateResponse{HasUpdate: true, Asset: asset}))
+	// The release page, or an asset whose digest is unknown, is not signed
+	assert.False(t, manifestVerifiable(CheckUpdateResponse{HasUpdate: true, DownloadURL: "https://github.com/releases"}))
+	assert.False(t, manifestVerifiable(CheckUpdateResponse{HasUpdate: true, Asset: &UpdateAsset{URL: "https://dl/app.apk", Size: 42}}))
+	assert.False(t, manifestVerifiable(CheckUpdateResponse{HasUpdate: true, Asset: &UpdateAsset{URL: "https://dl/app.apk", SHA256: strings.Repeat("ab", 32)}}))
+}
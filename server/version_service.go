@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/subtle"
 	"net/http"
 	"os"
 	"strconv"
@@ -28,13 +29,11 @@ func (s *VersionService) UpdateVersion(c *gin.Context) {
 			auth := c.GetHeader("Authorization")
 			if strings.HasPrefix(auth, "Bearer ") {
 				provided = strings.TrimPrefix(auth, "Bearer ")
-			} else {
-				// Fallback to query param token
-				provided = c.Query("token")
 			}
 		}
 
-		if provided == "" || provided != secret {
+		// The secret is only accepted from headers: query strings end up in access logs
+		if provided == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) != 1 {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized: invalid webhook secret"})
 			return
 		}
@@ -45,44 +44,42 @@ func (s *VersionService) UpdateVersion(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	platforms, err := parsePlatformSet(req.Platforms)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	assets, err := buildReleaseAssets(req.Assets)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	translations, err := buildReleaseNoteTranslations(req.ReleaseNotesI18n)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// Start transaction
-	// Start transaction (GORM)
+	rel := releaseUpsert{
+		Version:            req.Version,
+		BuildNumber:        req.BuildNumber,
+		ReleaseNotes:       req.ReleaseNotes,
+		DownloadURL:        req.DownloadURL,
+		AndroidDownloadURL: req.AndroidDownloadURL,
+		IsBeta:             inferBeta(req.Version),
+	}
+	// Keep the previous targeting when the payload does not list platforms
+	if req.Platforms != nil {
+		rel.Platforms = &platforms
+	}
+	if req.Assets != nil {
+		rel.Assets = &assets
+	}
+	if len(translations) > 0 {
+		rel.ReleaseNotesI18n = translations
+	}
 	if err := s.db.Transaction(func(tx *gorm.DB) error {
-		// Set all existing versions to not latest
-		if err := tx.Model(&AppVersion{}).Where("is_latest = ?", true).Update("is_latest", false).Error; err != nil {
-			return err
-		}
-
-		var existing AppVersion
-		err := tx.Where("version = ?", req.Version).First(&existing).Error
-		isBeta := inferBeta(req.Version)
-
-		if err == gorm.ErrRecordNotFound {
-			// Insert new version
-			v := AppVersion{
-				Version:            req.Version,
-				ReleaseNotes:       req.ReleaseNotes,
-				DownloadURL:        req.DownloadURL,
-				AndroidDownloadURL: req.AndroidDownloadURL,
-				IsLatest:           true,
-				IsBeta:             isBeta,
-				CreatedAt:          nowUTC(),
-				UpdatedAt:          nowUTC(),
-			}
-			return tx.Create(&v).Error
-		} else if err != nil {
-			return err
-		} else {
-			// Update existing version
-			existing.ReleaseNotes = req.ReleaseNotes
-			existing.DownloadURL = req.DownloadURL
-			existing.AndroidDownloadURL = req.AndroidDownloadURL
-			existing.IsLatest = true
-			existing.IsBeta = isBeta
-			existing.UpdatedAt = nowUTC()
-			return tx.Save(&existing).Error
-		}
+		return upsertRelease(tx, rel)
 	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update version"})
 		return
@@ -94,6 +91,96 @@ func (s *VersionService) UpdateVersion(c *gin.Context) {
 	})
 }
 
+// releaseUpsert describes a release announced by one of the webhooks.
+// Nil pointer fields leave the stored value of an existing release untouched.
+type releaseUpsert struct {
+	Version            string
+	BuildNumber        *int
+	ReleaseNotes       string
+	DownloadURL        string
+	AndroidDownloadURL string
+	IsBeta             bool
+	IsPublished        *bool
+	Platforms          *PlatformSet
+	Assets             *[]ReleaseAsset
+	// ReleaseNotesI18n is merged into the stored translations
+	ReleaseNotesI18n map[string]string
+}
+
+// upsertRelease creates or updates the release. A soft-deleted release with the
+// same version is restored.
+func upsertRelease(tx *gorm.DB, rel releaseUpsert) error {
+	published := rel.IsPublished == nil || *rel.IsPublished
+
+	var existing AppVersion
+	err := tx.Unscoped().Where("version = ?", rel.Version).First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		// Insert new version
+		v := AppVersion{
+			Version:            rel.Version,
+			ReleaseNotes:       rel.ReleaseNotes,
+			DownloadURL:        rel.DownloadURL,
+			AndroidDownloadURL: rel.AndroidDownloadURL,
+			IsBeta:             rel.IsBeta,
+			IsPublished:        published,
+			CreatedAt:          nowUTC(),
+			UpdatedAt:          nowUTC(),
+		}
+		if rel.BuildNumber != nil {
+			v.BuildNumber = *rel.BuildNumber
+		}
+		if rel.Platforms != nil {
+			v.Platforms = *rel.Platforms
+		}
+		if err := tx.Create(&v).Error; err != nil {
+			return err
+		}
+		if !published {
+			// is_published defaults to true in the database, so a false value must be written explicitly
+			if err := tx.Model(&v).Update("is_published", false).Error; err != nil {
+				return err
+			}
+		}
+		return applyReleaseChildren(tx, v.ID, rel)
+	} else if err != nil {
+		return err
+	}
+
+	// Update existing version
+	existing.ReleaseNotes = rel.ReleaseNotes
+	existing.DownloadURL = rel.DownloadURL
+	existing.AndroidDownloadURL = rel.AndroidDownloadURL
+	existing.IsBeta = rel.IsBeta
+	if rel.IsPublished != nil {
+		existing.IsPublished = *rel.IsPublished
+	}
+	if rel.BuildNumber != nil {
+		existing.BuildNumber = *rel.BuildNumber
+	}
+	if rel.Platforms != nil {
+		existing.Platforms = *rel.Platforms
+	}
+	existing.DeletedAt = gorm.DeletedAt{}
+	existing.UpdatedAt = nowUTC()
+	if err := tx.Unscoped().Save(&existing).Error; err != nil {
+		return err
+	}
+	return applyReleaseChildren(tx, existing.ID, rel)
+}
+
+// applyReleaseChildren stores the assets and translated notes of an upserted release.
+func applyReleaseChildren(tx *gorm.DB, versionID int, rel releaseUpsert) error {
+	if rel.Assets != nil {
+		if err := replaceReleaseAssets(tx, versionID, *rel.Assets); err != nil {
+			return err
+		}
+	}
+	if len(rel.ReleaseNotesI18n) > 0 {
+		return mergeReleaseNotes(tx, versionID, rel.ReleaseNotesI18n)
+	}
+	return nil
+}
+
 func inferBeta(version string) bool {
 	v := strings.ToLower(version)
 	return strings.Contains(v, "-") || strings.Contains(v, "alpha") || strings.Contains(v, "beta") || strings.Contains(v, "rc") || strings.Contains(v, "preview") || strings.Contains(v, "pre")
@@ -118,12 +205,59 @@ func (s *VersionService) AdminListVersions(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
 		return
 	}
+	published, err := loadPublishedVersions(s.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
+		return
+	}
+	if err := attachReleaseAssets(s.db, versions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch release assets"})
+		return
+	}
+	if err := attachReleaseNotes(s.db, versions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch release notes"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"items": versions,
 		"total": total,
+		"latest": gin.H{
+			"stable": latestVersionsByPlatform(published, false),
+			"beta":   latestVersionsByPlatform(published, true),
+		},
 	})
 }
 
+// loadPublishedVersions returns all published releases, newest version first,
+// in the order of loadPlatformReleases: the latest release of a platform is the
+// same for both.
+func loadPublishedVersions(db *gorm.DB) ([]AppVersion, error) {
+	var versions []AppVersion
+	if err := db.Where("is_published = ?", true).Order(versionOrderSQL).Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	sortVersionsDesc(versions)
+	return versions, nil
+}
+
+// latestVersionsByPlatform maps each known platform to the newest published
+// release available on it, ignoring staged rollout. versions must be ordered
+// newest first, as returned by loadPublishedVersions.
+func latestVersionsByPlatform(versions []AppVersion, includeBeta bool) map[string]string {
+	latest := make(map[string]string, len(knownPlatforms))
+	for _, v := range versions {
+		if v.IsBeta && !includeBeta {
+			continue
+		}
+		for _, p := range v.Platforms.Expand() {
+			if _, ok := latest[p]; !ok {
+				latest[p] = v.Version
+			}
+		}
+	}
+	return latest
+}
+
 func (s *VersionService) AdminUpdateVersion(c *gin.Context) {
 	id := c.Param("id")
 	var req AdminUpdateVersionRequest
@@ -131,6 +265,39 @@ func (s *VersionService) AdminUpdateVersion(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.RolloutPercent != nil && (*req.RolloutPercent < 0 || *req.RolloutPercent > 100) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rollout_percent must be between 0 and 100"})
+		return
+	}
+	if req.RolloutRampHours != nil && *req.RolloutRampHours < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rollout_ramp_hours must not be negative"})
+		return
+	}
+	if req.BuildNumber != nil && *req.BuildNumber < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "build_number must not be negative"})
+		return
+	}
+	var platforms PlatformSet
+	if req.Platforms != nil {
+		var err error
+		if platforms, err = parsePlatformSet(*req.Platforms); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var assets []ReleaseAsset
+	if req.Assets != nil {
+		var err error
+		if assets, err = buildReleaseAssets(*req.Assets); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	translations, err := buildReleaseNoteTranslations(req.ReleaseNotesI18n)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	if err := s.db.Transaction(func(tx *gorm.DB) error {
 		var v AppVersion
@@ -138,6 +305,9 @@ func (s *VersionService) AdminUpdateVersion(c *gin.Context) {
 			return err
 		}
 
+		if req.BuildNumber != nil {
+			v.BuildNumber = *req.BuildNumber
+		}
 		if req.ReleaseNotes != nil {
 			v.ReleaseNotes = *req.ReleaseNotes
 		}
@@ -153,19 +323,21 @@ func (s *VersionService) AdminUpdateVersion(c *gin.Context) {
 		if req.IsPublished != nil {
 			v.IsPublished = *req.IsPublished
 		}
+		if req.Platforms != nil {
+			v.Platforms = platforms
+		}
+		applyRolloutUpdate(&v, req, nowUTC())
 
-		if req.IsLatest != nil && *req.IsLatest {
-			// Unset other latest
-			if err := tx.Model(&AppVersion{}).Where("is_latest = ?", true).Update("is_latest", false).Error; err != nil {
+		v.UpdatedAt = nowUTC()
+		if err := tx.Save(&v).Error; err != nil {
+			return err
+		}
+		if req.Assets != nil {
+			if err := replaceReleaseAssets(tx, v.ID, assets); err != nil {
 				return err
 			}
-			v.IsLatest = true
-		} else if req.IsLatest != nil && !*req.IsLatest {
-			v.IsLatest = false
 		}
-
-		v.UpdatedAt = nowUTC()
-		return tx.Save(&v).Error
+		return mergeReleaseNotes(tx, v.ID, translations)
 	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update version: " + err.Error()})
 		return
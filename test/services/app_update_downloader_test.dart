@@ -64,5 +64,46 @@ void main() {
 
       expect(ranked.map((item) => item.url), candidates);
     });
+
+    test('drops mirrors whose file size differs from the release', () {
+      final downloader = AppUpdateDownloader.instance;
+      final candidates = <String>[
+        'https://github.com/JustLookAtNow/pt_mate/releases/download/v1/app.apk',
+        'https://gh-proxy.com/https://github.com/JustLookAtNow/pt_mate/releases/download/v1/app.apk',
+        'https://ghproxy.net/https://github.com/JustLookAtNow/pt_mate/releases/download/v1/app.apk',
+      ];
+
+      final ranked = downloader.rankCandidatesForTesting(candidates, {
+        candidates[0]: const MirrorProbeResult(
+          isAvailable: true,
+          latencyMs: 150,
+          bytesReceived: 900000,
+          elapsedMs: 3000,
+          contentLength: 12000000,
+        ),
+        candidates[1]: const MirrorProbeResult(
+          isAvailable: true,
+          latencyMs: 80,
+          bytesReceived: 950000,
+          elapsedMs: 3000,
+          contentLength: 11000000,
+        ),
+        candidates[2]: const MirrorProbeResult(
+          isAvailable: true,
+          latencyMs: 90,
+          bytesReceived: 100000,
+          elapsedMs: 3000,
+          contentLength: null,
+        ),
+      });
+
+      expect(
+        downloader
+            .filterCandidatesBySize(ranked, 12000000)
+            .map((item) => item.url),
+        [candidates[0], candidates[2]],
+      );
+      expect(downloader.filterCandidatesBySize(ranked, null), ranked);
+    });
   });
 }
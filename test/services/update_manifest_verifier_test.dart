@@ -0,0 +1,126 @@
+import 'package:flutter_test/flutter_test.dart';
+import 'package:pt_mate/services/update_manifest_verifier.dart';
+
+void main() {
+  // Signed by the server with the Ed25519 seed "a" * 32
+  const publicKey = 'rwaj4ykXFOTzVsGcmxXNGVHsbmZiqne+B1R/KJODNB0=';
+  const manifest =
+      'eyJ2ZXJzaW9uIjoiMi4zMC4wIiwicGxhdGZvcm0iOiJhbmRyb2lkIiwiYXJjaCI6ImFybTY0IiwiZm9ybWF0IjoiYXBrIiwiZG93bmxvYWRfdXJsIjoiaHR0cHM6Ly9kbC9hcHAuYXBrIiwic2l6ZSI6NDIsInNoYTI1NiI6ImFiYWJhYmFiYWJhYmFiYWJhYmFiYWJhYmFiYWJhYmFiYWJhYmFiYWJhYmFiYWJhYmFiYWJhYmFiYWJhYmFiYWIiLCJmb3JjZV91cGRhdGUiOmZhbHNlLCJpc3N1ZWRfYXQiOjE3Nzc2MzY4MDB9';
+  const signature =
+      'M6PfYId1ZtQq401HFed9ptSyjqwYuECo1WS0N0pVHs+mUlkzDyxa1EfsVr8M6vuh2WpkjeaPhA69R6N8TPhJDA==';
+
+  group('UpdateManifestVerifier', () {
+    test('is disabled without trusted keys', () {
+      expect(UpdateManifestVerifier.fromSpec('').isEnabled, isFalse);
+      expect(UpdateManifestVerifier.fromSpec('k1:not-a-key').isEnabled, isFalse);
+    });
+
+    test('accepts a manifest signed by any trusted key', () {
+      final verifier = UpdateManifestVerifier.fromSpec(
+        'next:${'A' * 43}=,current:$publicKey',
+      );
+
+      final result = verifier.verify(manifest, [
+        {'key_id': 'retired', 'signature': signature},
+        {'key_id': 'current', 'signature': signature},
+      ]);
+
+      expect(result.version, '2.30.0');
+      expect(result.platform, 'android');
+      expect(result.format, 'apk');
+      expect(result.downloadUrl, 'https://dl/app.apk');
+      expect(result.size, 42);
+      expect(result.sha256, 'ab' * 32);
+      expect(result.issuedAt, DateTime.utc(2026, 5, 1, 12));
+    });
+
+    test('rejects unknown keys, bad signatures and missing manifests', () {
+      final verifier = UpdateManifestVerifier.fromSpec('current:$publicKey');
+
+      expect(
+        () => verifier.verify(manifest, [
+          {'key_id': 'other', 'signature': signature},
+        ]),
+        throwsA(isA<UpdateManifestException>()),
+      );
+      expect(
+        () => verifier.verify('${manifest}AA', [
+          {'key_id': 'current', 'signature': signature},
+        ]),
+        throwsA(isA<UpdateManifestException>()),
+      );
+      expect(
+        () => verifier.verify(null, const []),
+        throwsA(isA<UpdateManifestException>()),
+      );
+    });
+  });
+
+  group('UpdateManifest.ensureApplicable', () {
+    final issuedAt = DateTime.utc(2026, 5, 1, 12);
+    UpdateManifest manifestWith({
+      String platform = 'android',
+      int? size = 42,
+      String? sha256,
+    }) {
+      return UpdateManifest(
+        version: '2.30.0',
+        platform: platform,
+        format: 'apk',
+        downloadUrl: 'https://dl/app.apk',
+        size: size,
+        sha256: sha256 ?? 'ab' * 32,
+        issuedAt: issuedAt,
+      );
+    }
+
+    test('accepts a fresh manifest of this platform', () {
+      manifestWith().ensureApplicable(
+        platform: 'Android',
+        now: issuedAt.add(const Duration(minutes: 5)),
+      );
+    });
+
+    test('rejects manifests without digest or size', () {
+      final now = issuedAt.add(const Duration(minutes: 5));
+      expect(
+        () => manifestWith(sha256: '').ensureApplicable(
+          platform: 'android',
+          now: now,
+        ),
+        throwsA(isA<UpdateManifestException>()),
+      );
+      expect(
+        () => manifestWith(size: null).ensureApplicable(
+          platform: 'android',
+          now: now,
+        ),
+        throwsA(isA<UpdateManifestException>()),
+      );
+    });
+
+    test('rejects other platforms and replayed manifests', () {
+      expect(
+        () => manifestWith().ensureApplicable(
+          platform: 'windows',
+          now: issuedAt,
+        ),
+        throwsA(isA<UpdateManifestException>()),
+      );
+      expect(
+        () => manifestWith().ensureApplicable(
+          platform: 'android',
+          now: issuedAt.add(const Duration(days: 2)),
+        ),
+        throwsA(isA<UpdateManifestException>()),
+      );
+      expect(
+        () => manifestWith().ensureApplicable(
+          platform: 'android',
+          now: issuedAt.subtract(const Duration(hours: 2)),
+        ),
+        throwsA(isA<UpdateManifestException>()),
+      );
+    });
+  });
+}